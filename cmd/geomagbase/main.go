@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ozym/geomag/internal/baseline"
+	"github.com/ozym/geomag/internal/raw"
+)
+
+const timeFormat = "2006-01-02T15:04:05"
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Apply baselines fitted to absolute observations to stored geomag variometer files\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] <observation files>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var verbose bool
+	flag.BoolVar(&verbose, "verbose", false, "make noise")
+
+	var config string
+	flag.StringVar(&config, "config", "", "yaml or json file of station baseline settings")
+
+	var base string
+	flag.StringVar(&base, "base", ".", "base directory")
+
+	var path string
+	flag.StringVar(&path, "path", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.csv", "variometer file name template")
+
+	var adjpath string
+	flag.StringVar(&adjpath, "adjpath", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.adj.csv", "adjusted file name template")
+
+	var qdpath string
+	flag.StringVar(&qdpath, "qdpath", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.qd.csv", "quasi-definitive file name template")
+
+	var baselinepath string
+	flag.StringVar(&baselinepath, "baselinepath", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.baseline.csv", "baseline value file name template")
+
+	var truncate time.Duration
+	flag.DurationVar(&truncate, "truncate", time.Hour, "time interval files are split into")
+
+	var tolerance time.Duration
+	flag.DurationVar(&tolerance, "tolerance", time.Minute, "maximum time between an observation and the variometer reading used")
+
+	var dp int
+	flag.IntVar(&dp, "dp", 2, "number of decimal places for adjusted and baseline values")
+
+	var starttime string
+	flag.StringVar(&starttime, "starttime", "", "optional time to adjust from, the start of the previous day if empty")
+
+	var endtime string
+	flag.StringVar(&endtime, "endtime", "", "optional time to adjust to, a day after the start time if empty")
+
+	flag.Parse()
+
+	if config == "" {
+		log.Fatalf("a baseline config file must be given")
+	}
+
+	table, err := baseline.LoadTable(config)
+	if err != nil {
+		log.Fatalf("unable to load baseline config %s: %v", config, err)
+	}
+
+	st := time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)
+	if starttime != "" {
+		if st, err = time.Parse(timeFormat, starttime); err != nil {
+			log.Fatalf("invalid starttime %s: %v", starttime, err)
+		}
+	}
+
+	et := st.Add(24 * time.Hour)
+	if endtime != "" {
+		if et, err = time.Parse(timeFormat, endtime); err != nil {
+			log.Fatalf("invalid endtime %s: %v", endtime, err)
+		}
+	}
+
+	var observations []baseline.Observation
+	for _, arg := range flag.Args() {
+		files, err := filepath.Glob(arg)
+		if err != nil {
+			log.Fatalf("invalid observation file pattern %s: %v", arg, err)
+		}
+		for _, f := range files {
+			list, err := baseline.LoadObservations(f)
+			if err != nil {
+				log.Fatalf("unable to load observations: %v", err)
+			}
+			observations = append(observations, list...)
+		}
+	}
+	observations = baseline.Expand(observations)
+
+	if verbose {
+		log.Printf("loaded %d absolute observations", len(observations))
+	}
+
+	for _, station := range table {
+		for _, code := range station.Codes() {
+			label := station.Channel(code)
+
+			// the variometer readings around each observation
+			var readings []raw.Reading
+			for _, o := range observations {
+				if o.Station != station.Station || o.Component != code {
+					continue
+				}
+				r, err := raw.Load(base, path, truncate, label, o.Timestamp.Add(-tolerance), o.Timestamp.Add(tolerance+time.Second))
+				if err != nil {
+					log.Fatalf("unable to load %s: %v", label, err)
+				}
+				readings = append(readings, r.Readings...)
+			}
+
+			points := baseline.Points(observations, station.Station, code, readings, tolerance)
+
+			curve, err := baseline.Fit(station.Method(), points)
+			if err != nil {
+				log.Printf("skipping %s %s, unable to fit baseline: %v", station.Station, code, err)
+				continue
+			}
+
+			if verbose {
+				for _, p := range curve.Points {
+					log.Printf("baseline %s %s %s %g", station.Station, code, p.Timestamp.Format(time.RFC3339), p.Value)
+				}
+			}
+
+			variometer, err := raw.Load(base, path, truncate, label, st, et)
+			if err != nil {
+				log.Fatalf("unable to load %s: %v", label, err)
+			}
+
+			adjusted, definitive, values := baseline.Apply(label, curve, variometer.Readings, dp)
+
+			for _, s := range []struct {
+				r    *raw.Raw
+				path string
+			}{
+				{adjusted, adjpath},
+				{definitive, qdpath},
+				{values, baselinepath},
+			} {
+				if !(len(s.r.Readings) > 0) || strings.TrimSpace(s.path) == "" {
+					continue
+				}
+				if err := s.r.Store(base, s.path, truncate); err != nil {
+					log.Fatalf("unable to store %s: %v", label, err)
+				}
+			}
+		}
+	}
+}
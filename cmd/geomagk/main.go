@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozym/geomag/internal/kindex"
+	"github.com/ozym/geomag/internal/raw"
+)
+
+const timeFormat = "2006-01-02T15:04:05"
+
+// Result holds the K indices of a single station.
+type Result struct {
+	Station string         `json:"station"`
+	Method  string         `json:"method"`
+	K9      float64        `json:"k9"`
+	Indices []kindex.Index `json:"indices"`
+}
+
+// WriteText writes a line per day of K indices along with the daily sum, missing indices are given as a dash.
+func (r Result) WriteText(wr io.Writer) error {
+	perDay := int(24 * time.Hour / kindex.Interval)
+
+	for i := 0; i < len(r.Indices); i += perDay {
+		var values []string
+		var sum int
+		for j := i; j < i+perDay && j < len(r.Indices); j++ {
+			switch k := r.Indices[j].K; k {
+			case kindex.Missing:
+				values = append(values, "-")
+			default:
+				values = append(values, strconv.Itoa(k))
+				sum += k
+			}
+		}
+		if _, err := fmt.Fprintf(wr, "%s %s %s k9=%g %s sum=%d\n", r.Station, r.Indices[i].Start.Format("2006-01-02"),
+			r.Method, r.K9, strings.Join(values, " "), sum); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Compute three hourly K indices from stored geomag one-minute horizontal component files\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var verbose bool
+	flag.BoolVar(&verbose, "verbose", false, "make noise")
+
+	var config string
+	flag.StringVar(&config, "config", "", "yaml or json file of station k index settings")
+
+	var stations string
+	flag.StringVar(&stations, "stations", "", "optional comma delimited station labels, all configured stations if empty")
+
+	var base string
+	flag.StringVar(&base, "base", ".", "base directory")
+
+	var path string
+	flag.StringVar(&path, "path", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.min.csv", "one-minute file name template")
+
+	var truncate time.Duration
+	flag.DurationVar(&truncate, "truncate", time.Hour, "time interval files are split into")
+
+	var starttime string
+	flag.StringVar(&starttime, "starttime", "", "optional time to compute from, the start of the previous day if empty")
+
+	var endtime string
+	flag.StringVar(&endtime, "endtime", "", "optional time to compute to, a day after the start time if empty")
+
+	var asJSON bool
+	flag.BoolVar(&asJSON, "json", false, "output the indices as json rather than text")
+
+	flag.Parse()
+
+	if config == "" {
+		log.Fatalf("a k index config file must be given")
+	}
+
+	table, err := kindex.LoadTable(config)
+	if err != nil {
+		log.Fatalf("unable to load k index config %s: %v", config, err)
+	}
+
+	st := time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)
+	if starttime != "" {
+		if st, err = time.Parse(timeFormat, starttime); err != nil {
+			log.Fatalf("invalid starttime %s: %v", starttime, err)
+		}
+	}
+	st = st.Truncate(kindex.Interval)
+
+	et := st.Add(24 * time.Hour)
+	if endtime != "" {
+		if et, err = time.Parse(timeFormat, endtime); err != nil {
+			log.Fatalf("invalid endtime %s: %v", endtime, err)
+		}
+	}
+
+	var list []kindex.Station
+	switch stations {
+	case "":
+		list = table
+	default:
+		for _, s := range strings.Split(stations, ",") {
+			station, ok := table.Find(strings.TrimSpace(s))
+			if !ok {
+				log.Fatalf("unknown station %s", s)
+			}
+			list = append(list, station)
+		}
+	}
+
+	// the daily variation is estimated with an extra day either side
+	start, end := st.Add(-24*time.Hour), et.Add(24*time.Hour)
+
+	results := []Result{}
+	for _, station := range list {
+		first, second := station.Channels()
+
+		var series []kindex.Series
+		for _, label := range []string{first, second} {
+			if verbose {
+				log.Printf("loading %s from %v to %v", label, start, end)
+			}
+			r, err := raw.Load(base, path, truncate, label, start, end)
+			if err != nil {
+				log.Fatalf("unable to load %s: %v", label, err)
+			}
+			series = append(series, kindex.NewSeries(start, end, r.Readings))
+		}
+
+		result := Result{
+			Station: station.Station,
+			Method:  station.Method,
+			K9:      station.K9,
+		}
+		if result.Method == "" {
+			result.Method = kindex.MethodFMI
+		}
+
+		for _, v := range station.Indices(series[0], series[1]) {
+			if v.Start.Before(st) || !v.Start.Before(et) {
+				continue
+			}
+			result.Indices = append(result.Indices, v)
+		}
+
+		if !asJSON {
+			if err := result.WriteText(os.Stdout); err != nil {
+				log.Fatalf("unable to write indices: %v", err)
+			}
+			continue
+		}
+
+		results = append(results, result)
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Fatalf("unable to encode indices: %v", err)
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ozym/geomag/internal/filter"
+	"github.com/ozym/geomag/internal/raw"
+)
+
+const timeFormat = "2006-01-02T15:04:05"
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Build hourly and daily mean files from stored geomag raw csv files\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options] <label> [<label> ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var verbose bool
+	flag.BoolVar(&verbose, "verbose", false, "make noise")
+
+	var base string
+	flag.StringVar(&base, "base", ".", "base directory")
+
+	var path string
+	flag.StringVar(&path, "path", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.csv", "raw csv file name template")
+
+	var truncate time.Duration
+	flag.DurationVar(&truncate, "truncate", time.Hour, "time interval files are split into")
+
+	var period time.Duration
+	flag.DurationVar(&period, "period", 0, "nominal sample period, estimated from the readings if zero")
+
+	var starttime string
+	flag.StringVar(&starttime, "starttime", "", "optional time to compute from, the start of the previous day if empty")
+
+	var endtime string
+	flag.StringVar(&endtime, "endtime", "", "optional time to compute to, a day after the start time if empty")
+
+	var hourly bool
+	flag.BoolVar(&hourly, "hourly", true, "build hourly mean files")
+
+	var hourpath string
+	flag.StringVar(&hourpath, "hourpath", "{{year}}/{{year}}.{{yearday}}.{{toupper .Label}}.hour.csv", "hourly mean file name template")
+
+	var daily bool
+	flag.BoolVar(&daily, "daily", true, "build daily mean files")
+
+	var daypath string
+	flag.StringVar(&daypath, "daypath", "{{year}}/{{year}}.{{toupper .Label}}.day.csv", "daily mean file name template")
+
+	var coverage float64
+	flag.Float64Var(&coverage, "coverage", filter.DefaultCoverage, "minimum fraction of samples needed for a mean")
+
+	var dp int
+	flag.IntVar(&dp, "dp", -1, "number of decimal places for means, negative for the default")
+
+	var format string
+	flag.StringVar(&format, "format", "csv", "mean file format, either csv, station or iaga2002")
+
+	var timeformat string
+	flag.StringVar(&timeformat, "timeformat", raw.TimeSecond, "precision of csv timestamps, either second, millisecond or microsecond")
+
+	var header string
+	flag.StringVar(&header, "header", "", "optional json file of iaga2002 station headers")
+
+	flag.Parse()
+
+	labels := flag.Args()
+	if !(len(labels) > 0) {
+		flag.Usage()
+		fmt.Fprintf(os.Stderr, "Missing channel labels\n")
+		os.Exit(1)
+	}
+
+	if !raw.ValidFormat(format) {
+		log.Fatalf("unknown mean file format: %s", format)
+	}
+
+	if !raw.ValidTimeFormat(timeformat) {
+		log.Fatalf("unknown time format: %s", timeformat)
+	}
+
+	var headers map[string]raw.Header
+	if header != "" {
+		h, err := raw.LoadHeaders(header)
+		if err != nil {
+			log.Fatalf("unable to load station headers %s: %v", header, err)
+		}
+		headers = h
+	}
+
+	var err error
+
+	st := time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)
+	if starttime != "" {
+		if st, err = time.Parse(timeFormat, starttime); err != nil {
+			log.Fatalf("invalid starttime %s: %v", starttime, err)
+		}
+	}
+
+	et := st.Add(24 * time.Hour)
+	if endtime != "" {
+		if et, err = time.Parse(timeFormat, endtime); err != nil {
+			log.Fatalf("invalid endtime %s: %v", endtime, err)
+		}
+	}
+
+	for _, p := range []struct {
+		enabled  bool
+		interval time.Duration
+		path     string
+	}{
+		{hourly, time.Hour, hourpath},
+		{daily, 24 * time.Hour, daypath},
+	} {
+		if !p.enabled {
+			continue
+		}
+
+		// means need whole intervals, so the readings are loaded for each one spanned
+		start, end := st.Truncate(p.interval), et.Add(p.interval-1).Truncate(p.interval)
+
+		var means []*raw.Raw
+		for _, label := range labels {
+			label = strings.TrimSpace(label)
+
+			if verbose {
+				log.Printf("loading %s from %v to %v", label, start, end)
+			}
+			r, err := raw.Load(base, path, truncate, label, start, end)
+			if err != nil {
+				log.Fatalf("unable to load %s: %v", label, err)
+			}
+
+			dt := period
+			if dt == 0 {
+				dt = r.Spacing()
+			}
+			if !(dt > 0) {
+				log.Printf("skipping %s, not enough readings to estimate the sample period", label)
+				continue
+			}
+
+			m := filter.Means(r, dt, p.interval, coverage, filter.Precision(dp))
+			if verbose {
+				log.Printf("built %d %s means for %s", len(m.Readings), p.interval, label)
+			}
+			if len(m.Readings) > 0 {
+				means = append(means, m)
+			}
+		}
+
+		if err := raw.StoreFormat(format, timeformat, means, headers, nil, base, p.path, truncate); err != nil {
+			log.Fatalf("unable to store means: %v", err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ozym/geomag/internal/qc"
+	"github.com/ozym/geomag/internal/raw"
+)
+
+const timeFormat = "2006-01-02T15:04:05"
+
+// readFile decodes the readings of a stored raw file, grouped by label. Station and IAGA-2002
+// files are grouped by their channel codes or reported components, as used for their columns.
+func readFile(format, path string) (map[string][]raw.Reading, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch format {
+	case "station":
+		var s raw.Station
+		if err := s.Decode(file); err != nil {
+			return nil, err
+		}
+		return s.Readings, nil
+	case "iaga2002":
+		var g raw.IAGA
+		if err := g.Decode(file); err != nil {
+			return nil, err
+		}
+		return g.Readings, nil
+	}
+
+	var r raw.Raw
+	if err := r.Decode(file); err != nil {
+		return nil, err
+	}
+
+	readings := make(map[string][]raw.Reading)
+	for _, v := range r.Readings {
+		readings[v.Label] = append(readings[v.Label], v)
+	}
+
+	return readings, nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Report gaps, overlaps, duplicates and timing jitter in stored geomag raw files\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "General Options:\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	var verbose bool
+	flag.BoolVar(&verbose, "verbose", false, "make noise")
+
+	var base string
+	flag.StringVar(&base, "base", ".", "base directory")
+
+	var path string
+	flag.StringVar(&path, "path", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.csv", "file name template")
+
+	var format string
+	flag.StringVar(&format, "format", "csv", "raw file format, either csv, station or iaga2002")
+
+	var truncate time.Duration
+	flag.DurationVar(&truncate, "truncate", time.Hour, "time interval files are split into")
+
+	var period time.Duration
+	flag.DurationVar(&period, "period", 0, "nominal sample period, estimated from the readings if zero")
+
+	var tolerance time.Duration
+	flag.DurationVar(&tolerance, "tolerance", 0, "timing jitter tolerance, a tenth of the sample period if zero")
+
+	var starttime string
+	flag.StringVar(&starttime, "starttime", "", "optional time to report from")
+
+	var endtime string
+	flag.StringVar(&endtime, "endtime", "", "optional time to report to")
+
+	var problems bool
+	flag.BoolVar(&problems, "problems", false, "only report files with problems")
+
+	var asJSON bool
+	flag.BoolVar(&asJSON, "json", false, "output the report as json rather than text")
+
+	flag.Parse()
+
+	if !raw.ValidFormat(format) {
+		log.Fatalf("unknown raw file format: %s", format)
+	}
+
+	var err error
+	var st, et time.Time
+	if starttime != "" {
+		if st, err = time.Parse(timeFormat, starttime); err != nil {
+			log.Fatalf("invalid starttime %s: %v", starttime, err)
+		}
+	}
+	if endtime != "" {
+		if et, err = time.Parse(timeFormat, endtime); err != nil {
+			log.Fatalf("invalid endtime %s: %v", endtime, err)
+		}
+	}
+
+	glob, err := raw.Glob(path)
+	if err != nil {
+		log.Fatalf("invalid file name template %s: %v", path, err)
+	}
+
+	pattern, err := raw.Pattern(path)
+	if err != nil {
+		log.Fatalf("invalid file name template %s: %v", path, err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(base, glob))
+	if err != nil {
+		log.Fatalf("unable to find files matching %s: %v", glob, err)
+	}
+
+	// the glob also matches derived files, e.g. one-minute or resampled values, with the same labels
+	var files []string
+	for _, f := range matches {
+		if name, err := filepath.Rel(base, f); err == nil && pattern.MatchString(filepath.ToSlash(name)) {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+
+	if verbose {
+		log.Printf("checking %d files matching %s", len(files), glob)
+	}
+
+	now := time.Now().UTC()
+
+	reports := []qc.Report{}
+	for _, f := range files {
+		readings, err := readFile(format, f)
+		if err != nil {
+			log.Printf("skipping file, unable to read %s: %v", f, err)
+			continue
+		}
+
+		name, err := filepath.Rel(base, f)
+		if err != nil {
+			name = f
+		}
+
+		var labels []string
+		for k := range readings {
+			labels = append(labels, k)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			first, last := readings[label][0].Timestamp, readings[label][0].Timestamp
+			for _, v := range readings[label] {
+				if v.Timestamp.Before(first) {
+					first = v.Timestamp
+				}
+				if v.Timestamp.After(last) {
+					last = v.Timestamp
+				}
+			}
+
+			start, end := first.Truncate(truncate), last.Truncate(truncate).Add(truncate)
+			if end.After(now) {
+				end = now
+			}
+			if (!st.IsZero() && !end.After(st)) || (!et.IsZero() && !start.Before(et)) {
+				continue
+			}
+
+			report := qc.Analyse(label, readings[label], start, end, period, tolerance)
+			report.File = name
+
+			if problems && report.OK() {
+				continue
+			}
+
+			if !asJSON {
+				if err := report.WriteText(os.Stdout); err != nil {
+					log.Fatalf("unable to write report: %v", err)
+				}
+				continue
+			}
+
+			reports = append(reports, report)
+		}
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			log.Fatalf("unable to encode report: %v", err)
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+	}
+}
@@ -3,13 +3,18 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"time"
 
+	"github.com/ozym/geomag/internal/calib"
+	"github.com/ozym/geomag/internal/filter"
 	"github.com/ozym/geomag/internal/mseed"
+	"github.com/ozym/geomag/internal/qc"
 	"github.com/ozym/geomag/internal/raw"
+	"github.com/ozym/geomag/internal/resample"
+	"github.com/ozym/geomag/internal/rotate"
 )
 
 func main() {
@@ -22,6 +27,8 @@ func main() {
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "  %s [options] [options] <mseed ...>\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "A file name of \"-\" will read from standard input.\n")
+		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "General Options:\n")
 		fmt.Fprintf(os.Stderr, "\n")
 		flag.PrintDefaults()
@@ -38,11 +45,65 @@ func main() {
 	flag.StringVar(&path, "path", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.csv", "file name template")
 
 	var dp int
-	flag.IntVar(&dp, "dp", 0, "number of decimal places for raw data")
+	flag.IntVar(&dp, "dp", -1, "number of decimal places for raw data, negative to use the native sample type")
 
 	var gain float64
 	flag.Float64Var(&gain, "gain", 1.0, "gain to apply to raw data")
 
+	var calibration string
+	flag.StringVar(&calibration, "calibration", "", "optional yaml, json or csv file of per channel calibrations, overrides gain")
+
+	var qualityControl string
+	flag.StringVar(&qualityControl, "qc", "", "optional yaml or json file of per channel quality control checks, requires csv raw files for the flag column")
+
+	var resampling string
+	flag.StringVar(&resampling, "resample", "", "optional yaml or json file of per channel rules for resampling onto exact sample times")
+
+	var resamplepath string
+	flag.StringVar(&resamplepath, "resamplepath", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.res.csv", "resampled file name template")
+
+	var derivations string
+	flag.StringVar(&derivations, "derivations", "", "optional file to append a line to for each resampled reading describing how it was derived")
+
+	var rotation string
+	flag.StringVar(&rotation, "rotation", "", "optional yaml or json file of per station rotations into xyz, hdz or dif components")
+
+	var format string
+	flag.StringVar(&format, "format", "csv", "raw file format, either csv, station or iaga2002")
+
+	var timeformat string
+	flag.StringVar(&timeformat, "timeformat", raw.TimeSecond, "precision of raw csv timestamps, either second, millisecond or microsecond")
+
+	var header string
+	flag.StringVar(&header, "header", "", "optional json file of iaga2002 station headers")
+
+	var policy string
+	flag.StringVar(&policy, "merge", "new", "policy for readings that conflict with stored ones, either new, existing, quality or reject")
+
+	var conflicts string
+	flag.StringVar(&conflicts, "conflicts", "", "optional file to append a line to for each conflicting reading")
+
+	var minute bool
+	flag.BoolVar(&minute, "minute", false, "also build intermagnet one-minute filtered files from one-second data")
+
+	var minutepath string
+	flag.StringVar(&minutepath, "minutepath", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.min.csv", "one-minute file name template")
+
+	var hourly bool
+	flag.BoolVar(&hourly, "hourly", false, "also build hourly mean files, from one-minute values if built")
+
+	var hourpath string
+	flag.StringVar(&hourpath, "hourpath", "{{year}}/{{year}}.{{yearday}}.{{toupper .Label}}.hour.csv", "hourly mean file name template")
+
+	var daily bool
+	flag.BoolVar(&daily, "daily", false, "also build daily mean files, from one-minute values if built")
+
+	var daypath string
+	flag.StringVar(&daypath, "daypath", "{{year}}/{{year}}.{{toupper .Label}}.day.csv", "daily mean file name template")
+
+	var coverage float64
+	flag.Float64Var(&coverage, "coverage", filter.DefaultCoverage, "minimum fraction of samples needed for one-minute values and hourly or daily means")
+
 	flag.Parse()
 
 	fi, err := os.Stat(base)
@@ -53,57 +114,296 @@ func main() {
 		log.Fatalf("cannot write to base directory: %s: not a directory", base)
 	}
 
-	cache := make(map[string]*raw.Raw)
+	if !raw.ValidFormat(format) {
+		log.Fatalf("unknown raw file format: %s", format)
+	}
+
+	if !raw.ValidTimeFormat(timeformat) {
+		log.Fatalf("unknown raw time format: %s", timeformat)
+	}
 
-	msr := mseed.NewMSRecord()
-	defer mseed.FreeMSRecord(msr)
+	// rotated readings share the station files of the raw channels, and may need five components
+	if rotation != "" && format == "iaga2002" {
+		log.Fatalf("rotation requires csv or station raw files, not %s", format)
+	}
 
-	for _, f := range flag.Args() {
-		data, err := ioutil.ReadFile(f)
+	// only csv files have a flag column, the flags would otherwise be lost when stored
+	if qualityControl != "" && format != "csv" {
+		log.Fatalf("quality control requires csv raw files, not %s", format)
+	}
+
+	if !raw.ValidPolicy(policy) {
+		log.Fatalf("unknown merge policy: %s", policy)
+	}
+
+	merger := raw.NewMerger(raw.Policy(policy), nil)
+	if conflicts != "" {
+		file, err := os.OpenFile(conflicts, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			log.Fatalf("unable to read file %s: %v", f, err)
+			log.Fatalf("unable to open conflict log %s: %v", conflicts, err)
+		}
+		defer file.Close()
+
+		merger.Log = file
+	}
+
+	headers := make(map[string]raw.Header)
+	if header != "" {
+		h, err := raw.LoadHeaders(header)
+		if err != nil {
+			log.Fatalf("unable to load station headers %s: %v", header, err)
 		}
+		headers = h
+	}
+
+	var calibrations calib.Table
+	if calibration != "" {
+		c, err := calib.LoadTable(calibration)
+		if err != nil {
+			log.Fatalf("unable to load calibrations %s: %v", calibration, err)
+		}
+		calibrations = c
+	}
+
+	var checks qc.Table
+	if qualityControl != "" {
+		c, err := qc.LoadTable(qualityControl)
+		if err != nil {
+			log.Fatalf("unable to load quality control checks %s: %v", qualityControl, err)
+		}
+		checks = c
+	}
+
+	var rotations rotate.Table
+	if rotation != "" {
+		r, err := rotate.LoadTable(rotation)
+		if err != nil {
+			log.Fatalf("unable to load rotations %s: %v", rotation, err)
+		}
+		rotations = r
+	}
+
+	var resamples resample.Table
+	if resampling != "" {
+		r, err := resample.LoadTable(resampling)
+		if err != nil {
+			log.Fatalf("unable to load resample rules %s: %v", resampling, err)
+		}
+		resamples = r
+	}
+
+	var derived io.Writer
+	if derivations != "" {
+		file, err := os.OpenFile(derivations, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("unable to open derivation log %s: %v", derivations, err)
+		}
+		defer file.Close()
+
+		derived = file
+	}
+
+	resampler := resample.NewResampler(resamples, filter.Precision(dp))
 
-		for n := 0; n < len(data)/512; n++ {
-			if err := msr.Unpack(data[n*512:(n+1)*512], 512, 1, 0); err != nil {
-				log.Printf("skipping block, unable to unpack block  %s: (%d) %v", f, n, err)
-				continue
+	// storeResampled writes any resampled readings and how they were derived.
+	storeResampled := func(final bool) {
+		resampled, list := resampler.Flush(final)
+		if err := raw.StoreFormat(format, timeformat, resampled, headers, merger, base, resamplepath, truncate); err != nil {
+			log.Fatalf("unable to store resampled observations: %v", err)
+		}
+		if derived != nil {
+			if err := resample.EncodeDerivations(derived, list); err != nil {
+				log.Fatalf("unable to write resample derivations: %v", err)
 			}
+		}
+	}
 
-			srcname := msr.SrcName(0)
+	products := filter.NewProducts(coverage, filter.Precision(dp))
+	products.Minute, products.Hourly, products.Daily = minute, hourly, daily
 
-			sps := float64(msr.Samprate())
-			if !(sps > 0) {
-				log.Printf("skipping block, invalid sample rate %s: (%s) %g", f, srcname, sps)
-				continue
+	// storeProducts writes any completed one-minute, hourly and daily values.
+	storeProducts := func(final bool) {
+		minutes, hours, days := products.Flush(final)
+		for _, p := range []struct {
+			raws []*raw.Raw
+			path string
+		}{
+			{minutes, minutepath},
+			{hours, hourpath},
+			{days, daypath},
+		} {
+			if err := raw.StoreFormat(format, timeformat, p.raws, headers, merger, base, p.path, truncate); err != nil {
+				log.Fatalf("unable to store products: %v", err)
 			}
+		}
+	}
+
+	checker := qc.NewStream(checks)
 
-			dt := time.Duration(float64(time.Second) / sps)
+	// the channels of a station may be given in separate files, so wait for all of them
+	rotator := rotate.NewRotator(rotations, filter.Precision(dp))
+	rotator.MaxDelay = 0
 
-			samples, err := msr.DataSamples()
-			if err != nil {
-				log.Printf("skipping block, unable to decode samples %s: (%s) %v", f, srcname, err)
-				continue
+	periods := make(map[string]time.Duration)
+
+	// process stores the readings of completed intervals, along with any rotated, resampled
+	// or derived values, final also stores any readings still waiting on other channels.
+	process := func(raws []*raw.Raw, final bool) {
+		stored := append([]*raw.Raw{}, raws...)
+		for _, v := range raws {
+			if revised := checker.Apply(v); revised != nil {
+				stored = append(stored, revised)
 			}
+		}
+		if err := raw.StoreFormat(format, timeformat, stored, headers, merger, base, path, truncate); err != nil {
+			log.Fatalf("unable to store observations: %v", err)
+		}
 
-			for n, s := range samples {
-				t := msr.Starttime().Add(time.Duration(n) * dt)
+		rotated := rotator.Add(raws...)
+		if final {
+			rotated = append(rotated, rotator.Flush()...)
+		}
+		if err := raw.StoreFormat(format, timeformat, rotated, headers, merger, base, path, truncate); err != nil {
+			log.Fatalf("unable to store rotated observations: %v", err)
+		}
+		for _, v := range rotated {
+			if d := qc.NominalPeriod(v.Readings); d > 0 {
+				periods[v.Label] = d
+			}
+		}
 
-				if _, ok := cache[srcname]; !ok {
-					cache[srcname] = raw.NewRaw(srcname, dp)
+		if resampler.Enabled() {
+			for _, v := range append(raws, rotated...) {
+				resampler.Add(v, periods[v.Label])
+			}
+			storeResampled(final)
+		}
+
+		if products.Enabled() {
+			for _, v := range append(raws, rotated...) {
+				products.Add(v, periods[v.Label])
+			}
+			storeProducts(final)
+		}
+	}
+
+	// readings are held until their channel moves on to a later file interval
+	cache := make(map[string]*raw.Raw)
+	latest := make(map[string]time.Time)
+
+	var msr mseed.Record
+
+	for _, f := range flag.Args() {
+		if err := func() error {
+			file := os.Stdin
+			if f != "-" {
+				var err error
+				if file, err = os.Open(f); err != nil {
+					return err
 				}
+				defer file.Close()
+			}
 
-				if r, ok := cache[srcname]; ok {
-					r.Add(raw.NewReading(t, srcname, gain*float64(s)))
+			reader := mseed.NewReader(file)
+			defer reader.Close()
+
+			for n := 0; ; n++ {
+				record, err := reader.ReadRecord()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					log.Printf("skipping remainder of file, unable to read block %s: (%d) %v", f, n, err)
+					break
+				}
+
+				if err := msr.Unpack(record); err != nil {
+					log.Printf("skipping block, unable to unpack block  %s: (%d) %v", f, n, err)
+					continue
+				}
+
+				srcname := msr.SrcName(0)
+
+				sps := msr.Samprate()
+				if !(sps > 0) {
+					log.Printf("skipping block, invalid sample rate %s: (%s) %g", f, srcname, sps)
+					continue
+				}
+
+				dt := time.Duration(float64(time.Second) / sps)
+
+				samples, err := msr.DataSamplesFloat64()
+				if err != nil {
+					log.Printf("skipping block, unable to decode samples %s: (%s) %v", f, srcname, err)
+					continue
+				}
+				if !(len(samples) > 0) {
+					continue
+				}
+
+				r, ok := cache[srcname]
+				if !ok {
+					r = raw.NewRaw(srcname, raw.Precision(dp, msr.Sampletype() == 'i' && gain == 1.0 && !calibrations.Calibrated(srcname)))
+					cache[srcname] = r
+					periods[srcname] = dt
+				}
+
+				block := raw.NewRaw(srcname, r.Precision)
+				for n, s := range samples {
+					t := msr.Starttime().Add(time.Duration(n) * dt)
+					block.Add(raw.NewReading(t, srcname, calibrations.Convert(srcname, t, s, gain)))
+				}
+
+				// late or repeated records are already complete
+				end := msr.Starttime().Add(time.Duration(len(samples)-1) * dt).Truncate(truncate)
+				if end.Before(latest[srcname]) {
+					process([]*raw.Raw{block}, false)
+					continue
+				}
+
+				r.Readings = append(r.Readings, block.Readings...)
+				if !end.After(latest[srcname]) {
+					continue
+				}
+				latest[srcname] = end
+
+				if done := complete(r, end); done != nil {
+					process([]*raw.Raw{done}, false)
 				}
 			}
+
+			return nil
+		}(); err != nil {
+			log.Fatalf("unable to read file %s: %v", f, err)
 		}
 	}
 
+	var raws []*raw.Raw
 	for _, v := range cache {
-		if err := v.Store(base, path, truncate); err != nil {
-			log.Fatalf("unable to store observations: %v", err)
+		if len(v.Readings) > 0 {
+			raws = append(raws, v)
+		}
+	}
+
+	process(raws, true)
+}
+
+// complete removes the readings before the given time, which are returned, or nil if there are none.
+func complete(r *raw.Raw, at time.Time) *raw.Raw {
+	var done *raw.Raw
+
+	var readings []raw.Reading
+	for _, v := range r.Readings {
+		if !v.Timestamp.Before(at) {
+			readings = append(readings, v)
+			continue
+		}
+		if done == nil {
+			done = raw.NewRaw(r.Label, r.Precision)
 		}
+		done.Add(v)
 	}
+	r.Readings = readings
 
+	return done
 }
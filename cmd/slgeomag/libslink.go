@@ -0,0 +1,108 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ozym/geomag/internal/slink"
+)
+
+const timeFormat = "2006,01,02,15,04,05"
+
+// haveLibslink indicates the libslink client is available.
+const haveLibslink = true
+
+// libslink collects seedlink packets using the libslink client and passes the records to
+// the handler, the connection state is saved at the configured interval and whenever a
+// connection ends so that the next connection resumes from it.
+func (c collector) libslink(ctx context.Context, handler chan<- []byte) error {
+	if c.statefile != "" {
+		if err := os.MkdirAll(filepath.Dir(c.statefile), 0775); err != nil {
+			fatalf("unable to create statefile parent directory %s: %v", c.statefile, err)
+		}
+	}
+
+	return c.supervise(ctx, func(ctx context.Context, server string) error {
+		slconn := slink.NewSLCD()
+		defer slink.FreeSLCD(slconn)
+
+		// seedlink settings
+		slconn.SetNetDly(int(c.netdly / time.Second))
+		slconn.SetNetTo(int(c.netto / time.Second))
+		slconn.SetKeepAlive(int(c.keepalive / time.Second))
+		slconn.ParseStreamList(c.streams, c.selectors)
+
+		// conection
+		slconn.SetSLAddr(server)
+		defer slconn.Disconnect()
+
+		switch {
+		case c.statefile != "":
+			switch _, err := os.Stat(c.statefile); err {
+			case nil:
+				if n := slconn.RecoverState(c.statefile); n != 0 {
+					slconn.SetBeginTime(time.Now().UTC().Add(-c.startup).Format(timeFormat))
+				}
+			default:
+				slconn.SetBeginTime(time.Now().UTC().Add(-c.startup).Format(timeFormat))
+			}
+		default:
+			slconn.SetBeginTime(time.Now().UTC().Add(-c.startup).Format(timeFormat))
+		}
+
+		// stop collecting if the context is cancelled
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				slconn.Terminate()
+			case <-done:
+			}
+		}()
+
+		saveState := func() {
+			if c.verbose {
+				log.Printf("saving state: %s", c.statefile)
+			}
+			slconn.SaveState(c.statefile)
+		}
+
+		var last time.Time
+		for {
+			p, rc := slconn.Collect()
+			if rc == slink.SLTERMINATE {
+				break
+			} else if rc != slink.SLPACKET {
+				if c.statefile != "" {
+					saveState()
+				}
+				return fmt.Errorf("collect return value not SLPACKET or SLTERMINATE: %d", rc)
+			}
+			if p.PacketType() != slink.SLDATA {
+				continue
+			}
+
+			handler <- p.GetMSRecord()
+
+			if c.statefile != "" {
+				if t := time.Now(); t.Sub(last) > c.state {
+					saveState()
+					last = t
+				}
+			}
+		}
+
+		if c.statefile != "" {
+			saveState()
+		}
+
+		return nil
+	})
+}
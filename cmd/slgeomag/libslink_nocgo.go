@@ -0,0 +1,16 @@
+//go:build !cgo
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// haveLibslink indicates the libslink client is available.
+const haveLibslink = false
+
+// libslink is unavailable without cgo, the native client is used instead.
+func (c collector) libslink(ctx context.Context, handler chan<- []byte) error {
+	return errors.New("libslink support requires cgo")
+}
@@ -1,21 +1,52 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/nightlyone/lockfile"
 
+	"github.com/ozym/geomag/internal/alert"
+	"github.com/ozym/geomag/internal/calib"
+	"github.com/ozym/geomag/internal/filter"
 	"github.com/ozym/geomag/internal/mseed"
+	"github.com/ozym/geomag/internal/qc"
 	"github.com/ozym/geomag/internal/raw"
-	"github.com/ozym/geomag/internal/slink"
+	"github.com/ozym/geomag/internal/resample"
+	"github.com/ozym/geomag/internal/rotate"
 )
 
-const timeFormat = "2006,01,02,15,04,05"
+// webhookTimeout limits how long an alert webhook may take to respond.
+const webhookTimeout = 10 * time.Second
+
+// droppedEvents counts the alert events dropped while notifications were falling behind.
+var droppedEvents = expvar.NewInt("dropped_alert_events")
+
+// collector holds the seedlink connection settings, connections fail over between the servers.
+type collector struct {
+	servers    []string
+	netdly     time.Duration
+	netto      time.Duration
+	keepalive  time.Duration
+	streams    string
+	selectors  string
+	startup    time.Duration
+	statefile  string
+	state      time.Duration
+	backoff    time.Duration
+	maxbackoff time.Duration
+	verbose    bool
+}
 
 func main() {
 	flag.Usage = func() {
@@ -24,7 +55,7 @@ func main() {
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "Usage:\n")
 		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Fprintf(os.Stderr, "  %s [options] <command> [options] <server>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [options] <command> [options] <server>[,<server>...]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "General Options:\n")
 		fmt.Fprintf(os.Stderr, "\n")
@@ -38,6 +69,9 @@ func main() {
 	var lock string
 	flag.StringVar(&lock, "lockfile", "", "provide a process lock file")
 
+	var native bool
+	flag.BoolVar(&native, "native", false, "use the native go seedlink client rather than libslink, always used without cgo")
+
 	// seedlink options
 	var netdly time.Duration
 	flag.DurationVar(&netdly, "netdly", 0, "provide network delay")
@@ -63,6 +97,15 @@ func main() {
 	var state time.Duration
 	flag.DurationVar(&state, "state", 5*time.Minute, "how often to save state")
 
+	var backoff time.Duration
+	flag.DurationVar(&backoff, "backoff", 5*time.Second, "initial delay before reconnecting, doubled after each reconnect, zero to exit instead")
+
+	var maxbackoff time.Duration
+	flag.DurationVar(&maxbackoff, "maxbackoff", 5*time.Minute, "maximum delay before reconnecting")
+
+	var metrics string
+	flag.StringVar(&metrics, "metrics", "", "optional address to serve reconnect metrics from, e.g. localhost:8080")
+
 	var truncate time.Duration
 	flag.DurationVar(&truncate, "truncate", time.Hour, "interval to store files")
 
@@ -73,11 +116,77 @@ func main() {
 	flag.StringVar(&path, "path", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.csv", "file name template")
 
 	var dp int
-	flag.IntVar(&dp, "dp", 0, "number of decimal places for raw data")
+	flag.IntVar(&dp, "dp", -1, "number of decimal places for raw data, negative to use the native sample type")
 
 	var gain float64
 	flag.Float64Var(&gain, "gain", 1.0, "apply a gain to the raw data")
 
+	var calibration string
+	flag.StringVar(&calibration, "calibration", "", "optional yaml, json or csv file of per channel calibrations, overrides gain")
+
+	var qualityControl string
+	flag.StringVar(&qualityControl, "qc", "", "optional yaml or json file of per channel quality control checks, requires csv raw files for the flag column")
+
+	var resampling string
+	flag.StringVar(&resampling, "resample", "", "optional yaml or json file of per channel rules for resampling onto exact sample times")
+
+	var resamplepath string
+	flag.StringVar(&resamplepath, "resamplepath", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.res.csv", "resampled file name template")
+
+	var derivations string
+	flag.StringVar(&derivations, "derivations", "", "optional file to append a line to for each resampled reading describing how it was derived")
+
+	var rotation string
+	flag.StringVar(&rotation, "rotation", "", "optional yaml or json file of per station rotations into xyz, hdz or dif components")
+
+	var flush time.Duration
+	flag.DurationVar(&flush, "flush", time.Minute, "how often to write buffered readings to their files, zero to write every packet")
+
+	var format string
+	flag.StringVar(&format, "format", "csv", "raw file format, either csv, station or iaga2002")
+
+	var timeformat string
+	flag.StringVar(&timeformat, "timeformat", raw.TimeSecond, "precision of raw csv timestamps, either second, millisecond or microsecond")
+
+	var header string
+	flag.StringVar(&header, "header", "", "optional json file of iaga2002 station headers")
+
+	var policy string
+	flag.StringVar(&policy, "merge", "new", "policy for readings that conflict with stored ones, either new, existing, quality or reject")
+
+	var conflicts string
+	flag.StringVar(&conflicts, "conflicts", "", "optional file to append a line to for each conflicting reading")
+
+	var minute bool
+	flag.BoolVar(&minute, "minute", false, "also build intermagnet one-minute filtered files from one-second data")
+
+	var minutepath string
+	flag.StringVar(&minutepath, "minutepath", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.min.csv", "one-minute file name template")
+
+	var hourly bool
+	flag.BoolVar(&hourly, "hourly", false, "also build hourly mean files, from one-minute values if built")
+
+	var hourpath string
+	flag.StringVar(&hourpath, "hourpath", "{{year}}/{{year}}.{{yearday}}.{{toupper .Label}}.hour.csv", "hourly mean file name template")
+
+	var daily bool
+	flag.BoolVar(&daily, "daily", false, "also build daily mean files, from one-minute values if built")
+
+	var daypath string
+	flag.StringVar(&daypath, "daypath", "{{year}}/{{year}}.{{toupper .Label}}.day.csv", "daily mean file name template")
+
+	var coverage float64
+	flag.Float64Var(&coverage, "coverage", filter.DefaultCoverage, "minimum fraction of samples needed for one-minute values and hourly or daily means")
+
+	var alerts string
+	flag.StringVar(&alerts, "alerts", "", "optional yaml or json file of rate of change alert rules")
+
+	var alertlog string
+	flag.StringVar(&alertlog, "alertlog", "", "optional file to append alert events to as json")
+
+	var webhook string
+	flag.StringVar(&webhook, "webhook", "", "optional url to post alert events to as json")
+
 	flag.Parse()
 
 	args := flag.Args()
@@ -90,7 +199,7 @@ func main() {
 	if lock != "" {
 		lf, err := lockfile.New(lock)
 		if err != nil {
-			log.Fatalf("unable to open lockfile %s: %v", lock, err)
+			fatalf("unable to open lockfile %s: %v", lock, err)
 		}
 		if err := lf.TryLock(); err != nil {
 			if verbose {
@@ -99,32 +208,305 @@ func main() {
 			os.Exit(1)
 		}
 		defer lf.Unlock()
+
+		// fatal errors exit without running any deferred calls
+		release = func() { lf.Unlock() }
+	}
+
+	// an interrupt or terminate signal stops the collection so that any pending readings can be
+	// stored and the state saved before exiting, a second signal exits straight away
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		log.Printf("received %s signal, shutting down", <-signals)
+		cancel()
+
+		fatalf("received %s signal, exiting", <-signals)
+	}()
+
+	var servers []string
+	for _, s := range strings.Split(args[len(args)-1], ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	if !(len(servers) > 0) {
+		fatalf("no seedlink server given")
 	}
 
-	server := args[len(args)-1]
+	if metrics != "" {
+		// the expvar package serves its variables at /debug/vars
+		go func() {
+			if err := http.ListenAndServe(metrics, nil); err != nil {
+				fatalf("unable to serve metrics on %s: %v", metrics, err)
+			}
+		}()
+	}
 
 	fi, err := os.Stat(base)
 	switch {
 	case err != nil:
-		log.Fatalf("cannot write to base directory: %v", err)
+		fatalf("cannot write to base directory: %v", err)
 	case !fi.IsDir():
-		log.Fatalf("cannot write to base directory: %s: not a directory", base)
+		fatalf("cannot write to base directory: %s: not a directory", base)
+	}
+
+	if !raw.ValidFormat(format) {
+		fatalf("unknown raw file format: %s", format)
+	}
+
+	if !raw.ValidTimeFormat(timeformat) {
+		fatalf("unknown raw time format: %s", timeformat)
+	}
+
+	// rotated readings share the station files of the raw channels, and may need five components
+	if rotation != "" && format == "iaga2002" {
+		fatalf("rotation requires csv or station raw files, not %s", format)
+	}
+
+	// only csv files have a flag column, the flags would otherwise be lost when stored
+	if qualityControl != "" && format != "csv" {
+		fatalf("quality control requires csv raw files, not %s", format)
+	}
+
+	if !raw.ValidPolicy(policy) {
+		fatalf("unknown merge policy: %s", policy)
+	}
+
+	merger := raw.NewMerger(raw.Policy(policy), nil)
+	if conflicts != "" {
+		file, err := os.OpenFile(conflicts, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fatalf("unable to open conflict log %s: %v", conflicts, err)
+		}
+		defer file.Close()
+
+		merger.Log = file
+	}
+
+	headers := make(map[string]raw.Header)
+	if header != "" {
+		h, err := raw.LoadHeaders(header)
+		if err != nil {
+			fatalf("unable to load station headers %s: %v", header, err)
+		}
+		headers = h
+	}
+
+	var calibrations calib.Table
+	if calibration != "" {
+		c, err := calib.LoadTable(calibration)
+		if err != nil {
+			fatalf("unable to load calibrations %s: %v", calibration, err)
+		}
+		calibrations = c
+	}
+
+	var checks qc.Table
+	if qualityControl != "" {
+		c, err := qc.LoadTable(qualityControl)
+		if err != nil {
+			fatalf("unable to load quality control checks %s: %v", qualityControl, err)
+		}
+		checks = c
+	}
+
+	var rotations rotate.Table
+	if rotation != "" {
+		r, err := rotate.LoadTable(rotation)
+		if err != nil {
+			fatalf("unable to load rotations %s: %v", rotation, err)
+		}
+		rotations = r
+	}
+
+	var resamples resample.Table
+	if resampling != "" {
+		r, err := resample.LoadTable(resampling)
+		if err != nil {
+			fatalf("unable to load resample rules %s: %v", resampling, err)
+		}
+		resamples = r
+	}
+
+	var derived io.Writer
+	if derivations != "" {
+		file, err := os.OpenFile(derivations, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fatalf("unable to open derivation log %s: %v", derivations, err)
+		}
+		defer file.Close()
+
+		derived = file
+	}
+
+	resampler := resample.NewResampler(resamples, filter.Precision(dp))
+
+	products := filter.NewProducts(coverage, filter.Precision(dp))
+	products.Minute, products.Hourly, products.Daily = minute, hourly, daily
+
+	// storeProducts writes any completed one-minute, hourly and daily values.
+	storeProducts := func(final bool) {
+		minutes, hours, days := products.Flush(final)
+		for _, p := range []struct {
+			raws []*raw.Raw
+			path string
+		}{
+			{minutes, minutepath},
+			{hours, hourpath},
+			{days, daypath},
+		} {
+			if err := raw.StoreFormat(format, timeformat, p.raws, headers, merger, base, p.path, truncate); err != nil {
+				fatalf("unable to store products: %v", err)
+			}
+		}
+	}
+
+	var rules alert.Table
+	if alerts != "" {
+		r, err := alert.LoadTable(alerts)
+		if err != nil {
+			fatalf("unable to load alert rules %s: %v", alerts, err)
+		}
+		rules = r
+	}
+
+	var notifiers []alert.Notifier
+	if alertlog != "" {
+		notifiers = append(notifiers, alert.NewFile(alertlog))
+	}
+	if webhook != "" {
+		notifiers = append(notifiers, alert.NewWebhook(webhook, webhookTimeout))
+	}
+
+	monitor := alert.NewMonitor(rules)
+
+	// events are passed on separately so a slow webhook doesn't hold up storing the data, once
+	// the queue is full any further events are logged and dropped rather than waiting
+	events := make(chan alert.Event, 100)
+	notified := make(chan struct{})
+	go func() {
+		defer close(notified)
+		for e := range events {
+			log.Printf("alert: %s", e)
+			for _, n := range notifiers {
+				if err := n.Notify(e); err != nil {
+					log.Printf("unable to send alert event %s: %v", e.Name, err)
+				}
+			}
+		}
+	}()
+
+	// checks keep a trailing window of each channel so they see across packet boundaries
+	checker := qc.NewStream(checks)
+
+	rotator := rotate.NewRotator(rotations, filter.Precision(dp))
+
+	// readings are held in memory and files are written once per flush interval or hour rollover
+	buffer := raw.NewBuffer(format, timeformat, headers, merger, base, path, truncate, flush)
+	resampled := raw.NewBuffer(format, timeformat, headers, merger, base, resamplepath, truncate, flush)
+	// expiring is stopped before the final flush at shutdown
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if !(flush > 0) {
+			return
+		}
+
+		ticker := time.NewTicker(flush)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if err := buffer.Expire(); err != nil {
+				fatalf("unable to store observations: %v", err)
+			}
+			if err := resampled.Expire(); err != nil {
+				fatalf("unable to store resampled observations: %v", err)
+			}
+		}
+	}()
+
+	// storeResampled buffers any resampled readings and writes how they were derived.
+	storeResampled := func(final bool) {
+		raws, list := resampler.Flush(final)
+		if err := resampled.Add(raws...); err != nil {
+			fatalf("unable to store resampled observations: %v", err)
+		}
+		if derived != nil {
+			if err := resample.EncodeDerivations(derived, list); err != nil {
+				fatalf("unable to write resample derivations: %v", err)
+			}
+		}
+	}
+
+	// periods holds the latest sample period of each station, for any rotated readings flushed at shutdown
+	periods := make(map[string]time.Duration)
+
+	// process buffers the readings and passes them on for resampling, any products and alert
+	// monitoring, so that alerts can be given for rotated channels as well as the raw channels.
+	process := func(raws []*raw.Raw, dt time.Duration) {
+		if err := buffer.Add(raws...); err != nil {
+			fatalf("unable to store observations: %v", err)
+		}
+
+		for _, v := range raws {
+			for _, e := range monitor.Add(v) {
+				select {
+				case events <- e:
+				default:
+					droppedEvents.Add(1)
+					log.Printf("alert: dropping event, notifications are falling behind: %s", e)
+				}
+			}
+		}
+
+		if resampler.Enabled() {
+			for _, v := range raws {
+				resampler.Add(v, dt)
+			}
+			storeResampled(false)
+		}
+
+		if products.Enabled() {
+			for _, v := range raws {
+				products.Add(v, dt)
+			}
+			storeProducts(false)
+		}
 	}
 
 	handler := make(chan []byte, 20000)
+	drained := make(chan struct{})
 	go func() {
-		msr := mseed.NewMSRecord()
-		defer mseed.FreeMSRecord(msr)
+		defer close(drained)
+
+		var msr mseed.Record
 
 		for b := range handler {
-			if err := msr.Unpack(b, 512, 1, 0); err != nil {
+			n, err := mseed.RecordLength(b)
+			if err != nil {
+				log.Printf("skipping block, unable to detect block length: %v", err)
+				continue
+			}
+			if n == 0 || n > len(b) {
+				n = len(b)
+			}
+			if err := msr.Unpack(b[:n]); err != nil {
 				log.Printf("skipping block, unable to unpack block: %v", err)
 				continue
 			}
 
 			srcname := msr.SrcName(0)
 
-			sps := float64(msr.Samprate())
+			sps := msr.Samprate()
 			if !(sps > 0) {
 				log.Printf("skipping block, invalid sample rate %s: %g", srcname, sps)
 				continue
@@ -132,81 +514,106 @@ func main() {
 
 			st, dt := msr.Starttime(), time.Duration(float64(time.Second)/sps)
 
-			samples, err := msr.DataSamples()
+			samples, err := msr.DataSamplesFloat64()
 			if err != nil {
 				log.Printf("skipping block, unable to decode samples %s: %v", srcname, err)
 				continue
 			}
 
-			geomag := raw.NewRaw(srcname, dp)
+			geomag := raw.NewRaw(srcname, raw.Precision(dp, msr.Sampletype() == 'i' && gain == 1.0 && !calibrations.Calibrated(srcname)))
 			for i, s := range samples {
-				geomag.Add(raw.NewReading(st.Add(time.Duration(i)*dt), srcname, gain*float64(s)))
+				t := st.Add(time.Duration(i) * dt)
+				geomag.Add(raw.NewReading(t, srcname, calibrations.Convert(srcname, t, s, gain)))
 			}
 
 			if verbose {
 				log.Printf("handling packet %s: %s (%d)", srcname, st, len(samples))
 			}
-			if err := geomag.Store(base, path, truncate); err != nil {
-				log.Fatalf("unable to store observations: %v", err)
-			}
-		}
-	}()
 
-	slconn := slink.NewSLCD()
-	defer slink.FreeSLCD(slconn)
+			revised := checker.Apply(geomag)
 
-	// seedlink settings
-	slconn.SetNetDly(int(netdly / time.Second))
-	slconn.SetNetTo(int(netto / time.Second))
-	slconn.SetKeepAlive(int(keepalive / time.Second))
-	slconn.ParseStreamList(streams, selectors)
+			station, _ := raw.SplitSrcName(srcname)
+			periods[station] = dt
 
-	// conection
-	slconn.SetSLAddr(server)
-	defer slconn.Disconnect()
+			process(append([]*raw.Raw{geomag}, rotator.Add(geomag)...), dt)
 
-	switch {
-	case statefile != "":
-		if err := os.MkdirAll(filepath.Dir(statefile), 0775); err != nil {
-			log.Fatalf("unable to create statefile parent directory %s: %v", statefile, err)
-		}
-		switch _, err := os.Stat(statefile); err {
-		case nil:
-			if n := slconn.RecoverState(statefile); n != 0 {
-				slconn.SetBeginTime(time.Now().UTC().Add(-startup).Format(timeFormat))
+			// earlier readings with revised flags replace those already buffered or stored
+			if revised != nil {
+				if err := buffer.Add(revised); err != nil {
+					fatalf("unable to store observations: %v", err)
+				}
 			}
-		default:
-			slconn.SetBeginTime(time.Now().UTC().Add(-startup).Format(timeFormat))
+
 		}
-	default:
-		slconn.SetBeginTime(time.Now().UTC().Add(-startup).Format(timeFormat))
+	}()
+
+	c := collector{
+		servers:    servers,
+		netdly:     netdly,
+		netto:      netto,
+		keepalive:  keepalive,
+		streams:    streams,
+		selectors:  selectors,
+		startup:    startup,
+		statefile:  statefile,
+		state:      state,
+		backoff:    backoff,
+		maxbackoff: maxbackoff,
+		verbose:    verbose,
 	}
 
-	var last time.Time
-	for {
-		// dying here
-		p, rc := slconn.Collect()
-		if rc == slink.SLTERMINATE {
-			log.Printf("SLTERMINATE signal received.")
-			break
-		} else if rc != slink.SLPACKET {
-			log.Printf("Collect return value not SLPACKET or SLTERMINATE: %d", rc)
-			break
+	switch {
+	case native || !haveLibslink:
+		if err := c.native(ctx, handler); err != nil {
+			log.Printf("unable to collect seedlink packets: %v", err)
 		}
-		if p.PacketType() != slink.SLDATA {
-			continue
+	default:
+		if err := c.libslink(ctx, handler); err != nil {
+			log.Printf("unable to collect seedlink packets: %v", err)
 		}
+	}
 
-		handler <- p.GetMSRecord()
+	// handle any packets still waiting before storing the buffered readings
+	close(handler)
+	<-drained
 
-		if statefile != "" {
-			if verbose {
-				log.Printf("saving state: %s", statefile)
-			}
-			if t := time.Now(); t.Sub(last) > state {
-				slconn.SaveState(statefile)
-				last = t
-			}
+	close(stop)
+	<-stopped
+
+	// samples still waiting on other channels are converted with the readings available
+	for _, v := range rotator.Flush() {
+		station, _ := raw.SplitSrcName(v.Label)
+		process([]*raw.Raw{v}, periods[station])
+	}
+
+	if products.Enabled() {
+		storeProducts(true)
+	}
+
+	if err := buffer.Flush(); err != nil {
+		fatalf("unable to store observations: %v", err)
+	}
+
+	if resampler.Enabled() {
+		storeResampled(true)
+		if err := resampled.Flush(); err != nil {
+			fatalf("unable to store resampled observations: %v", err)
 		}
 	}
+
+	close(events)
+	<-notified
+
+	if verbose {
+		log.Printf("shutdown complete")
+	}
+}
+
+// release frees any process lockfile before a fatal error exits.
+var release = func() {}
+
+// fatalf logs the error and exits, as log.Fatalf but after releasing any lockfile.
+func fatalf(format string, v ...interface{}) {
+	release()
+	log.Fatalf(format, v...)
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+
+	"github.com/ozym/geomag/internal/filter"
+	"github.com/ozym/geomag/internal/raw"
+	"github.com/ozym/geomag/internal/seedlink"
+	"github.com/ozym/geomag/internal/seedlink/seedlinktest"
+)
+
+// output collects the program output while it is running.
+type output struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *output) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// build compiles slgeomag into the given directory.
+func build(t *testing.T, dir string) string {
+	prog := filepath.Join(dir, "slgeomag")
+	if out, err := exec.Command("go", "build", "-o", prog, ".").CombinedOutput(); err != nil {
+		t.Fatalf("unable to build slgeomag: %v\n%s", err, out)
+	}
+	return prog
+}
+
+// TestRotation checks that rotated readings are refused for iaga2002 raw files at startup, rather
+// than failing when the first combined station file is stored.
+func TestRotation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	dir, err := ioutil.TempDir("", "slgeomag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prog := build(t, dir)
+
+	rotation := filepath.Join(dir, "rotation.yaml")
+	if err := ioutil.WriteFile(rotation, []byte("- station: NZ_EYWM_51\n  channels: [LFX, LFY, LFZ]\n  scalar: LFF\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lock := filepath.Join(dir, "slgeomag.lock")
+
+	out, err := exec.Command(prog, "-format", "iaga2002", "-rotation", rotation,
+		"-base", dir, "-lockfile", lock, "localhost:0").CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected iaga2002 rotation to be rejected:\n%s", out)
+	}
+	if !strings.Contains(string(out), "rotation requires csv or station raw files, not iaga2002") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	// fatal errors still release the lockfile
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("expected the lockfile to be released: %v", err)
+	}
+}
+
+// TestQualityControl checks that quality control is refused at startup for raw file formats
+// without a flag column, rather than the flags being lost when stored.
+func TestQualityControl(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	dir, err := ioutil.TempDir("", "slgeomag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prog := build(t, dir)
+
+	checks := filepath.Join(dir, "qc.yaml")
+	if err := ioutil.WriteFile(checks, []byte("- srcname: NZ_EYWM_51_LF?\n  spike: {window: 5, threshold: 5}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"station", "iaga2002"} {
+		out, err := exec.Command(prog, "-format", format, "-qc", checks, "-base", dir, "localhost:0").CombinedOutput()
+		if err == nil {
+			t.Fatalf("expected %s quality control to be rejected:\n%s", format, out)
+		}
+		if !strings.Contains(string(out), "quality control requires csv raw files, not "+format) {
+			t.Errorf("unexpected output:\n%s", out)
+		}
+	}
+}
+
+// TestShutdown runs slgeomag against a fake seedlink server and checks that a terminate signal
+// stores the buffered readings, the rotated samples still waiting on other channels and the
+// remaining one-minute values, saves the state and releases the lockfile before exiting.
+func TestShutdown(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping signal test on windows")
+	}
+
+	data, err := ioutil.ReadFile("../msgeomag/testdata/NZ.EYWM.51.LFF.D.2019.146")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// vector channels are copies of the scalar records, the scalar readings stop half way so
+	// that the later rotated samples are still waiting for them when the signal arrives
+	var records [][]byte
+	for i := 0; i < 10 && (i+1)*seedlink.RecordSize <= len(data); i++ {
+		record := data[i*seedlink.RecordSize : (i+1)*seedlink.RecordSize]
+		for _, c := range []string{"LFX", "LFY", "LFZ"} {
+			b := append([]byte{}, record...)
+			copy(b[15:18], c)
+			records = append(records, b)
+		}
+		if i < 5 {
+			records = append(records, record)
+		}
+	}
+
+	server := seedlinktest.NewServer(records)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "slgeomag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prog := build(t, dir)
+
+	base, state, lock := filepath.Join(dir, "data"), filepath.Join(dir, "state", "slgeomag.state"), filepath.Join(dir, "slgeomag.lock")
+	if err := os.MkdirAll(base, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rotation := filepath.Join(dir, "rotation.yaml")
+	if err := ioutil.WriteFile(rotation, []byte("- station: NZ_EYWM_51\n  input: xyz\n  channels: [LFX, LFY, LFZ]\n  scalar: LFF\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// alerts can be given for the rotated channels
+	alerts, alertlog := filepath.Join(dir, "alerts.yaml"), filepath.Join(dir, "alerts.json")
+	if err := ioutil.WriteFile(alerts, []byte("- name: EYWM dH/dt\n  channels: [NZ_EYWM_51_X, NZ_EYWM_51_Y]\n  window: 60s\n  threshold: 0.001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a long flush interval so that no readings are stored until the shutdown
+	var stderr output
+	cmd := exec.Command(prog, "-native", "-verbose",
+		"-base", base, "-statefile", state, "-lockfile", lock, "-rotation", rotation, "-minute", "-coverage", "0.5",
+		"-alerts", alerts, "-alertlog", alertlog, "-flush", "1h", "-state", "1h", "-streams", "NZ_EYWM", server.Addr())
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the packets to have been handled
+	for start := time.Now(); strings.Count(stderr.String(), "handling packet") < len(records); {
+		if time.Since(start) > 30*time.Second {
+			cmd.Process.Kill()
+			t.Fatalf("timeout waiting for packets:\n%s", stderr.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	files, err := filepath.Glob(filepath.Join(base, "*", "*", "*_[A-Z][A-Z][A-Z].csv"))
+	if err != nil || len(files) != 0 {
+		t.Errorf("expected readings to be buffered before the shutdown: %v (%v)", files, err)
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected exit: %v\n%s", err, stderr.String())
+		}
+	case <-time.After(30 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("timeout waiting for shutdown:\n%s", stderr.String())
+	}
+
+	if !strings.Contains(stderr.String(), "shutdown complete") {
+		t.Errorf("expected a complete shutdown:\n%s", stderr.String())
+	}
+
+	// stored readings of each label, keyed by the file suffix
+	stored := make(map[string]map[string]*raw.Raw)
+	files, err = filepath.Glob(filepath.Join(base, "*", "*", "*.csv"))
+	if err != nil || !(len(files) > 0) {
+		t.Fatalf("expected stored readings after the shutdown: %v (%v)", files, err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r raw.Raw
+		if err := r.Unmarshal(b); err != nil {
+			t.Fatal(err)
+		}
+
+		kind := "raw"
+		if strings.HasSuffix(f, ".min.csv") {
+			kind = "minute"
+		}
+		if _, ok := stored[kind]; !ok {
+			stored[kind] = make(map[string]*raw.Raw)
+		}
+		for _, v := range r.Readings {
+			if _, ok := stored[kind][v.Label]; !ok {
+				stored[kind][v.Label] = raw.NewRaw(v.Label, 0)
+			}
+			stored[kind][v.Label].Add(v)
+		}
+	}
+
+	count := func(kind, label string) int {
+		if r, ok := stored[kind][label]; ok {
+			return len(r.Readings)
+		}
+		return 0
+	}
+
+	vector, scalar := count("raw", "NZ_EYWM_51_LFX"), count("raw", "NZ_EYWM_51_LFF")
+	if !(vector > 0) || !(scalar > 0) || !(scalar < vector) {
+		t.Fatalf("unexpected stored raw readings: vector %d scalar %d", vector, scalar)
+	}
+
+	// samples waiting for the scalar channel are converted without delta-F at the shutdown
+	for _, c := range []string{"X", "Y", "Z", "F"} {
+		if n := count("raw", "NZ_EYWM_51_"+c); n != vector {
+			t.Errorf("expected %d rotated %s readings got %d", vector, c, n)
+		}
+	}
+	if n := count("raw", "NZ_EYWM_51_G"); n != scalar {
+		t.Errorf("expected %d delta-F readings got %d", scalar, n)
+	}
+
+	// one-minute values include those near the end of the readings, which have enough coverage
+	// to only be completed at the shutdown
+	for _, l := range []string{"NZ_EYWM_51_LFX", "NZ_EYWM_51_LFF", "NZ_EYWM_51_X"} {
+		expected := filter.Minutes(stored["raw"][l], 0.5, 0)
+		if n := count("minute", l); n != len(expected.Readings) {
+			t.Errorf("expected %d one-minute %s values got %d", len(expected.Readings), l, n)
+		}
+	}
+
+	if b, err := ioutil.ReadFile(alertlog); err != nil || !strings.Contains(string(b), `"name":"EYWM dH/dt"`) {
+		t.Errorf("expected an alert event for the rotated channels: %s (%v)", b, err)
+	}
+
+	b, err := ioutil.ReadFile(state)
+	if err != nil {
+		t.Fatalf("expected the state to be saved: %v", err)
+	}
+	if !strings.Contains(string(b), "NZ EYWM") {
+		t.Errorf("unexpected saved state: %s", b)
+	}
+
+	lf, err := lockfile.New(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lf.TryLock(); err != nil {
+		t.Errorf("expected the lockfile to be released: %v", err)
+	}
+	lf.Unlock()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ozym/geomag/internal/seedlink"
+)
+
+// native collects seedlink packets using the native go client and passes the records to
+// the handler, the client state is saved at the configured interval and whenever a connection
+// ends. The client keeps its stream sequence numbers between connections.
+func (c collector) native(ctx context.Context, handler chan<- []byte) error {
+	client := seedlink.NewClient(c.servers[0])
+	client.Timeout = c.netto
+	client.KeepAlive = c.keepalive
+	client.BeginTime = time.Now().UTC().Add(-c.startup)
+
+	if _, err := client.ParseStreamList(c.streams, c.selectors); err != nil {
+		fatalf("unable to parse seedlink streams %s: %v", c.streams, err)
+	}
+
+	if c.statefile != "" {
+		if err := os.MkdirAll(filepath.Dir(c.statefile), 0775); err != nil {
+			fatalf("unable to create statefile parent directory %s: %v", c.statefile, err)
+		}
+		if _, err := os.Stat(c.statefile); err == nil {
+			if err := client.RecoverState(c.statefile); err != nil {
+				log.Printf("unable to recover state %s: %v", c.statefile, err)
+			}
+		}
+	}
+
+	saveState := func() {
+		if c.verbose {
+			log.Printf("saving state: %s", c.statefile)
+		}
+		if err := client.SaveState(c.statefile); err != nil {
+			log.Printf("unable to save state %s: %v", c.statefile, err)
+		}
+	}
+
+	return c.supervise(ctx, func(ctx context.Context, server string) error {
+		client.Server = server
+
+		packets := make(chan seedlink.Packet)
+
+		done := make(chan error, 1)
+		go func() {
+			defer close(packets)
+			done <- client.Collect(ctx, packets)
+		}()
+
+		var last time.Time
+		for p := range packets {
+			handler <- p.Record
+
+			if c.statefile == "" {
+				continue
+			}
+			if t := time.Now(); t.Sub(last) > c.state {
+				saveState()
+				last = t
+			}
+		}
+
+		if c.statefile != "" {
+			saveState()
+		}
+
+		return <-done
+	})
+}
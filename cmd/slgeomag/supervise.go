@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+)
+
+// stableSession is how long a connection needs to last for the reconnect backoff to be reset.
+const stableSession = time.Minute
+
+// reconnects counts the reconnect events to each server, available via the metrics address.
+var reconnects = expvar.NewMap("reconnects")
+
+// session collects packets from a single connection to a server, it returns once the connection
+// has ended and the collection state has been saved.
+type session func(ctx context.Context, server string) error
+
+// supervise runs sessions until the context is cancelled, reconnecting after each one ends with
+// an exponential backoff. A session that fails moves on to the next server in the list, whereas
+// a server ending the data stream is reconnected to. A zero backoff disables reconnecting.
+func (c collector) supervise(ctx context.Context, run session) error {
+	delay := c.backoff
+	for n := 0; ; {
+		server := c.servers[n%len(c.servers)]
+
+		started := time.Now()
+		err := run(ctx, server)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !(c.backoff > 0) {
+			return err
+		}
+
+		if time.Since(started) > stableSession {
+			delay = c.backoff
+		}
+		if err != nil {
+			n++
+		}
+
+		next := c.servers[n%len(c.servers)]
+		reconnects.Add(next, 1)
+
+		switch {
+		case err != nil:
+			log.Printf("connection to %s failed: %v, reconnecting to %s in %s", server, err, next, delay)
+		default:
+			log.Printf("connection to %s ended, reconnecting to %s in %s", server, next, delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > c.maxbackoff {
+			delay = c.maxbackoff
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ozym/geomag/internal/qc"
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// Window is a span of time to request from the fdsn service.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Length returns the duration of the window.
+func (w Window) Length() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+// Label returns the srcname used to label stored readings, this is only available for
+// a complete source without any wildcards.
+func (s Source) Label() (string, bool) {
+	loc := s.Location
+	if loc == "--" {
+		loc = ""
+	}
+	for _, v := range []string{s.Network, s.Station, loc, s.Channel} {
+		if strings.ContainsAny(v, "*?") {
+			return "", false
+		}
+	}
+	return strings.Join([]string{s.Network, s.Station, loc, s.Channel}, "_"), true
+}
+
+// Missing returns the windows between the start and end times without any stored readings for
+// the labelled channel. Each window is extended by a sample period either side so the request
+// overlaps the readings bounding the gap, a channel without any stored readings is missing the
+// whole time span.
+func Missing(base, path string, truncate time.Duration, label string, start, end time.Time) ([]Window, error) {
+	stored, err := raw.Load(base, path, truncate, label, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	report := qc.Analyse(label, stored.Readings, start, end, 0, 0)
+	if !(report.Period > 0) {
+		if len(stored.Readings) > 0 {
+			// a single reading doesn't give a sample period, rather than guess just leave it
+			return nil, nil
+		}
+		return []Window{{Start: start, End: end}}, nil
+	}
+
+	var windows []Window
+	for _, g := range report.Gaps {
+		w := Window{
+			Start: g.Start.Add(-report.Period),
+			End:   g.End.Add(report.Period),
+		}
+		if w.Start.Before(start) {
+			w.Start = start
+		}
+		if w.End.After(end) {
+			w.End = end
+		}
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
@@ -3,15 +3,24 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/nightlyone/lockfile"
 
+	"github.com/ozym/geomag/internal/calib"
+	"github.com/ozym/geomag/internal/filter"
 	"github.com/ozym/geomag/internal/mseed"
+	"github.com/ozym/geomag/internal/qc"
 	"github.com/ozym/geomag/internal/raw"
+	"github.com/ozym/geomag/internal/resample"
+	"github.com/ozym/geomag/internal/rotate"
+	"github.com/ozym/geomag/internal/stationxml"
 )
 
 const timeFormat = "2006-01-02T15:04:05"
@@ -85,11 +94,80 @@ func main() {
 	flag.StringVar(&path, "path", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.csv", "file name template")
 
 	var dp int
-	flag.IntVar(&dp, "dp", 0, "number of decimal places for raw data")
+	flag.IntVar(&dp, "dp", -1, "number of decimal places for raw data, negative to use the native sample type")
 
 	var gain float64
 	flag.Float64Var(&gain, "gain", 1.0, "gain to apply to raw data")
 
+	var calibration string
+	flag.StringVar(&calibration, "calibration", "", "optional yaml, json or csv file of per channel calibrations, overrides gain")
+
+	var response bool
+	flag.BoolVar(&response, "response", false, "apply instrument sensitivities from the fdsn station service before any gain or calibration")
+
+	var stationXML string
+	flag.StringVar(&stationXML, "stationxml", "", "optional local stationxml file of instrument sensitivities, used rather than the station service")
+
+	var responseCache string
+	flag.StringVar(&responseCache, "cache", "", "optional directory to cache station service responses")
+
+	var responseAge time.Duration
+	flag.DurationVar(&responseAge, "maxage", 24*time.Hour, "how long to use cached station service responses")
+
+	var qualityControl string
+	flag.StringVar(&qualityControl, "qc", "", "optional yaml or json file of per channel quality control checks, requires csv raw files for the flag column")
+
+	var resampling string
+	flag.StringVar(&resampling, "resample", "", "optional yaml or json file of per channel rules for resampling onto exact sample times")
+
+	var resamplepath string
+	flag.StringVar(&resamplepath, "resamplepath", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.res.csv", "resampled file name template")
+
+	var derivations string
+	flag.StringVar(&derivations, "derivations", "", "optional file to append a line to for each resampled reading describing how it was derived")
+
+	var rotation string
+	flag.StringVar(&rotation, "rotation", "", "optional yaml or json file of per station rotations into xyz, hdz or dif components")
+
+	var format string
+	flag.StringVar(&format, "format", "csv", "raw file format, either csv, station or iaga2002")
+
+	var timeformat string
+	flag.StringVar(&timeformat, "timeformat", raw.TimeSecond, "precision of raw csv timestamps, either second, millisecond or microsecond")
+
+	var header string
+	flag.StringVar(&header, "header", "", "optional json file of iaga2002 station headers")
+
+	var policy string
+	flag.StringVar(&policy, "merge", "new", "policy for readings that conflict with stored ones, either new, existing, quality or reject")
+
+	var conflicts string
+	flag.StringVar(&conflicts, "conflicts", "", "optional file to append a line to for each conflicting reading")
+
+	var minute bool
+	flag.BoolVar(&minute, "minute", false, "also build intermagnet one-minute filtered files from one-second data")
+
+	var minutepath string
+	flag.StringVar(&minutepath, "minutepath", "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.min.csv", "one-minute file name template")
+
+	var hourly bool
+	flag.BoolVar(&hourly, "hourly", false, "also build hourly mean files, from one-minute values if built")
+
+	var hourpath string
+	flag.StringVar(&hourpath, "hourpath", "{{year}}/{{year}}.{{yearday}}.{{toupper .Label}}.hour.csv", "hourly mean file name template")
+
+	var daily bool
+	flag.BoolVar(&daily, "daily", false, "also build daily mean files, from one-minute values if built")
+
+	var daypath string
+	flag.StringVar(&daypath, "daypath", "{{year}}/{{year}}.{{toupper .Label}}.day.csv", "daily mean file name template")
+
+	var coverage float64
+	flag.Float64Var(&coverage, "coverage", filter.DefaultCoverage, "minimum fraction of samples needed for one-minute values and hourly or daily means")
+
+	var backfill time.Duration
+	flag.DurationVar(&backfill, "backfill", 0, "optional lookback to scan stored csv files for gaps, only the missing data is requested")
+
 	flag.Parse()
 
 	if lock != "" {
@@ -124,45 +202,188 @@ func main() {
 		srcnames = append(srcnames, strings.TrimSpace(s))
 	}
 
-	client := NewDataselect(service, timeout)
-	msr := mseed.NewMSRecord()
-	defer mseed.FreeMSRecord(msr)
+	if !raw.ValidFormat(format) {
+		log.Fatalf("unknown raw file format: %s", format)
+	}
 
-	for {
-		t, dt := func() (time.Time, time.Duration) {
-			switch {
-			case !st.IsZero() && !et.IsZero():
-				return et, et.Sub(st)
-			case !st.IsZero():
-				return st.Add(length), length
-			case !et.IsZero():
-				return et, length
-			default:
-				t := <-time.After(ticks(interval, offset))
-				return t.UTC().Add(-delay), length
+	if !raw.ValidTimeFormat(timeformat) {
+		log.Fatalf("unknown raw time format: %s", timeformat)
+	}
+
+	// rotated readings share the station files of the raw channels, and may need five components
+	if rotation != "" && format == "iaga2002" {
+		log.Fatalf("rotation requires csv or station raw files, not %s", format)
+	}
+
+	// only csv files have a flag column, the flags would otherwise be lost when stored
+	if qualityControl != "" && format != "csv" {
+		log.Fatalf("quality control requires csv raw files, not %s", format)
+	}
+
+	if !raw.ValidPolicy(policy) {
+		log.Fatalf("unknown merge policy: %s", policy)
+	}
+
+	merger := raw.NewMerger(raw.Policy(policy), nil)
+	if conflicts != "" {
+		file, err := os.OpenFile(conflicts, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("unable to open conflict log %s: %v", conflicts, err)
+		}
+		defer file.Close()
+
+		merger.Log = file
+	}
+
+	labels := make(map[string]string)
+	if backfill > 0 {
+		if format != "csv" {
+			log.Fatalf("backfill requires csv raw files, not %s", format)
+		}
+		for _, srcname := range srcnames {
+			label, ok := NewSource(srcname).Label()
+			if !ok {
+				log.Fatalf("backfill requires complete channel srcnames: %s", srcname)
 			}
-		}()
+			labels[srcname] = label
+		}
+	}
 
-		if verbose {
-			log.Printf("query: %s from %v to %v", strings.Join(srcnames, ","), t.Add(-dt), t)
+	headers := make(map[string]raw.Header)
+	if header != "" {
+		h, err := raw.LoadHeaders(header)
+		if err != nil {
+			log.Fatalf("unable to load station headers %s: %v", header, err)
+		}
+		headers = h
+	}
+
+	var calibrations calib.Table
+	if calibration != "" {
+		c, err := calib.LoadTable(calibration)
+		if err != nil {
+			log.Fatalf("unable to load calibrations %s: %v", calibration, err)
+		}
+		calibrations = c
+	}
+
+	var checks qc.Table
+	if qualityControl != "" {
+		c, err := qc.LoadTable(qualityControl)
+		if err != nil {
+			log.Fatalf("unable to load quality control checks %s: %v", qualityControl, err)
+		}
+		checks = c
+	}
+
+	var rotations rotate.Table
+	if rotation != "" {
+		r, err := rotate.LoadTable(rotation)
+		if err != nil {
+			log.Fatalf("unable to load rotations %s: %v", rotation, err)
+		}
+		rotations = r
+	}
+
+	var resamples resample.Table
+	if resampling != "" {
+		r, err := resample.LoadTable(resampling)
+		if err != nil {
+			log.Fatalf("unable to load resample rules %s: %v", resampling, err)
+		}
+		resamples = r
+	}
+
+	var derived io.Writer
+	if derivations != "" {
+		file, err := os.OpenFile(derivations, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("unable to open derivation log %s: %v", derivations, err)
 		}
+		defer file.Close()
 
-		data, err := client.Query(srcnames, t, dt)
+		derived = file
+	}
+
+	resampler := resample.NewResampler(resamples, filter.Precision(dp))
+
+	// storeResampled writes any resampled readings and how they were derived.
+	storeResampled := func(final bool) {
+		resampled, list := resampler.Flush(final)
+		if err := raw.StoreFormat(format, timeformat, resampled, headers, merger, base, resamplepath, truncate); err != nil {
+			log.Fatalf("unable to store resampled observations: %v", err)
+		}
+		if derived != nil {
+			if err := resample.EncodeDerivations(derived, list); err != nil {
+				log.Fatalf("unable to write resample derivations: %v", err)
+			}
+		}
+	}
+
+	products := filter.NewProducts(coverage, filter.Precision(dp))
+	products.Minute, products.Hourly, products.Daily = minute, hourly, daily
+
+	// storeProducts writes any completed one-minute, hourly and daily values.
+	storeProducts := func(final bool) {
+		minutes, hours, days := products.Flush(final)
+		for _, p := range []struct {
+			raws []*raw.Raw
+			path string
+		}{
+			{minutes, minutepath},
+			{hours, hourpath},
+			{days, daypath},
+		} {
+			if err := raw.StoreFormat(format, timeformat, p.raws, headers, merger, base, p.path, truncate); err != nil {
+				log.Fatalf("unable to store products: %v", err)
+			}
+		}
+	}
+
+	var sensitivities stationxml.Sensitivities
+	if stationXML != "" {
+		s, err := stationxml.Load(stationXML)
+		if err != nil {
+			log.Fatalf("unable to load stationxml %s: %v", stationXML, err)
+		}
+		sensitivities = s
+	}
+
+	responses := stationxml.NewService(service, timeout)
+	responses.Cache, responses.MaxAge = responseCache, responseAge
+
+	client := NewDataselect(service, timeout)
+	var msr mseed.Record
+
+	periods := make(map[string]time.Duration)
+
+	// request queries the fdsn service and adds the decoded samples to the cache.
+	request := func(cache map[string]*raw.Raw, srcname string, t time.Time, dt time.Duration) {
+		body, err := client.Query(srcname, t, dt)
 		if err != nil {
 			log.Fatalf("unable to query fdsn service: %v", err)
 		}
 
-		cache := make(map[string]*raw.Raw)
+		reader := mseed.NewReader(body)
 
-		for n := 0; n < len(data)/512; n++ {
-			if err := msr.Unpack(data[n*512:(n+1)*512], 512, 1, 0); err != nil {
+		for n := 0; ; n++ {
+			record, err := reader.ReadRecord()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Printf("skipping remainder of query, unable to read block: (%d) %v", n, err)
+				break
+			}
+
+			if err := msr.Unpack(record); err != nil {
 				log.Printf("skipping block, unable to unpack block: (%d) %v", n, err)
 				continue
 			}
 
 			srcname := msr.SrcName(0)
 
-			sps := float64(msr.Samprate())
+			sps := msr.Samprate()
 			if !(sps > 0) {
 				log.Printf("skipping block, invalid sample rate: (%s) %g", srcname, sps)
 				continue
@@ -170,7 +391,7 @@ func main() {
 
 			dt := time.Duration(float64(time.Second) / sps)
 
-			samples, err := msr.DataSamples()
+			samples, err := msr.DataSamplesFloat64()
 			if err != nil {
 				log.Printf("skipping block, unable to decode samples: (%s) %v", srcname, err)
 				continue
@@ -179,20 +400,120 @@ func main() {
 			for n, s := range samples {
 				t := msr.Starttime().Add(time.Duration(n) * dt)
 
+				v, scaled := sensitivities.Convert(srcname, t, s)
+
 				if _, ok := cache[srcname]; !ok {
-					cache[srcname] = raw.NewRaw(srcname, dp)
+					cache[srcname] = raw.NewRaw(srcname, raw.Precision(dp, msr.Sampletype() == 'i' && !scaled && gain == 1.0 && !calibrations.Calibrated(srcname)))
+					periods[srcname] = dt
 				}
 
 				if r, ok := cache[srcname]; ok {
-					r.Add(raw.NewReading(t, srcname, gain*float64(s)))
+					r.Add(raw.NewReading(t, srcname, calibrations.Convert(srcname, t, v, gain)))
+				}
+			}
+		}
+
+		reader.Close()
+		body.Close()
+	}
+
+	// an interrupt or terminate signal stops any continuous processing once the current query has been stored
+	signals := make(chan os.Signal, 1)
+	if interval > 0 && st.IsZero() && et.IsZero() {
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	}
+
+	for {
+		t, dt, ok := func() (time.Time, time.Duration, bool) {
+			switch {
+			case !st.IsZero() && !et.IsZero():
+				return et, et.Sub(st), true
+			case !st.IsZero():
+				return st.Add(length), length, true
+			case !et.IsZero():
+				return et, length, true
+			default:
+				select {
+				case t := <-time.After(ticks(interval, offset)):
+					return t.UTC().Add(-delay), length, true
+				case s := <-signals:
+					log.Printf("received %s signal, shutting down", s)
+					return time.Time{}, 0, false
 				}
 			}
+		}()
+		if !ok {
+			break
+		}
+
+		if verbose && !(backfill > 0) {
+			log.Printf("query: %s from %v to %v", strings.Join(srcnames, ","), t.Add(-dt), t)
+		}
+		if verbose && backfill > 0 {
+			log.Printf("backfill: %s from %v to %v", strings.Join(srcnames, ","), t.Add(-backfill), t)
 		}
 
+		cache := make(map[string]*raw.Raw)
+
+		for _, srcname := range srcnames {
+			if response && stationXML == "" {
+				s := NewSource(srcname)
+				list, err := responses.Query(s.Network, s.Station, s.Location, s.Channel)
+				if err != nil {
+					log.Fatalf("unable to query fdsn station service: %v", err)
+				}
+				sensitivities = list
+			}
+
+			if !(backfill > 0) {
+				request(cache, srcname, t, dt)
+				continue
+			}
+
+			windows, err := Missing(base, path, truncate, labels[srcname], t.Add(-backfill), t)
+			if err != nil {
+				log.Fatalf("unable to scan stored files for %s: %v", srcname, err)
+			}
+			for _, w := range windows {
+				if verbose {
+					log.Printf("backfill: %s from %v to %v", srcname, w.Start, w.End)
+				}
+				request(cache, srcname, w.End, w.Length())
+			}
+		}
+
+		var raws []*raw.Raw
 		for _, v := range cache {
-			if err := v.Store(base, path, truncate); err != nil {
-				log.Fatalf("unable to store observations: %v", err)
+			checks.Apply(v)
+			raws = append(raws, v)
+		}
+
+		if err := raw.StoreFormat(format, timeformat, raws, headers, merger, base, path, truncate); err != nil {
+			log.Fatalf("unable to store observations: %v", err)
+		}
+
+		rotated := rotate.Rotate(rotations, filter.Precision(dp), raws)
+		if err := raw.StoreFormat(format, timeformat, rotated, headers, merger, base, path, truncate); err != nil {
+			log.Fatalf("unable to store rotated observations: %v", err)
+		}
+		for _, v := range rotated {
+			periods[v.Label] = qc.NominalPeriod(v.Readings)
+			raws = append(raws, v)
+		}
+
+		if resampler.Enabled() {
+			for _, v := range raws {
+				resampler.Add(v, periods[v.Label])
+			}
+			storeResampled(!st.IsZero() || !et.IsZero() || !(interval > 0))
+		}
+
+		if products.Enabled() {
+			for _, v := range raws {
+				products.Add(v, periods[v.Label])
 			}
+			// values at the end of a single query are flushed rather than waiting for more data
+			storeProducts(!st.IsZero() || !et.IsZero() || !(interval > 0))
 		}
 
 		if !st.IsZero() || !et.IsZero() {
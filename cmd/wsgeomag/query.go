@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -23,39 +25,40 @@ func NewDataselect(service string, timeout time.Duration) *Dataselect {
 	}
 }
 
-func (d *Dataselect) Query(srcnames []string, at time.Time, length time.Duration) ([]byte, error) {
+// Query requests miniseed data for a single srcname, the response body is returned
+// for streaming and must be closed by the caller. A service responding with no data
+// will return an empty body.
+func (d *Dataselect) Query(srcname string, at time.Time, length time.Duration) (io.ReadCloser, error) {
 
 	client := &http.Client{
 		Timeout: d.Timeout,
 	}
 
-	var data []byte
-	for _, s := range srcnames {
-		query, err := d.Request(s, at, length)
-		if err != nil {
-			return nil, err
-		}
-
-		request, err := http.NewRequest("GET", query, nil)
-		if err != nil {
-			return nil, err
-		}
-
-		resp, err := client.Do(request)
-		if resp == nil || err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
+	query, err := d.Request(srcname, at, length)
+	if err != nil {
+		return nil, err
+	}
 
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
-		}
+	request, err := http.NewRequest("GET", query, nil)
+	if err != nil {
+		return nil, err
+	}
 
-		data = append(data, body...)
+	resp, err := client.Do(request)
+	if resp == nil || err != nil {
+		return nil, err
 	}
 
-	return data, nil
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNoContent, http.StatusNotFound:
+		resp.Body.Close()
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("invalid response from %s: %s", query, resp.Status)
+	}
 }
 
 func (d *Dataselect) Request(srcname string, endtime time.Time, length time.Duration) (string, error) {
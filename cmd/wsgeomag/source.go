@@ -2,6 +2,8 @@ package main
 
 import (
 	"strings"
+
+	"github.com/ozym/geomag/internal/mseed"
 )
 
 type Source struct {
@@ -11,9 +13,20 @@ type Source struct {
 	Channel  string
 }
 
+// NewSource builds a query Source from either a NET_STA_LOC_CHA style srcname, or an FDSN
+// source identifier (e.g. FDSN:NZ_EYWM_51_L_F_F), missing codes are treated as wildcards.
 func NewSource(srcname string) Source {
 
 	srcname = strings.TrimSpace(srcname)
+
+	if strings.HasPrefix(srcname, mseed.SourceIDPrefix) {
+		if s, ok := newSourceID(srcname); ok {
+			return s
+		}
+		// a partial identifier, e.g. FDSN:NZ_EYWM
+		srcname = strings.TrimPrefix(srcname, mseed.SourceIDPrefix)
+	}
+
 	srcname = strings.Replace(srcname, "_", "_ ", -1)
 
 	parts := strings.Split(srcname, "_")
@@ -60,6 +73,35 @@ func NewSource(srcname string) Source {
 	return s
 }
 
+// newSourceID builds a query Source from a complete FDSN source identifier, an empty
+// location code is requested explicitly as a blank location.
+func newSourceID(sid string) (Source, bool) {
+	s := Source{"*", "*", "*", "*"}
+
+	net, sta, loc, cha, err := mseed.ParseSourceID(sid)
+	if err != nil {
+		return s, false
+	}
+
+	if net != "" {
+		s.Network = net
+	}
+	if sta != "" {
+		s.Station = sta
+	}
+	switch loc {
+	case "":
+		s.Location = "--"
+	default:
+		s.Location = loc
+	}
+	if cha != "" {
+		s.Channel = cha
+	}
+
+	return s, true
+}
+
 func (s Source) String() string {
 	return strings.Join([]string{
 		s.Network,
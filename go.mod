@@ -2,6 +2,9 @@ module github.com/ozym/geomag
 
 go 1.13
 
-require github.com/nightlyone/lockfile v1.0.0
+require (
+	github.com/nightlyone/lockfile v1.0.0
+	gopkg.in/yaml.v2 v2.4.0
+)
 
 replace github.com/ozym/geomag => github.com/AdrianBenson/geomag v0.0.0-20200821134019-8138f09db597
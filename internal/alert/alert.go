@@ -0,0 +1,320 @@
+// Package alert raises rate of change alerts, e.g. dB/dt on the horizontal components, from streamed raw readings.
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// Event states.
+const (
+	StateAlert = "alert"
+	StateClear = "clear"
+)
+
+// Rule describes when to raise an alert. The rate of change of each channel is found over the
+// Window, e.g. "60s", in units per minute (nT/min) and the rates of all channels are combined as a
+// vector magnitude, giving the horizontal rate when the X and Y (or H) channels are listed. An alert
+// is raised when the rate reaches the Threshold and is only cleared once it falls below Clear,
+// which defaults to the Threshold, to avoid flapping.
+type Rule struct {
+	// Name labels the alert events, the channels are used if not given.
+	Name      string   `json:"name" yaml:"name"`
+	Channels  []string `json:"channels" yaml:"channels"`
+	Window    string   `json:"window" yaml:"window"`
+	Threshold float64  `json:"threshold" yaml:"threshold"`
+	Clear     float64  `json:"clear,omitempty" yaml:"clear,omitempty"`
+}
+
+// window returns the parsed rate window.
+func (r Rule) window() time.Duration {
+	d, _ := time.ParseDuration(r.Window)
+	return d
+}
+
+// clear returns the rate below which an alert is cleared.
+func (r Rule) clear() float64 {
+	if r.Clear > 0 && r.Clear < r.Threshold {
+		return r.Clear
+	}
+	return r.Threshold
+}
+
+// Validate checks the rule settings are complete.
+func (r Rule) Validate() error {
+	switch d, err := time.ParseDuration(r.Window); {
+	case err != nil:
+		return fmt.Errorf("invalid alert window for %s: %v", r.Name, err)
+	case !(d > 0):
+		return fmt.Errorf("invalid alert window for %s: %s", r.Name, r.Window)
+	case !(len(r.Channels) > 0):
+		return fmt.Errorf("missing alert channels for %s", r.Name)
+	case !(r.Threshold > 0):
+		return fmt.Errorf("invalid alert threshold for %s: %g", r.Name, r.Threshold)
+	}
+	return nil
+}
+
+// Event records a change of alert state.
+type Event struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Time      time.Time `json:"time"`
+	Rate      float64   `json:"rate"`
+	Peak      float64   `json:"peak"`
+	Threshold float64   `json:"threshold"`
+	Window    string    `json:"window"`
+}
+
+// String returns a single line summary of the event.
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s %s rate=%.2f peak=%.2f threshold=%g window=%s",
+		e.Name, e.State, e.Time.Format(time.RFC3339Nano), e.Rate, e.Peak, e.Threshold, e.Window)
+}
+
+// Table holds a list of alert rules.
+type Table []Rule
+
+// LoadTable reads a table of alert rules from a YAML or JSON file, the format is chosen using the file extension.
+func LoadTable(name string) (Table, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var table Table
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown alert file format: %s", ext)
+	}
+
+	for _, r := range table {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
+
+// point holds a single reading time and value.
+type point struct {
+	at    time.Time
+	value float64
+}
+
+// monitor tracks the recent readings and alert state of a single rule. Sample times are not
+// expected to match exactly across channels or packets, readings are looked up to within half
+// the sample period of each channel.
+type monitor struct {
+	rule   Rule
+	window time.Duration
+
+	history   map[string][]point
+	tolerance map[string]time.Duration
+	latest    time.Time
+	active    bool
+	peak      float64
+}
+
+// Monitor follows the streamed readings of the channels used by a set of rules.
+type Monitor struct {
+	monitors []*monitor
+}
+
+// NewMonitor returns a Monitor for the given rules.
+func NewMonitor(rules Table) *Monitor {
+	var m Monitor
+	for _, r := range rules {
+		if r.Name == "" {
+			r.Name = strings.Join(r.Channels, ",")
+		}
+		m.monitors = append(m.monitors, &monitor{
+			rule:      r,
+			window:    r.window(),
+			history:   make(map[string][]point),
+			tolerance: make(map[string]time.Duration),
+		})
+	}
+	return &m
+}
+
+// nearest returns the reading of a channel closest to the given time, if within the tolerance.
+func (m *monitor) nearest(label string, at time.Time) (point, bool) {
+	h, tolerance := m.history[label], m.tolerance[label]
+
+	i := sort.Search(len(h), func(i int) bool {
+		return !h[i].at.Before(at)
+	})
+
+	var best point
+	var found bool
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(h) {
+			continue
+		}
+		d := abs(h[j].at.Sub(at))
+		if d > tolerance {
+			continue
+		}
+		if !found || d < abs(best.at.Sub(at)) {
+			best, found = h[j], true
+		}
+	}
+
+	return best, found
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// rate returns the combined rate of change, per minute, at the given time if all channels have readings.
+func (m *monitor) rate(at time.Time) (float64, bool) {
+	var sum float64
+	for _, c := range m.rule.Channels {
+		now, ok := m.nearest(c, at)
+		if !ok {
+			return 0, false
+		}
+		then, ok := m.nearest(c, at.Add(-m.window))
+		if !ok {
+			return 0, false
+		}
+		dt := now.at.Sub(then.at)
+		if !(dt > 0) {
+			return 0, false
+		}
+		r := (now.value - then.value) / dt.Minutes()
+		sum += r * r
+	}
+	return math.Sqrt(sum), true
+}
+
+// insert adds a reading to the time ordered history of a channel, replacing any at the same time.
+func (m *monitor) insert(label string, p point) {
+	h := m.history[label]
+
+	n := len(h)
+	switch i := sort.Search(n, func(i int) bool { return !h[i].at.Before(p.at) }); {
+	case i == n:
+		h = append(h, p)
+	case h[i].at.Equal(p.at):
+		h[i] = p
+	default:
+		h = append(h, point{})
+		copy(h[i+1:], h[i:])
+		h[i] = p
+	}
+
+	m.history[label] = h
+}
+
+// add includes the readings of a channel and returns any change of alert state.
+func (m *monitor) add(label string, readings []raw.Reading) []Event {
+	var times []time.Time
+	for _, r := range readings {
+		if r.Label != label || r.Flag != "" || math.IsNaN(r.Field) {
+			continue
+		}
+		m.insert(label, point{at: r.Timestamp, value: r.Field})
+		times = append(times, r.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+
+	// the tolerance is half the smallest spacing of the recent readings
+	h := m.history[label]
+
+	var spacing time.Duration
+	for i := 1; i < len(h); i++ {
+		if d := h[i].at.Sub(h[i-1].at); spacing == 0 || d < spacing {
+			spacing = d
+		}
+	}
+	m.tolerance[label] = spacing / 2
+
+	var events []Event
+	for _, t := range times {
+		if !t.After(m.latest) {
+			continue
+		}
+		rate, ok := m.rate(t)
+		if !ok {
+			continue
+		}
+		m.latest = t
+
+		if m.active && rate > m.peak {
+			m.peak = rate
+		}
+
+		switch {
+		case !m.active && rate >= m.rule.Threshold:
+			m.active, m.peak = true, rate
+			events = append(events, m.event(StateAlert, t, rate))
+		case m.active && rate < m.rule.clear():
+			m.active = false
+			events = append(events, m.event(StateClear, t, rate))
+		}
+	}
+
+	// only keep the readings needed for later rates
+	if len(times) > 0 {
+		oldest := times[len(times)-1].Add(-2 * m.window)
+		i := sort.Search(len(h), func(i int) bool {
+			return !h[i].at.Before(oldest)
+		})
+		m.history[label] = append([]point{}, h[i:]...)
+	}
+
+	return events
+}
+
+func (m *monitor) event(state string, at time.Time, rate float64) Event {
+	return Event{
+		Name:      m.rule.Name,
+		State:     state,
+		Time:      at,
+		Rate:      rate,
+		Peak:      m.peak,
+		Threshold: m.rule.Threshold,
+		Window:    m.rule.Window,
+	}
+}
+
+// Add includes the readings of a raw data set, flagged or missing readings are ignored, and
+// returns any alert events raised or cleared by them.
+func (m *Monitor) Add(r *raw.Raw) []Event {
+	var events []Event
+	for _, v := range m.monitors {
+		for _, c := range v.rule.Channels {
+			if c == r.Label {
+				events = append(events, v.add(r.Label, r.Readings)...)
+			}
+		}
+	}
+	return events
+}
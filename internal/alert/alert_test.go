@@ -0,0 +1,162 @@
+package alert
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+func TestMonitor(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	monitor := NewMonitor(Table{{
+		Channels:  []string{"NZ_EYWM_51_LFX", "NZ_EYWM_51_LFY"},
+		Window:    "60s",
+		Threshold: 50,
+		Clear:     20,
+	}})
+
+	// the x component changes at 40, 60, 40, 30 and then 10 nT/min over successive five minute
+	// spans, the y component changes at 30 nT/min in the second span giving a 67 nT/min vector
+	rates := []float64{40, 60, 40, 30, 10}
+
+	x, y := 20000.0, 3000.0
+	var events []Event
+	for i := 0; i < 25*60; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+
+		span := i / 300
+		x += rates[span] / 60.0
+		if span == 1 {
+			y += 30.0 / 60.0
+		}
+
+		// channels arrive in separate one second packets
+		for _, r := range []*raw.Raw{{Label: "NZ_EYWM_51_LFX"}, {Label: "NZ_EYWM_51_LFY"}} {
+			v := x
+			if r.Label == "NZ_EYWM_51_LFY" {
+				v = y
+			}
+			r.Add(raw.NewReading(at, r.Label, v))
+			events = append(events, monitor.Add(r)...)
+		}
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected an alert and a clear event got %v", events)
+	}
+
+	alert, clear := events[0], events[1]
+	if alert.State != StateAlert || alert.Name != "NZ_EYWM_51_LFX,NZ_EYWM_51_LFY" || alert.Time.Before(start.Add(5*time.Minute)) || alert.Time.After(start.Add(6*time.Minute)) {
+		t.Errorf("unexpected alert event: %s", alert)
+	}
+	if clear.State != StateClear || clear.Time.Before(start.Add(20*time.Minute)) || clear.Time.After(start.Add(21*time.Minute)) {
+		t.Errorf("unexpected clear event: %s", clear)
+	}
+	if clear.Peak < 66 || clear.Peak > 68 {
+		t.Errorf("unexpected peak rate: %g", clear.Peak)
+	}
+
+	// flagged readings are ignored
+	r := raw.NewRaw("NZ_EYWM_51_LFX", 0)
+	reading := raw.NewReading(start.Add(25*time.Minute), r.Label, 99999.0)
+	reading.Flag = "S"
+	r.Add(reading)
+	if events := monitor.Add(r); len(events) != 0 {
+		t.Errorf("unexpected events from a flagged reading: %v", events)
+	}
+}
+
+func TestMonitor_Jitter(t *testing.T) {
+	start := time.Date(2016, time.March, 19, 0, 0, 0, 968393000, time.UTC)
+
+	monitor := NewMonitor(Table{{
+		Name:      "NZ_EYWM_51",
+		Channels:  []string{"NZ_EYWM_51_LFX", "NZ_EYWM_51_LFY"},
+		Window:    "60s",
+		Threshold: 50,
+	}})
+
+	// sample times drift off the whole second and differ across channels, a steady 60 nT/min
+	// change on both channels gives an 85 nT/min vector
+	jitter := []time.Duration{0, 3 * time.Millisecond, -5 * time.Millisecond, 11 * time.Millisecond}
+
+	var events []Event
+	for p := 0; p < 10; p++ {
+		for i, c := range []string{"NZ_EYWM_51_LFX", "NZ_EYWM_51_LFY"} {
+			r := raw.NewRaw(c, 0)
+			for n := p * 30; n < (p+1)*30; n++ {
+				at := start.Add(time.Duration(n)*time.Second + time.Duration(i)*20*time.Millisecond + jitter[n%len(jitter)])
+				r.Add(raw.NewReading(at, c, float64(n)))
+			}
+			events = append(events, monitor.Add(r)...)
+		}
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("expected a single alert event got %v", events)
+	}
+	if e := events[0]; e.State != StateAlert || e.Rate < 84 || e.Rate > 86 || e.Time.Before(start.Add(time.Minute)) || e.Time.After(start.Add(61*time.Second)) {
+		t.Errorf("unexpected alert event: %s", e)
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	event := Event{
+		Name:      "NZ_EYWM_51",
+		State:     StateAlert,
+		Time:      time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Rate:      62.5,
+		Peak:      62.5,
+		Threshold: 50,
+		Window:    "60s",
+	}
+
+	var posted Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL, time.Second).Notify(event); err != nil {
+		t.Fatal(err)
+	}
+	if posted != event {
+		t.Errorf("unexpected posted event: %v", posted)
+	}
+
+	if err := NewWebhook(server.URL+"/missing\x7f", time.Second).Notify(event); err == nil {
+		t.Error("expected an invalid webhook url to fail")
+	}
+
+	dir, err := ioutil.TempDir("", "alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := NewFile(filepath.Join(dir, "alerts.json"))
+	for i := 0; i < 2; i++ {
+		if err := file.Notify(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"state":"alert"`) {
+		t.Errorf("unexpected alert file contents: %s", string(data))
+	}
+}
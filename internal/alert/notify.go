@@ -0,0 +1,91 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Notifier passes on alert events.
+type Notifier interface {
+	Notify(Event) error
+}
+
+// File appends each event as a line of JSON to a file.
+type File struct {
+	Path string
+}
+
+// NewFile returns a File notifier for the given path.
+func NewFile(path string) *File {
+	return &File{
+		Path: path,
+	}
+}
+
+// Notify appends the event to the file, which is created if needed.
+func (f *File) Notify(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Webhook posts each event as JSON to an HTTP endpoint.
+type Webhook struct {
+	URL     string
+	Timeout time.Duration
+}
+
+// NewWebhook returns a Webhook notifier for the given URL.
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{
+		URL:     url,
+		Timeout: timeout,
+	}
+}
+
+// Notify posts the event, any non successful response is returned as an error.
+func (w *Webhook) Notify(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout: w.Timeout,
+	}
+
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("invalid response from %s: %s", w.URL, resp.Status)
+	}
+
+	return nil
+}
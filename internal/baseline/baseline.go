@@ -0,0 +1,169 @@
+package baseline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// Station holds the baseline settings of a single station, the variometer channel label of each
+// observed component (e.g. X, Y and Z) and the method used to fit the baselines.
+type Station struct {
+	Station    string            `json:"station" yaml:"station"`
+	Components map[string]string `json:"components" yaml:"components"`
+	Fit        string            `json:"fit,omitempty" yaml:"fit,omitempty"`
+}
+
+// Method returns the fitting method, piecewise linear if not given.
+func (s Station) Method() string {
+	if s.Fit == "" {
+		return FitLinear
+	}
+	return strings.ToLower(s.Fit)
+}
+
+// Codes returns the sorted component codes.
+func (s Station) Codes() []string {
+	var codes []string
+	for k := range s.Components {
+		codes = append(codes, strings.ToUpper(k))
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Channel returns the variometer channel label of a component.
+func (s Station) Channel(code string) string {
+	for k, v := range s.Components {
+		if strings.ToUpper(k) == code {
+			return v
+		}
+	}
+	return ""
+}
+
+// Validate checks the station settings are complete.
+func (s Station) Validate() error {
+	switch s.Method() {
+	case FitLinear, FitSpline:
+	default:
+		return fmt.Errorf("unknown baseline fit for station %s: %s", s.Station, s.Fit)
+	}
+	if !(len(s.Components) > 0) {
+		return fmt.Errorf("missing baseline components for station %s", s.Station)
+	}
+	return nil
+}
+
+// Table holds the baseline settings of a list of stations.
+type Table []Station
+
+// LoadTable reads a table of station settings from a YAML or JSON file, the format is chosen using the file extension.
+func LoadTable(name string) (Table, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var table Table
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown baseline file format: %s", ext)
+	}
+
+	for _, s := range table {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
+
+// nearest returns the unflagged reading closest to a time, if within the tolerance.
+func nearest(readings []raw.Reading, at time.Time, tolerance time.Duration) (raw.Reading, bool) {
+	var best raw.Reading
+	var found bool
+	for _, r := range readings {
+		if r.Flag != "" || math.IsNaN(r.Field) {
+			continue
+		}
+		d := r.Timestamp.Sub(at)
+		if d < 0 {
+			d = -d
+		}
+		if d > tolerance {
+			continue
+		}
+		if !found || d < absolute(best.Timestamp.Sub(at)) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+func absolute(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Points returns the baseline values of a station component, the observed absolute values less
+// the nearest variometer reading within the tolerance, observations without a reading are skipped.
+func Points(observations []Observation, station, code string, readings []raw.Reading, tolerance time.Duration) []Point {
+	var points []Point
+	for _, o := range observations {
+		if o.Station != station || o.Component != code {
+			continue
+		}
+		r, ok := nearest(readings, o.Timestamp, tolerance)
+		if !ok {
+			continue
+		}
+		points = append(points, Point{
+			Timestamp: o.Timestamp,
+			Value:     o.Value - r.Field,
+		})
+	}
+	return points
+}
+
+// Apply adds the baseline to the variometer readings of a channel. It returns the adjusted readings,
+// the quasi-definitive readings which are limited to the span of the baseline points, and the
+// baseline values used. Any reading flags are kept.
+func Apply(label string, curve *Curve, readings []raw.Reading, precision int) (*raw.Raw, *raw.Raw, *raw.Raw) {
+	adjusted, definitive, baseline := raw.NewRaw(label, precision), raw.NewRaw(label, precision), raw.NewRaw(label, precision)
+
+	for _, r := range readings {
+		b := curve.Value(r.Timestamp)
+
+		v := raw.NewReading(r.Timestamp, label, r.Field+b)
+		v.Flag = r.Flag
+
+		adjusted.Add(v)
+		if curve.Covers(r.Timestamp) {
+			definitive.Add(v)
+		}
+		baseline.Add(raw.NewReading(r.Timestamp, label, b))
+	}
+
+	return adjusted, definitive, baseline
+}
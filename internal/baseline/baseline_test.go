@@ -0,0 +1,133 @@
+package baseline
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+func TestDecodeObservations(t *testing.T) {
+	data := strings.Join([]string{
+		"time,station,component,value",
+		"# a comment",
+		"2020-01-01T01:00:00Z,NZ_EYWM_51,D,1380.0",
+		"2020-01-01T01:00:00Z,NZ_EYWM_51,I,-3900.0",
+		"2020-01-01T01:00:00Z,NZ_EYWM_51,F,55000.0",
+		"2020-01-02T01:00:00Z,NZ_EYWM_51,x,20000.5",
+	}, "\n")
+
+	observations, err := DecodeObservations(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(observations); n != 4 {
+		t.Fatalf("expected 4 observations got %d", n)
+	}
+	if observations[3].Component != "X" {
+		t.Errorf("expected an upper case component: %s", observations[3].Component)
+	}
+
+	expanded := Expand(observations)
+	if n := len(expanded); n != 8 {
+		t.Fatalf("expected 8 expanded observations got %d", n)
+	}
+
+	values := make(map[string]float64)
+	for _, o := range expanded {
+		if o.Timestamp.Day() == 1 {
+			values[o.Component] = o.Value
+		}
+	}
+	h := 55000.0 * math.Cos(65.0*math.Pi/180.0)
+	if math.Abs(values["H"]-h) > 1e-6 || math.Abs(values["X"]-h*math.Cos(23.0*math.Pi/180.0)) > 1e-6 {
+		t.Errorf("unexpected expanded components: %v", values)
+	}
+	if math.Abs(values["Z"]+55000.0*math.Sin(65.0*math.Pi/180.0)) > 1e-6 {
+		t.Errorf("unexpected expanded vertical component: %g", values["Z"])
+	}
+}
+
+func TestFit(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var points []Point
+	for i, v := range []float64{10.0, 12.0, 11.0, 15.0} {
+		points = append(points, Point{Timestamp: start.Add(time.Duration(i) * 24 * time.Hour), Value: v})
+	}
+
+	for _, method := range []string{FitLinear, FitSpline} {
+		curve, err := Fit(method, points)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range points {
+			if v := curve.Value(p.Timestamp); math.Abs(v-p.Value) > 1e-9 {
+				t.Errorf("%s: expected the curve to pass through %g got %g", method, p.Value, v)
+			}
+		}
+		if v := curve.Value(start.Add(-time.Hour)); v != 10.0 {
+			t.Errorf("%s: expected the curve to be held before the first point got %g", method, v)
+		}
+		if v := curve.Value(start.Add(100 * time.Hour)); v != 15.0 {
+			t.Errorf("%s: expected the curve to be held after the last point got %g", method, v)
+		}
+	}
+
+	linear, _ := Fit(FitLinear, points)
+	if v := linear.Value(start.Add(12 * time.Hour)); math.Abs(v-11.0) > 1e-9 {
+		t.Errorf("expected a linear midpoint of 11 got %g", v)
+	}
+
+	// the spline is smooth rather than following the straight line
+	spline, _ := Fit(FitSpline, points)
+	if v := spline.Value(start.Add(12 * time.Hour)); math.Abs(v-11.0) < 0.1 {
+		t.Errorf("expected a curved spline midpoint got %g", v)
+	}
+
+	if _, err := Fit("cubic", points); err == nil {
+		t.Error("expected an unknown fit to fail")
+	}
+}
+
+func TestApply(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var readings []raw.Reading
+	for i := 0; i < 4*24; i++ {
+		readings = append(readings, raw.NewReading(start.Add(time.Duration(i)*time.Hour), "NZ_EYWM_51_X", 100.0))
+	}
+
+	observations := []Observation{
+		{Timestamp: start.Add(24*time.Hour + 10*time.Second), Station: "NZ_EYWM_51", Component: "X", Value: 20100.0},
+		{Timestamp: start.Add(48 * time.Hour), Station: "NZ_EYWM_51", Component: "X", Value: 20110.0},
+		{Timestamp: start.Add(50 * time.Hour), Station: "NZ_EYWM_51", Component: "Y", Value: 0.0},
+		{Timestamp: start.Add(30*time.Hour + 30*time.Minute), Station: "NZ_EYWM_51", Component: "X", Value: 0.0},
+	}
+
+	points := Points(observations, "NZ_EYWM_51", "X", readings, time.Minute)
+	if len(points) != 2 || points[0].Value != 20000.0 || points[1].Value != 20010.0 {
+		t.Fatalf("unexpected baseline points: %v", points)
+	}
+
+	curve, err := Fit(FitLinear, points)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adjusted, definitive, base := Apply("NZ_EYWM_51_X", curve, readings, 2)
+	if len(adjusted.Readings) != len(readings) || len(base.Readings) != len(readings) {
+		t.Errorf("expected every reading to be adjusted: %d %d", len(adjusted.Readings), len(base.Readings))
+	}
+	if n := len(definitive.Readings); n != 24 {
+		t.Errorf("expected 24 quasi-definitive readings got %d", n)
+	}
+	if v := adjusted.Readings[36].Field; math.Abs(v-20105.0) > 0.01 {
+		t.Errorf("expected an adjusted value of 20105 got %g", v)
+	}
+	if v := base.Readings[0].Field; v != 20000.0 {
+		t.Errorf("expected the baseline to be held before the observations got %g", v)
+	}
+}
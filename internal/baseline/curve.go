@@ -0,0 +1,130 @@
+package baseline
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Supported baseline fitting methods.
+const (
+	FitLinear = "linear"
+	FitSpline = "spline"
+)
+
+// Point is a baseline value, the absolute observation less the variometer reading, at a given time.
+type Point struct {
+	Timestamp time.Time `json:"time"`
+	Value     float64   `json:"value"`
+}
+
+// Curve is a baseline fitted through a set of points, either piecewise linear or a natural cubic
+// spline. The curve is held flat at the first and last values outside the span of the points.
+type Curve struct {
+	Method string
+	Points []Point
+
+	// second derivatives of the spline at each point
+	second []float64
+}
+
+// Fit returns a baseline Curve through the points using the given method, points at the same time are averaged.
+func Fit(method string, points []Point) (*Curve, error) {
+	switch method {
+	case FitLinear, FitSpline:
+	default:
+		return nil, fmt.Errorf("unknown baseline fit: %s", method)
+	}
+
+	sums := make(map[int64][]float64)
+	for _, p := range points {
+		sums[p.Timestamp.UnixNano()] = append(sums[p.Timestamp.UnixNano()], p.Value)
+	}
+	if !(len(sums) > 0) {
+		return nil, fmt.Errorf("no baseline points to fit")
+	}
+
+	c := Curve{Method: method}
+	for k, v := range sums {
+		var sum float64
+		for _, x := range v {
+			sum += x
+		}
+		c.Points = append(c.Points, Point{Timestamp: time.Unix(0, k).UTC(), Value: sum / float64(len(v))})
+	}
+	sort.Slice(c.Points, func(i, j int) bool {
+		return c.Points[i].Timestamp.Before(c.Points[j].Timestamp)
+	})
+
+	if method == FitSpline {
+		c.second = c.spline()
+	}
+
+	return &c, nil
+}
+
+// x returns the time of a point in seconds from the first point.
+func (c *Curve) x(at time.Time) float64 {
+	return at.Sub(c.Points[0].Timestamp).Seconds()
+}
+
+// spline returns the second derivatives of the natural cubic spline through the points.
+func (c *Curve) spline() []float64 {
+	n := len(c.Points)
+
+	second := make([]float64, n)
+	if n < 3 {
+		return second
+	}
+
+	u := make([]float64, n)
+	for i := 1; i < n-1; i++ {
+		x0, x1, x2 := c.x(c.Points[i-1].Timestamp), c.x(c.Points[i].Timestamp), c.x(c.Points[i+1].Timestamp)
+		y0, y1, y2 := c.Points[i-1].Value, c.Points[i].Value, c.Points[i+1].Value
+
+		sig := (x1 - x0) / (x2 - x0)
+		p := sig*second[i-1] + 2.0
+		second[i] = (sig - 1.0) / p
+		u[i] = (y2-y1)/(x2-x1) - (y1-y0)/(x1-x0)
+		u[i] = (6.0*u[i]/(x2-x0) - sig*u[i-1]) / p
+	}
+	for k := n - 2; k >= 0; k-- {
+		second[k] = second[k]*second[k+1] + u[k]
+	}
+
+	return second
+}
+
+// Covers returns whether a time is within the span of the baseline points.
+func (c *Curve) Covers(at time.Time) bool {
+	return !at.Before(c.Points[0].Timestamp) && !at.After(c.Points[len(c.Points)-1].Timestamp)
+}
+
+// Value returns the baseline value at the given time.
+func (c *Curve) Value(at time.Time) float64 {
+	n := len(c.Points)
+	switch {
+	case !at.After(c.Points[0].Timestamp):
+		return c.Points[0].Value
+	case !at.Before(c.Points[n-1].Timestamp):
+		return c.Points[n-1].Value
+	}
+
+	hi := sort.Search(n, func(i int) bool {
+		return c.Points[i].Timestamp.After(at)
+	})
+	lo := hi - 1
+
+	x, x0, x1 := c.x(at), c.x(c.Points[lo].Timestamp), c.x(c.Points[hi].Timestamp)
+	y0, y1 := c.Points[lo].Value, c.Points[hi].Value
+
+	h := x1 - x0
+	a, b := (x1-x)/h, (x-x0)/h
+
+	v := a*y0 + b*y1
+	if c.second != nil {
+		v += ((a*a*a-a)*c.second[lo] + (b*b*b-b)*c.second[hi]) * (h * h) / 6.0
+	}
+
+	return v
+}
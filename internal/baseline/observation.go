@@ -0,0 +1,132 @@
+// Package baseline fits baselines to absolute observations and applies them to variometer readings.
+package baseline
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozym/geomag/internal/rotate"
+)
+
+const observationFormat = "2006-01-02T15:04:05Z"
+
+// Observation is an absolute value of a single field component at a station, declinations and
+// inclinations are in minutes of arc.
+type Observation struct {
+	Timestamp time.Time
+	Station   string
+	Component string
+	Value     float64
+}
+
+// DecodeObservations reads absolute observations from CSV data with time, station, component and
+// value columns, an optional header line starting with "time" is skipped.
+func DecodeObservations(rd io.Reader) ([]Observation, error) {
+	reader := csv.NewReader(rd)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var observations []Observation
+	for _, l := range records {
+		if len(l) < 4 || strings.TrimSpace(l[0]) == "time" {
+			continue
+		}
+
+		t, err := time.Parse(observationFormat, strings.TrimSpace(l[0]))
+		if err != nil {
+			return nil, err
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(l[3]), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		observations = append(observations, Observation{
+			Timestamp: t,
+			Station:   strings.TrimSpace(l[1]),
+			Component: strings.ToUpper(strings.TrimSpace(l[2])),
+			Value:     v,
+		})
+	}
+
+	return observations, nil
+}
+
+// LoadObservations reads absolute observations from a CSV file.
+func LoadObservations(path string) ([]Observation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	observations, err := DecodeObservations(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return observations, nil
+}
+
+// Expand adds X, Y, Z and H components to any observations made up of D, I and F components at
+// the same time, existing components are left alone. The result is sorted by time.
+func Expand(observations []Observation) []Observation {
+	type key struct {
+		t       int64
+		station string
+	}
+
+	found := make(map[key]map[string]Observation)
+	for _, o := range observations {
+		k := key{o.Timestamp.UnixNano(), o.Station}
+		if _, ok := found[k]; !ok {
+			found[k] = make(map[string]Observation)
+		}
+		found[k][o.Component] = o
+	}
+
+	res := append([]Observation{}, observations...)
+	for _, c := range found {
+		d, okd := c["D"]
+		i, oki := c["I"]
+		f, okf := c["F"]
+		if !okd || !oki || !okf {
+			continue
+		}
+
+		v := rotate.FromDIF(d.Value, i.Value, f.Value)
+		for code, value := range map[string]float64{"X": v.X, "Y": v.Y, "Z": v.Z, "H": v.H()} {
+			if _, ok := c[code]; ok {
+				continue
+			}
+			res = append(res, Observation{
+				Timestamp: d.Timestamp,
+				Station:   d.Station,
+				Component: code,
+				Value:     value,
+			})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		switch {
+		case res[i].Timestamp.Equal(res[j].Timestamp):
+			return res[i].Component < res[j].Component
+		default:
+			return res[i].Timestamp.Before(res[j].Timestamp)
+		}
+	})
+
+	return res
+}
@@ -0,0 +1,207 @@
+// Package calib provides per channel calibrations for converting raw sample counts into physical units.
+package calib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Calibration converts the raw samples of matching channels, any polynomial coefficients
+// are applied first (c0 + c1*x + c2*x^2 ...) followed by the gain and offset. A missing
+// or zero gain is treated as unity.
+type Calibration struct {
+	// Srcname is a channel srcname glob pattern, e.g. NZ_EYWM_51_LF?
+	Srcname    string    `json:"srcname" yaml:"srcname"`
+	Gain       float64   `json:"gain,omitempty" yaml:"gain,omitempty"`
+	Offset     float64   `json:"offset,omitempty" yaml:"offset,omitempty"`
+	Polynomial []float64 `json:"polynomial,omitempty" yaml:"polynomial,omitempty"`
+	// Start and End give an optional validity window, the end time is exclusive.
+	Start time.Time `json:"start,omitempty" yaml:"start,omitempty"`
+	End   time.Time `json:"end,omitempty" yaml:"end,omitempty"`
+}
+
+// Match returns whether the calibration applies to the channel at the given time.
+func (c Calibration) Match(srcname string, at time.Time) bool {
+	if ok, err := path.Match(c.Srcname, srcname); err != nil || !ok {
+		return false
+	}
+	if !c.Start.IsZero() && at.Before(c.Start) {
+		return false
+	}
+	if !c.End.IsZero() && !at.Before(c.End) {
+		return false
+	}
+	return true
+}
+
+// Apply converts a raw sample value.
+func (c Calibration) Apply(v float64) float64 {
+	if len(c.Polynomial) > 0 {
+		var sum float64
+		for i := len(c.Polynomial) - 1; i >= 0; i-- {
+			sum = sum*v + c.Polynomial[i]
+		}
+		v = sum
+	}
+
+	gain := c.Gain
+	if gain == 0.0 {
+		gain = 1.0
+	}
+
+	return gain*v + c.Offset
+}
+
+// Table holds a list of calibrations, the first matching entry is used.
+type Table []Calibration
+
+// Find returns the first calibration that applies to the channel at the given time.
+func (t Table) Find(srcname string, at time.Time) (Calibration, bool) {
+	for _, c := range t {
+		if c.Match(srcname, at) {
+			return c, true
+		}
+	}
+	return Calibration{}, false
+}
+
+// Convert calibrates a raw sample value using the first matching calibration, the given
+// default gain is applied if no calibration applies.
+func (t Table) Convert(srcname string, at time.Time, v, gain float64) float64 {
+	if c, ok := t.Find(srcname, at); ok {
+		return c.Apply(v)
+	}
+	return gain * v
+}
+
+// Calibrated returns whether any calibration applies to the channel, regardless of time.
+func (t Table) Calibrated(srcname string) bool {
+	for _, c := range t {
+		if ok, err := path.Match(c.Srcname, srcname); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadTable reads a calibration table from a YAML, JSON or CSV file, the format is
+// chosen using the file extension.
+func LoadTable(name string) (Table, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var table Table
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.NewDecoder(file).Decode(&table); err != nil {
+			return nil, err
+		}
+	case ".csv":
+		if table, err = DecodeCSV(file); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown calibration file format: %s", ext)
+	}
+
+	for _, c := range table {
+		if _, err := path.Match(c.Srcname, ""); err != nil {
+			return nil, fmt.Errorf("invalid calibration srcname pattern %q: %v", c.Srcname, err)
+		}
+	}
+
+	return table, nil
+}
+
+// DecodeCSV reads a calibration table from CSV data with a header line naming the columns,
+// recognised columns are srcname, gain, offset, polynomial (space separated coefficients),
+// start and end (RFC3339 times). Empty values are ignored.
+func DecodeCSV(rd io.Reader) (Table, error) {
+	reader := csv.NewReader(rd)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if !(len(records) > 0) {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for i, h := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := columns["srcname"]; !ok {
+		return nil, fmt.Errorf("missing srcname column in calibration header")
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var table Table
+	for n, record := range records[1:] {
+		c := Calibration{
+			Srcname: field(record, "srcname"),
+		}
+
+		if s := field(record, "gain"); s != "" {
+			if c.Gain, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("invalid gain in calibration %d: %v", n+1, err)
+			}
+		}
+		if s := field(record, "offset"); s != "" {
+			if c.Offset, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("invalid offset in calibration %d: %v", n+1, err)
+			}
+		}
+		for _, s := range strings.Fields(field(record, "polynomial")) {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid polynomial in calibration %d: %v", n+1, err)
+			}
+			c.Polynomial = append(c.Polynomial, v)
+		}
+		if s := field(record, "start"); s != "" {
+			if c.Start, err = time.Parse(time.RFC3339, s); err != nil {
+				return nil, fmt.Errorf("invalid start time in calibration %d: %v", n+1, err)
+			}
+		}
+		if s := field(record, "end"); s != "" {
+			if c.End, err = time.Parse(time.RFC3339, s); err != nil {
+				return nil, fmt.Errorf("invalid end time in calibration %d: %v", n+1, err)
+			}
+		}
+
+		table = append(table, c)
+	}
+
+	return table, nil
+}
@@ -0,0 +1,133 @@
+package calib
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCalibration_Apply(t *testing.T) {
+	for _, v := range []struct {
+		c      Calibration
+		in     float64
+		expect float64
+	}{
+		{Calibration{}, 10, 10},
+		{Calibration{Gain: 0.5}, 10, 5},
+		{Calibration{Gain: 2, Offset: -3}, 10, 17},
+		{Calibration{Polynomial: []float64{1, 2, 3}}, 2, 17},
+		{Calibration{Gain: 10, Offset: 1, Polynomial: []float64{0, 0.5}}, 4, 21},
+	} {
+		if r := v.c.Apply(v.in); r != v.expect {
+			t.Errorf("expected %g got %g for %+v", v.expect, r, v.c)
+		}
+	}
+}
+
+func TestTable_Find(t *testing.T) {
+	change := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	table := Table{
+		{Srcname: "NZ_EYWM_51_LF?", Gain: 0.1, End: change},
+		{Srcname: "NZ_EYWM_51_LF?", Gain: 0.2, Start: change},
+		{Srcname: "NZ_EYWM_50_LKO", Offset: -273.15},
+	}
+
+	for _, v := range []struct {
+		srcname string
+		at      time.Time
+		gain    float64
+		ok      bool
+	}{
+		{"NZ_EYWM_51_LFZ", change.Add(-time.Second), 0.1, true},
+		{"NZ_EYWM_51_LFZ", change, 0.2, true},
+		{"NZ_EYWM_50_LKO", change, 0, true},
+		{"NZ_EYWM_51_LKO", change, 0, false},
+	} {
+		c, ok := table.Find(v.srcname, v.at)
+		if ok != v.ok || c.Gain != v.gain {
+			t.Errorf("unexpected calibration for %s at %s: %+v (%v)", v.srcname, v.at, c, ok)
+		}
+	}
+
+	if v := table.Convert("NZ_EYWM_51_LFZ", change, 10, 3); v != 2 {
+		t.Errorf("expected calibrated value 2 got %g", v)
+	}
+	if v := table.Convert("NZ_SMHS_51_LFZ", change, 10, 3); v != 30 {
+		t.Errorf("expected default gain value 30 got %g", v)
+	}
+
+	if !table.Calibrated("NZ_EYWM_51_LFX") || table.Calibrated("NZ_SMHS_51_LFX") {
+		t.Error("unexpected calibrated channel check")
+	}
+}
+
+func TestLoadTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"calib.yaml": `
+- srcname: NZ_EYWM_51_LF?
+  gain: 0.1
+  offset: 10
+  polynomial: [0, 1, 0.001]
+  start: 2019-01-01T00:00:00Z
+  end: 2020-01-01T00:00:00Z
+- srcname: NZ_EYWM_50_LKO
+  offset: -273.15
+`,
+		"calib.json": `[
+  {"srcname": "NZ_EYWM_51_LF?", "gain": 0.1, "offset": 10, "polynomial": [0, 1, 0.001], "start": "2019-01-01T00:00:00Z", "end": "2020-01-01T00:00:00Z"},
+  {"srcname": "NZ_EYWM_50_LKO", "offset": -273.15}
+]`,
+		"calib.csv": `# channel calibrations
+srcname,gain,offset,polynomial,start,end
+NZ_EYWM_51_LF?,0.1,10,0 1 0.001,2019-01-01T00:00:00Z,2020-01-01T00:00:00Z
+NZ_EYWM_50_LKO,,-273.15,,,
+`,
+	}
+
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			table, err := LoadTable(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(table) != 2 {
+				t.Fatalf("expected 2 calibrations got %d", len(table))
+			}
+
+			at := time.Date(2019, time.May, 26, 0, 0, 0, 0, time.UTC)
+
+			c, ok := table.Find("NZ_EYWM_51_LFZ", at)
+			if !ok {
+				t.Fatal("missing calibration")
+			}
+			if v := c.Apply(100); math.Abs(v-21.0) > 1.0e-9 {
+				t.Errorf("unexpected calibrated value: %g", v)
+			}
+			if _, ok := table.Find("NZ_EYWM_51_LFZ", at.AddDate(1, 0, 0)); ok {
+				t.Error("calibration should have expired")
+			}
+			if c, ok := table.Find("NZ_EYWM_50_LKO", at); !ok || math.Abs(c.Apply(300)-26.85) > 1.0e-9 {
+				t.Errorf("unexpected temperature calibration: %+v", c)
+			}
+		})
+	}
+
+	if _, err := LoadTable(filepath.Join(dir, "calib.txt")); err == nil {
+		t.Error("shouldn't be able to load an unknown file format")
+	}
+}
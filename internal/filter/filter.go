@@ -0,0 +1,228 @@
+// Package filter builds filtered and decimated values from raw readings.
+package filter
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+const (
+	// MinuteSigma is the standard deviation, in seconds, of the INTERMAGNET Gaussian
+	// filter used to build one-minute values from one-second data.
+	MinuteSigma = 15.8734
+	// MinuteHalfWidth is the number of one-second samples either side of the minute
+	// used by the INTERMAGNET filter, giving 91 coefficients in total.
+	MinuteHalfWidth = 45
+	// DefaultCoverage is the minimum fraction of filter samples needed for a value.
+	DefaultCoverage = 0.9
+	// DefaultPrecision is the number of decimal places used for filtered values.
+	DefaultPrecision = 2
+)
+
+// Precision returns the number of decimal places to use for filtered values, a negative
+// dp gives the default precision.
+func Precision(dp int) int {
+	if dp < 0 {
+		return DefaultPrecision
+	}
+	return dp
+}
+
+// Gaussian returns normalised symmetric Gaussian filter coefficients for the given standard
+// deviation, in samples, and number of samples either side of the centre.
+func Gaussian(sigma float64, half int) []float64 {
+	weights := make([]float64, 2*half+1)
+
+	var sum float64
+	for i := range weights {
+		n := float64(i - half)
+		weights[i] = math.Exp(-0.5 * (n / sigma) * (n / sigma))
+		sum += weights[i]
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+
+	return weights
+}
+
+// Filter applies a symmetric filter centred on regular output intervals to the readings of
+// a single channel. Readings are snapped to the nearest input sample period and may be added
+// incrementally, filtered values are produced once the filter window has been passed.
+//
+// Missing input samples are allowed as long as the fraction of samples present is at least
+// the Coverage, the available filter weights are renormalised to compensate.
+type Filter struct {
+	Label    string
+	Period   time.Duration
+	Interval time.Duration
+	Coverage float64
+	Weights  []float64
+
+	samples map[time.Time]float64
+	next    time.Time
+	latest  time.Time
+}
+
+// NewFilter returns a Filter using the given filter weights which are centred on each output interval.
+func NewFilter(label string, period, interval time.Duration, weights []float64) *Filter {
+	return &Filter{
+		Label:    label,
+		Period:   period,
+		Interval: interval,
+		Coverage: DefaultCoverage,
+		Weights:  weights,
+		samples:  make(map[time.Time]float64),
+	}
+}
+
+// NewMinute returns the INTERMAGNET standard 91 point Gaussian filter for building one-minute
+// values centred on the minute from one-second readings.
+func NewMinute(label string) *Filter {
+	return NewFilter(label, time.Second, time.Minute, Gaussian(MinuteSigma, MinuteHalfWidth))
+}
+
+// half returns the time span either side of the filter centre.
+func (f *Filter) half() time.Duration {
+	return time.Duration(len(f.Weights)/2) * f.Period
+}
+
+// Add includes a reading, readings too old to affect any remaining output values are ignored
+// as are missing or flagged values.
+func (f *Filter) Add(r raw.Reading) {
+	if math.IsNaN(r.Field) || r.Flag != "" {
+		return
+	}
+
+	at := r.Timestamp.Round(f.Period)
+	if !f.next.IsZero() && at.Before(f.next.Add(-f.half())) {
+		return
+	}
+	if f.next.IsZero() {
+		f.next = at.Truncate(f.Interval)
+	}
+	if at.After(f.latest) {
+		f.latest = at
+	}
+
+	f.samples[at] = r.Field
+}
+
+// AddRaw includes all the readings from a raw data set.
+func (f *Filter) AddRaw(r *raw.Raw) {
+	readings := append([]raw.Reading{}, r.Readings...)
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Less(readings[j])
+	})
+	for _, v := range readings {
+		f.Add(v)
+	}
+}
+
+// value returns the filtered value centred at the given time, and the number of samples used.
+func (f *Filter) value(at time.Time) (float64, int) {
+	half := len(f.Weights) / 2
+
+	var sum, weight float64
+	var count int
+	for i, w := range f.Weights {
+		v, ok := f.samples[at.Add(time.Duration(i-half)*f.Period)]
+		if !ok {
+			continue
+		}
+		sum += w * v
+		weight += w
+		count++
+	}
+
+	if !(weight > 0.0) {
+		return 0.0, 0
+	}
+
+	return sum / weight, count
+}
+
+// skip moves the next output time forward past any gap in the readings.
+func (f *Filter) skip() {
+	start := f.next.Add(-f.half())
+
+	var first time.Time
+	for t := range f.samples {
+		if t.Before(start) {
+			continue
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+	}
+
+	if first.IsZero() {
+		f.next = f.latest.Add(f.half() + f.Interval).Truncate(f.Interval)
+		return
+	}
+
+	switch t := first.Add(-f.half()).Truncate(f.Interval); {
+	case t.After(f.next):
+		f.next = t
+	default:
+		f.next = f.next.Add(f.Interval)
+	}
+}
+
+// Flush returns the filtered values with a complete filter window, if final is set then any
+// remaining values with enough coverage are also returned.
+func (f *Filter) Flush(final bool) []raw.Reading {
+	var readings []raw.Reading
+
+	for !f.next.IsZero() {
+		end := f.next.Add(f.half())
+		if final {
+			end = f.next.Add(-f.half())
+		}
+		if end.After(f.latest) {
+			break
+		}
+
+		v, n := f.value(f.next)
+		switch {
+		case n == 0:
+			// jump over any gap rather than stepping through it
+			f.skip()
+			continue
+		case float64(n) >= f.Coverage*float64(len(f.Weights)):
+			readings = append(readings, raw.NewReading(f.next, f.Label, v))
+		}
+
+		f.next = f.next.Add(f.Interval)
+	}
+
+	start := f.next.Add(-f.half())
+	for t := range f.samples {
+		if t.Before(start) {
+			delete(f.samples, t)
+		}
+	}
+
+	return readings
+}
+
+// Raw returns the flushed filtered values as raw data.
+func (f *Filter) Raw(final bool, precision int) *raw.Raw {
+	r := raw.NewRaw(f.Label, precision)
+	for _, v := range f.Flush(final) {
+		r.Add(v)
+	}
+	return r
+}
+
+// Minutes returns the INTERMAGNET one-minute values for a complete set of one-second readings.
+func Minutes(r *raw.Raw, coverage float64, precision int) *raw.Raw {
+	f := NewMinute(r.Label)
+	f.Coverage = coverage
+	f.AddRaw(r)
+
+	return f.Raw(true, precision)
+}
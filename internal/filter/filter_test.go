@@ -0,0 +1,151 @@
+package filter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+func testRaw(start time.Time, n int, fn func(int) float64) *raw.Raw {
+	r := raw.NewRaw("NZ_EYWM_51_LFZ", -1)
+	for i := 0; i < n; i++ {
+		r.Add(raw.NewReading(start.Add(time.Duration(i)*time.Second), r.Label, fn(i)))
+	}
+	return r
+}
+
+func TestGaussian(t *testing.T) {
+	weights := Gaussian(MinuteSigma, MinuteHalfWidth)
+	if len(weights) != 91 {
+		t.Fatalf("expected 91 weights got %d", len(weights))
+	}
+
+	var sum float64
+	for i, w := range weights {
+		sum += w
+		if w != weights[len(weights)-1-i] {
+			t.Errorf("weights are not symmetric at %d", i)
+		}
+		if i > 0 && i <= MinuteHalfWidth && !(w > weights[i-1]) {
+			t.Errorf("weights are not increasing towards the centre at %d", i)
+		}
+	}
+	if math.Abs(sum-1.0) > 1.0e-12 {
+		t.Errorf("weights are not normalised: %g", sum)
+	}
+}
+
+func TestMinutes(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// a linear ramp is unchanged by a symmetric filter
+	minutes := Minutes(testRaw(start, 3600, func(i int) float64 { return 1000.0 + 0.5*float64(i) }), DefaultCoverage, DefaultPrecision)
+
+	// the first and last minutes don't have enough coverage
+	if len(minutes.Readings) != 59 {
+		t.Fatalf("expected 59 minute values got %d", len(minutes.Readings))
+	}
+	for _, v := range minutes.Readings {
+		if v.Timestamp.Second() != 0 || v.Timestamp.Nanosecond() != 0 {
+			t.Errorf("minute value not centred on the minute: %s", v.Timestamp)
+		}
+		expect := 1000.0 + 0.5*v.Timestamp.Sub(start).Seconds()
+		if math.Abs(v.Field-expect) > 1.0e-9 {
+			t.Errorf("unexpected minute value at %s: %g != %g", v.Timestamp, v.Field, expect)
+		}
+	}
+	if minutes.Label != "NZ_EYWM_51_LFZ" || minutes.Precision != DefaultPrecision {
+		t.Errorf("unexpected minute raw details: %s %d", minutes.Label, minutes.Precision)
+	}
+}
+
+func TestFilter_Coverage(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	centre := start.Add(2 * time.Minute)
+
+	for _, v := range []struct {
+		missing int
+		ok      bool
+	}{
+		{0, true},
+		{9, true},
+		{10, false},
+	} {
+		r := raw.NewRaw("NZ_EYWM_51_LFZ", -1)
+		for i := 0; i < 240; i++ {
+			at := start.Add(time.Duration(i) * time.Second)
+			if d := at.Sub(centre); d >= 0 && d < time.Duration(v.missing)*time.Second {
+				continue
+			}
+			// slightly offset sample times are snapped to the nearest second
+			r.Add(raw.NewReading(at.Add(20*time.Millisecond), r.Label, 10.0))
+		}
+
+		var found bool
+		for _, m := range Minutes(r, DefaultCoverage, DefaultPrecision).Readings {
+			if m.Timestamp.Equal(centre) {
+				found = true
+				if math.Abs(m.Field-10.0) > 1.0e-9 {
+					t.Errorf("unexpected minute value: %g", m.Field)
+				}
+			}
+		}
+		if found != v.ok {
+			t.Errorf("unexpected minute value with %d missing samples: %v", v.missing, found)
+		}
+	}
+}
+
+func TestFilter_Flush(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	fn := func(i int) float64 { return math.Sin(float64(i) / 100.0) }
+	all := testRaw(start, 7200, fn)
+	expect := Minutes(all, DefaultCoverage, DefaultPrecision)
+
+	// add the readings in packets, including a repeated packet
+	f := NewMinute(all.Label)
+
+	var readings []raw.Reading
+	for i := 0; i < len(all.Readings); i += 412 {
+		j := i + 412
+		if j > len(all.Readings) {
+			j = len(all.Readings)
+		}
+		for _, v := range all.Readings[i:j] {
+			f.Add(v)
+		}
+		readings = append(readings, f.Flush(false)...)
+		for _, v := range all.Readings[i:j] {
+			f.Add(v)
+		}
+	}
+	readings = append(readings, f.Flush(true)...)
+
+	if len(readings) != len(expect.Readings) {
+		t.Fatalf("expected %d minute values got %d", len(expect.Readings), len(readings))
+	}
+	for i, v := range readings {
+		if !v.Timestamp.Equal(expect.Readings[i].Timestamp) || math.Abs(v.Field-expect.Readings[i].Field) > 1.0e-12 {
+			t.Errorf("unexpected minute value %d: %v != %v", i, v, expect.Readings[i])
+		}
+	}
+}
+
+func TestFilter_Gap(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	f := NewMinute("NZ_EYWM_51_LFZ")
+	f.AddRaw(testRaw(start, 600, func(int) float64 { return 1.0 }))
+	f.AddRaw(testRaw(start.AddDate(1, 0, 0), 600, func(int) float64 { return 2.0 }))
+
+	readings := f.Flush(true)
+	if len(readings) != 18 {
+		t.Fatalf("expected 18 minute values got %d", len(readings))
+	}
+	if v := readings[len(readings)-1]; v.Field != 2.0 || v.Timestamp.Before(start.AddDate(1, 0, 0)) {
+		t.Errorf("unexpected minute value after gap: %v", v)
+	}
+}
@@ -0,0 +1,158 @@
+package filter
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// Mean builds interval means, e.g. hourly or daily, from the regularly sampled readings of a
+// single channel. Readings are snapped to the nearest sample period and may be added incrementally.
+//
+// A mean is only produced once the readings span the whole interval, partial intervals at the
+// edges of the data are left alone. Intervals with fewer than the Coverage fraction of expected
+// samples are given a NaN value to mark them as missing, intervals without any readings are skipped.
+// Means are time stamped at the centre of each interval.
+type Mean struct {
+	Label    string
+	Period   time.Duration
+	Interval time.Duration
+	Coverage float64
+
+	samples map[time.Time]float64
+	next    time.Time
+	latest  time.Time
+}
+
+// NewMean returns a Mean for readings with the given sample period.
+func NewMean(label string, period, interval time.Duration) *Mean {
+	return &Mean{
+		Label:    label,
+		Period:   period,
+		Interval: interval,
+		Coverage: DefaultCoverage,
+		samples:  make(map[time.Time]float64),
+	}
+}
+
+// NewHourly returns a Mean for building hourly means.
+func NewHourly(label string, period time.Duration) *Mean {
+	return NewMean(label, period, time.Hour)
+}
+
+// NewDaily returns a Mean for building daily means.
+func NewDaily(label string, period time.Duration) *Mean {
+	return NewMean(label, period, 24*time.Hour)
+}
+
+// Add includes a reading, readings before any remaining interval are ignored as are missing or flagged values.
+func (m *Mean) Add(r raw.Reading) {
+	if math.IsNaN(r.Field) || r.Flag != "" {
+		return
+	}
+
+	at := r.Timestamp.Round(m.Period)
+	if m.next.IsZero() {
+		// skip the first interval unless it is seen from the start
+		switch m.next = at.Truncate(m.Interval); {
+		case at.After(m.next):
+			m.next = m.next.Add(m.Interval)
+		}
+	}
+	if at.Before(m.next) {
+		return
+	}
+	if at.After(m.latest) {
+		m.latest = at
+	}
+
+	m.samples[at] = r.Field
+}
+
+// AddRaw includes all the readings from a raw data set.
+func (m *Mean) AddRaw(r *raw.Raw) {
+	readings := append([]raw.Reading{}, r.Readings...)
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Less(readings[j])
+	})
+	for _, v := range readings {
+		m.Add(v)
+	}
+}
+
+// Flush returns the means of any intervals spanned by the readings.
+func (m *Mean) Flush() []raw.Reading {
+	var readings []raw.Reading
+
+	expected := float64(m.Interval / m.Period)
+	for !m.next.IsZero() && !m.next.Add(m.Interval-m.Period).After(m.latest) {
+		end := m.next.Add(m.Interval)
+
+		var sum float64
+		var count int
+		for t, v := range m.samples {
+			if t.Before(m.next) || !t.Before(end) {
+				continue
+			}
+			sum += v
+			count++
+		}
+
+		switch {
+		case count == 0:
+		case float64(count) >= m.Coverage*expected:
+			readings = append(readings, raw.NewReading(m.next.Add(m.Interval/2), m.Label, sum/float64(count)))
+		default:
+			readings = append(readings, raw.NewReading(m.next.Add(m.Interval/2), m.Label, math.NaN()))
+		}
+
+		for t := range m.samples {
+			if t.Before(end) {
+				delete(m.samples, t)
+			}
+		}
+
+		m.next = end
+
+		// jump over any gap rather than stepping through it
+		if count == 0 {
+			m.skip()
+		}
+	}
+
+	return readings
+}
+
+// skip moves the next interval forward to the earliest remaining reading.
+func (m *Mean) skip() {
+	var first time.Time
+	for t := range m.samples {
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+	}
+	if t := first.Truncate(m.Interval); !first.IsZero() && t.After(m.next) {
+		m.next = t
+	}
+}
+
+// Raw returns the flushed means as raw data.
+func (m *Mean) Raw(precision int) *raw.Raw {
+	r := raw.NewRaw(m.Label, precision)
+	for _, v := range m.Flush() {
+		r.Add(v)
+	}
+	return r
+}
+
+// Means returns the interval means of a complete set of readings with the given sample period,
+// such as those loaded from stored raw files.
+func Means(r *raw.Raw, period, interval time.Duration, coverage float64, precision int) *raw.Raw {
+	m := NewMean(r.Label, period, interval)
+	m.Coverage = coverage
+	m.AddRaw(r)
+
+	return m.Raw(precision)
+}
@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+func TestMeans(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	r := raw.NewRaw("NZ_EYWM_51_LFZ", -1)
+	for i := 0; i < 4*60; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		// a short gap in the second hour and a longer gap in the third
+		if (i >= 70 && i < 75) || (i >= 130 && i < 140) {
+			continue
+		}
+		r.Add(raw.NewReading(at, r.Label, float64(i/60)))
+	}
+	// a partial hour at the end is left alone
+	r.Add(raw.NewReading(start.Add(4*time.Hour), r.Label, 4.0))
+
+	means := Means(r, time.Minute, time.Hour, DefaultCoverage, DefaultPrecision)
+	if len(means.Readings) != 4 {
+		t.Fatalf("expected 4 hourly means got %d", len(means.Readings))
+	}
+	for i, v := range means.Readings {
+		if at := start.Add(time.Duration(i)*time.Hour + 30*time.Minute); !v.Timestamp.Equal(at) {
+			t.Errorf("unexpected hourly mean time: %s != %s", v.Timestamp, at)
+		}
+		switch i {
+		case 2:
+			if !math.IsNaN(v.Field) {
+				t.Errorf("expected missing hourly mean got %g", v.Field)
+			}
+		default:
+			if v.Field != float64(i) {
+				t.Errorf("unexpected hourly mean: %g != %d", v.Field, i)
+			}
+		}
+	}
+}
+
+func TestMean_Flush(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// the first partial interval is skipped
+	m := NewHourly("NZ_EYWM_51_LFZ", time.Second)
+	for i := 1800; i < 3*3600; i++ {
+		m.Add(raw.NewReading(start.Add(time.Duration(i)*time.Second), m.Label, 1.0))
+		if i == 7200 {
+			if readings := m.Flush(); len(readings) != 1 || !readings[0].Timestamp.Equal(start.Add(90*time.Minute)) {
+				t.Errorf("unexpected hourly means: %v", readings)
+			}
+		}
+	}
+
+	// an interval is flushed once its last sample has arrived
+	readings := m.Flush()
+	if len(readings) != 1 || readings[0].Field != 1.0 {
+		t.Errorf("unexpected final hourly means: %v", readings)
+	}
+
+	// a long gap is skipped rather than marked as missing
+	m.Add(raw.NewReading(start.AddDate(1, 0, 0), m.Label, 2.0))
+	for i := 0; i < 3600; i++ {
+		m.Add(raw.NewReading(start.AddDate(1, 0, 0).Add(time.Duration(i)*time.Second), m.Label, 2.0))
+	}
+	if readings := m.Flush(); len(readings) != 1 || readings[0].Field != 2.0 {
+		t.Errorf("unexpected hourly means after a gap: %v", readings)
+	}
+}
+
+func TestProducts(t *testing.T) {
+	start := time.Date(2019, time.December, 31, 23, 0, 0, 0, time.UTC)
+
+	p := NewProducts(DefaultCoverage, DefaultPrecision)
+	p.Minute, p.Hourly, p.Daily = true, true, true
+
+	var minutes, hours, days int
+	for h := 0; h < 26; h++ {
+		r := raw.NewRaw("NZ_EYWM_51_LFZ", -1)
+		for i := 0; i < 3600; i++ {
+			r.Add(raw.NewReading(start.Add(time.Duration(h)*time.Hour+time.Duration(i)*time.Second), r.Label, 10.0))
+		}
+		p.Add(r, time.Second)
+
+		m, hr, d := p.Flush(false)
+		for _, v := range m {
+			minutes += len(v.Readings)
+		}
+		for _, v := range hr {
+			hours += len(v.Readings)
+		}
+		for _, v := range d {
+			days += len(v.Readings)
+			if math.Abs(v.Readings[0].Field-10.0) > 1.0e-9 || !v.Readings[0].Timestamp.Equal(start.Add(13*time.Hour)) {
+				t.Errorf("unexpected daily mean: %v", v.Readings[0])
+			}
+		}
+	}
+
+	// the first minute lacks coverage, so the first hour is not seen from its start
+	if minutes != 26*60-1 || hours != 25 || days != 1 {
+		t.Errorf("unexpected number of products: %d minutes, %d hours, %d days", minutes, hours, days)
+	}
+}
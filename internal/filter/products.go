@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// Products builds the optional one-minute, hourly and daily values of a set of channels as
+// raw data is collected. If one-minute values are built then the hourly and daily means are
+// taken from them, otherwise the raw readings are used directly.
+type Products struct {
+	Minute    bool
+	Hourly    bool
+	Daily     bool
+	Coverage  float64
+	Precision int
+
+	minutes map[string]*Filter
+	hours   map[string]*Mean
+	days    map[string]*Mean
+}
+
+// NewProducts returns a Products using the given coverage and number of decimal places.
+func NewProducts(coverage float64, precision int) *Products {
+	return &Products{
+		Coverage:  coverage,
+		Precision: precision,
+		minutes:   make(map[string]*Filter),
+		hours:     make(map[string]*Mean),
+		days:      make(map[string]*Mean),
+	}
+}
+
+// Enabled returns whether any products are to be built.
+func (p *Products) Enabled() bool {
+	return p.Minute || p.Hourly || p.Daily
+}
+
+// mean adds readings to the hourly and daily means of a channel.
+func (p *Products) mean(label string, period time.Duration, readings ...raw.Reading) {
+	if p.Hourly {
+		if _, ok := p.hours[label]; !ok {
+			p.hours[label] = NewHourly(label, period)
+			p.hours[label].Coverage = p.Coverage
+		}
+		for _, v := range readings {
+			p.hours[label].Add(v)
+		}
+	}
+	if p.Daily {
+		if _, ok := p.days[label]; !ok {
+			p.days[label] = NewDaily(label, period)
+			p.days[label].Coverage = p.Coverage
+		}
+		for _, v := range readings {
+			p.days[label].Add(v)
+		}
+	}
+}
+
+// Add includes the readings of a raw data set with the given sample period.
+func (p *Products) Add(r *raw.Raw, period time.Duration) {
+	readings := append([]raw.Reading{}, r.Readings...)
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Less(readings[j])
+	})
+
+	switch {
+	case p.Minute:
+		if _, ok := p.minutes[r.Label]; !ok {
+			p.minutes[r.Label] = NewMinute(r.Label)
+			p.minutes[r.Label].Coverage = p.Coverage
+		}
+		for _, v := range readings {
+			p.minutes[r.Label].Add(v)
+		}
+	default:
+		p.mean(r.Label, period, readings...)
+	}
+}
+
+// Flush returns any completed one-minute values and hourly and daily means, if final is
+// set then any remaining one-minute values are also returned.
+func (p *Products) Flush(final bool) (minutes, hours, days []*raw.Raw) {
+	for k, v := range p.minutes {
+		m := v.Raw(final, p.Precision)
+		if !(len(m.Readings) > 0) {
+			continue
+		}
+		p.mean(k, v.Interval, m.Readings...)
+		minutes = append(minutes, m)
+	}
+	for _, v := range p.hours {
+		if m := v.Raw(p.Precision); len(m.Readings) > 0 {
+			hours = append(hours, m)
+		}
+	}
+	for _, v := range p.days {
+		if m := v.Raw(p.Precision); len(m.Readings) > 0 {
+			days = append(days, m)
+		}
+	}
+
+	return minutes, hours, days
+}
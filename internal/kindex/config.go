@@ -0,0 +1,157 @@
+package kindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Methods used to remove the regular daily variation.
+const (
+	MethodFMI   = "fmi"
+	MethodQuiet = "quiet"
+)
+
+// Station holds the K index settings of a single station. The horizontal components are given
+// either as X and Y channel labels, or as H and D channel labels with D in minutes of arc which
+// is converted to nT using the mean of H. Quiet day curves are only needed for the quiet method,
+// they are keyed by the lower case component name and hold 24 hourly values in the units of the
+// stored readings.
+type Station struct {
+	Station  string               `json:"station" yaml:"station"`
+	K9       float64              `json:"k9" yaml:"k9"`
+	X        string               `json:"x,omitempty" yaml:"x,omitempty"`
+	Y        string               `json:"y,omitempty" yaml:"y,omitempty"`
+	H        string               `json:"h,omitempty" yaml:"h,omitempty"`
+	D        string               `json:"d,omitempty" yaml:"d,omitempty"`
+	Method   string               `json:"method,omitempty" yaml:"method,omitempty"`
+	Quiet    map[string][]float64 `json:"quiet,omitempty" yaml:"quiet,omitempty"`
+	Coverage float64              `json:"coverage,omitempty" yaml:"coverage,omitempty"`
+}
+
+// Channels returns the labels of the two horizontal component channels.
+func (s Station) Channels() (string, string) {
+	if s.H != "" || s.D != "" {
+		return s.H, s.D
+	}
+	return s.X, s.Y
+}
+
+// components returns the quiet curve keys of the two horizontal components.
+func (s Station) components() (string, string) {
+	if s.H != "" || s.D != "" {
+		return "h", "d"
+	}
+	return "x", "y"
+}
+
+// method returns the method to use, FMI if not given.
+func (s Station) method() string {
+	if s.Method == "" {
+		return MethodFMI
+	}
+	return strings.ToLower(s.Method)
+}
+
+// coverage returns the minimum fraction of values needed, the default if not given.
+func (s Station) coverage() float64 {
+	if !(s.Coverage > 0) {
+		return DefaultCoverage
+	}
+	return s.Coverage
+}
+
+// Validate checks the station settings are complete.
+func (s Station) Validate() error {
+	first, second := s.Channels()
+	switch {
+	case !(s.K9 > 0):
+		return fmt.Errorf("invalid k9 limit for station %s: %g", s.Station, s.K9)
+	case first == "" || second == "":
+		return fmt.Errorf("missing horizontal channels for station %s", s.Station)
+	}
+
+	switch s.method() {
+	case MethodFMI:
+	case MethodQuiet:
+		a, b := s.components()
+		for _, c := range []string{a, b} {
+			if len(s.Quiet[c]) != 24 {
+				return fmt.Errorf("expected 24 hourly %s quiet curve values for station %s", c, s.Station)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown k index method for station %s: %s", s.Station, s.Method)
+	}
+
+	return nil
+}
+
+// Indices returns the K indices of each three hour interval of the two horizontal component series.
+func (s Station) Indices(first, second Series) []Index {
+	// declination is converted to nT using the mean field, before any quiet curve is removed
+	h := first.Mean()
+
+	if s.method() == MethodQuiet {
+		a, b := s.components()
+		first, second = first.Subtract(QuietCurve(first, s.Quiet[a])), second.Subtract(QuietCurve(second, s.Quiet[b]))
+	}
+
+	if s.H != "" || s.D != "" {
+		second = second.Scale(h * math.Pi / (180.0 * 60.0))
+	}
+
+	if s.method() == MethodQuiet {
+		return Indices(first, second, s.K9, s.coverage())
+	}
+
+	return FMI(first, second, s.K9, s.coverage())
+}
+
+// Table holds the K index settings of a list of stations.
+type Table []Station
+
+// Find returns the settings of the given station.
+func (t Table) Find(station string) (Station, bool) {
+	for _, s := range t {
+		if s.Station == station {
+			return s, true
+		}
+	}
+	return Station{}, false
+}
+
+// LoadTable reads a table of station settings from a YAML or JSON file, the format is chosen using the file extension.
+func LoadTable(name string) (Table, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var table Table
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown k index file format: %s", ext)
+	}
+
+	for _, s := range table {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
@@ -0,0 +1,272 @@
+// Package kindex computes three hourly K indices from one-minute horizontal component values.
+package kindex
+
+import (
+	"math"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+const (
+	// Interval is the time span covered by each K index.
+	Interval = 3 * time.Hour
+	// Missing is the K index given to an interval without enough data.
+	Missing = -1
+	// DefaultCoverage is the minimum fraction of one-minute values needed for an index.
+	DefaultCoverage = 0.9
+)
+
+// scale holds the lower range limits, in nT, of K indices one to nine for a K9 limit of 500 nT.
+var scale = [...]float64{5, 10, 20, 40, 70, 120, 200, 330, 500}
+
+// Limits returns the lower range limits of K indices one to nine scaled to the given K9 lower limit.
+func Limits(k9 float64) []float64 {
+	limits := make([]float64, len(scale))
+	for i, v := range scale {
+		limits[i] = v * k9 / scale[len(scale)-1]
+	}
+	return limits
+}
+
+// K returns the index of a range of values for the given K9 lower limit.
+func K(rng, k9 float64) int {
+	var k int
+	for i, l := range Limits(k9) {
+		if rng >= l {
+			k = i + 1
+		}
+	}
+	return k
+}
+
+// Index is the K index of a single three hour interval along with the larger of the two
+// horizontal component ranges used to find it.
+type Index struct {
+	Start time.Time `json:"start"`
+	K     int       `json:"k"`
+	Range float64   `json:"range"`
+}
+
+// Series holds regularly spaced one-minute values from a start time, missing values are NaN.
+type Series struct {
+	Start  time.Time
+	Values []float64
+}
+
+// NewSeries builds a one-minute Series between the start and end times, readings are snapped to
+// the nearest minute and flagged readings are treated as missing.
+func NewSeries(start, end time.Time, readings []raw.Reading) Series {
+	s := Series{
+		Start:  start,
+		Values: make([]float64, int(end.Sub(start)/time.Minute)),
+	}
+	for i := range s.Values {
+		s.Values[i] = math.NaN()
+	}
+
+	for _, r := range readings {
+		if r.Flag != "" {
+			continue
+		}
+		if i := int(r.Timestamp.Round(time.Minute).Sub(start) / time.Minute); i >= 0 && i < len(s.Values) {
+			s.Values[i] = r.Field
+		}
+	}
+
+	return s
+}
+
+// Mean returns the mean of the values present, NaN if there are none.
+func (s Series) Mean() float64 {
+	v, _ := mean(s.Values)
+	return v
+}
+
+// Scale returns a copy of the series with each value multiplied by the given factor.
+func (s Series) Scale(factor float64) Series {
+	res := Series{Start: s.Start, Values: make([]float64, len(s.Values))}
+	for i, v := range s.Values {
+		res.Values[i] = v * factor
+	}
+	return res
+}
+
+// Subtract returns a copy of the series with the matching curve values removed.
+func (s Series) Subtract(curve []float64) Series {
+	res := Series{Start: s.Start, Values: make([]float64, len(s.Values))}
+	for i, v := range s.Values {
+		res.Values[i] = v - curve[i]
+	}
+	return res
+}
+
+// mean returns the mean of the values present, and how many there were.
+func mean(values []float64) (float64, int) {
+	var sum float64
+	var n int
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		sum += v
+		n++
+	}
+	if n == 0 {
+		return math.NaN(), 0
+	}
+	return sum / float64(n), n
+}
+
+// span returns the range of the values present, and how many there were.
+func span(values []float64) (float64, int) {
+	var lo, hi float64
+	var n int
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		if n == 0 || v < lo {
+			lo = v
+		}
+		if n == 0 || v > hi {
+			hi = v
+		}
+		n++
+	}
+	return hi - lo, n
+}
+
+// Indices returns the K index of each complete three hour interval of the two horizontal
+// component series, which are expected to share the same start time and length. Intervals
+// with less than the coverage fraction of values in either component are marked as Missing.
+func Indices(x, y Series, k9, coverage float64) []Index {
+	size := int(Interval / time.Minute)
+
+	var indices []Index
+	for i := 0; i+size <= len(x.Values) && i+size <= len(y.Values); i += size {
+		rx, nx := span(x.Values[i : i+size])
+		ry, ny := span(y.Values[i : i+size])
+
+		index := Index{
+			Start: x.Start.Add(time.Duration(i) * time.Minute),
+			K:     Missing,
+			Range: math.Max(rx, ry),
+		}
+		if float64(nx) >= coverage*float64(size) && float64(ny) >= coverage*float64(size) {
+			index.K = K(index.Range, k9)
+		}
+
+		indices = append(indices, index)
+	}
+
+	return indices
+}
+
+// interpolate returns a one-minute curve through the hourly values, which are centred on the
+// half hour, missing hourly values are skipped and the curve is held flat at either end.
+func interpolate(hourly []float64, length int) []float64 {
+	var hours []int
+	for h, v := range hourly {
+		if !math.IsNaN(v) {
+			hours = append(hours, h)
+		}
+	}
+
+	curve := make([]float64, length)
+	for i := range curve {
+		curve[i] = math.NaN()
+	}
+	if len(hours) == 0 {
+		return curve
+	}
+
+	var j int
+	for i := range curve {
+		at := float64(i-30) / 60.0
+		for j+1 < len(hours) && float64(hours[j+1]) <= at {
+			j++
+		}
+		switch h := hours[j]; {
+		case at <= float64(h) || j+1 == len(hours):
+			curve[i] = hourly[h]
+		default:
+			next := hours[j+1]
+			f := (at - float64(h)) / float64(next-h)
+			curve[i] = hourly[h]*(1.0-f) + hourly[next]*f
+		}
+	}
+
+	return curve
+}
+
+const (
+	// fmiExponent gives the extension, in minutes, of each hourly mean window as K^fmiExponent.
+	fmiExponent = 3.3
+	// fmiExtra is a fixed extension, in minutes, added to each hourly mean window.
+	fmiExtra = 30
+	// fmiIterations is the number of times the curve is refined after the initial estimate.
+	fmiIterations = 2
+)
+
+// fmiCurve returns the smoothed curve through the hourly means of the series, the window of each
+// mean is extended either side depending on the K index of the interval holding the hour.
+func fmiCurve(s Series, indices []Index) []float64 {
+	size := int(Interval / time.Hour)
+
+	hourly := make([]float64, len(s.Values)/60)
+	for h := range hourly {
+		var k int
+		if n := h / size; n < len(indices) && indices[n].K > 0 {
+			k = indices[n].K
+		}
+
+		extend := int(math.Pow(float64(k), fmiExponent)) + fmiExtra
+
+		start, end := h*60-extend, (h+1)*60+extend
+		if start < 0 {
+			start = 0
+		}
+		if end > len(s.Values) {
+			end = len(s.Values)
+		}
+
+		hourly[h], _ = mean(s.Values[start:end])
+	}
+
+	return interpolate(hourly, len(s.Values))
+}
+
+// FMI returns the K indices of the two horizontal components using the FMI method, the regular
+// daily variation is estimated from hourly means using windows that widen with the level of
+// activity and is removed before finding the ranges. The initial indices come from the ranges
+// of the unadjusted values and are refined by repeating the estimate with the latest indices.
+func FMI(x, y Series, k9, coverage float64) []Index {
+	indices := Indices(x, y, k9, coverage)
+	for i := 0; i < fmiIterations; i++ {
+		indices = Indices(x.Subtract(fmiCurve(x, indices)), y.Subtract(fmiCurve(y, indices)), k9, coverage)
+	}
+	return indices
+}
+
+// QuietCurve returns the one-minute curve for a series given a daily quiet curve of 24 hourly
+// values centred on the half hour of each UT hour, the curve wraps around midnight.
+func QuietCurve(s Series, quiet []float64) []float64 {
+	curve := make([]float64, len(s.Values))
+	if len(quiet) == 0 {
+		return curve
+	}
+
+	midnight := s.Start.Truncate(24 * time.Hour)
+	for i := range curve {
+		at := s.Start.Add(time.Duration(i) * time.Minute).Sub(midnight).Minutes()
+		pos := math.Mod((at-30.0)/60.0+float64(len(quiet)), float64(len(quiet)))
+
+		h := int(pos)
+		f := pos - float64(h)
+
+		curve[i] = quiet[h]*(1.0-f) + quiet[(h+1)%len(quiet)]*f
+	}
+
+	return curve
+}
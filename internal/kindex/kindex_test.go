@@ -0,0 +1,183 @@
+package kindex
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+func TestK(t *testing.T) {
+	tests := []struct {
+		rng, k9 float64
+		k       int
+	}{
+		{0, 500, 0},
+		{4.9, 500, 0},
+		{5, 500, 1},
+		{69.9, 500, 4},
+		{500, 500, 9},
+		{1000, 300, 9},
+		{100, 1000, 4},
+	}
+
+	for _, test := range tests {
+		if k := K(test.rng, test.k9); k != test.k {
+			t.Errorf("range %g with k9 %g expected %d got %d", test.rng, test.k9, test.k, k)
+		}
+	}
+}
+
+// testSeries builds two days of one-minute values with a smooth daily variation, plus an optional disturbance.
+func testSeries(start time.Time, label string, sq float64, pulse func(int) float64) Series {
+	var readings []raw.Reading
+	for i := 0; i < 2*24*60; i++ {
+		v := 20000.0 + sq*math.Sin(2.0*math.Pi*float64(i)/(24.0*60.0))
+		if pulse != nil {
+			v += pulse(i)
+		}
+		readings = append(readings, raw.NewReading(start.Add(time.Duration(i)*time.Minute), label, v))
+	}
+	return NewSeries(start, start.Add(48*time.Hour), readings)
+}
+
+func TestFMI(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	pulse := func(i int) float64 {
+		if i >= 24*60+13*60 && i < 24*60+13*60+30 {
+			return 100.0
+		}
+		return 0.0
+	}
+
+	x := testSeries(start, "NZ_EYWM_51_LFX", 20.0, pulse)
+	y := testSeries(start, "NZ_EYWM_51_LFY", 10.0, nil)
+
+	// the daily variation alone gives a raw range of over 10 nT in some intervals
+	var raised bool
+	for _, v := range Indices(x, y, 500, DefaultCoverage) {
+		if v.K > 1 && !v.Start.Equal(start.Add(36*time.Hour)) {
+			raised = true
+		}
+	}
+	if !raised {
+		t.Fatal("expected the daily variation to raise the unadjusted indices")
+	}
+
+	indices := FMI(x, y, 500, DefaultCoverage)
+	if n := len(indices); n != 16 {
+		t.Fatalf("expected 16 indices got %d", n)
+	}
+	for _, v := range indices {
+		switch {
+		case v.Start.Equal(start.Add(36 * time.Hour)):
+			if v.K != 5 {
+				t.Errorf("%s: expected a disturbed index of 5 got %d (%g)", v.Start, v.K, v.Range)
+			}
+		case v.K > 1:
+			t.Errorf("%s: expected a quiet index got %d (%g)", v.Start, v.K, v.Range)
+		}
+	}
+}
+
+func TestStation_Indices(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	x := testSeries(start, "NZ_EYWM_51_LFX", 20.0, nil)
+	y := testSeries(start, "NZ_EYWM_51_LFY", 10.0, nil)
+
+	quiet := func(sq float64) []float64 {
+		var values []float64
+		for h := 0; h < 24; h++ {
+			values = append(values, 20000.0+sq*math.Sin(2.0*math.Pi*(float64(h)+0.5)/24.0))
+		}
+		return values
+	}
+
+	station := Station{
+		Station: "NZ_EYWM_51",
+		K9:      500,
+		X:       "NZ_EYWM_51_LFX",
+		Y:       "NZ_EYWM_51_LFY",
+		Method:  MethodQuiet,
+		Quiet: map[string][]float64{
+			"x": quiet(20.0),
+			"y": quiet(10.0),
+		},
+	}
+	if err := station.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range station.Indices(x, y) {
+		if v.K != 0 {
+			t.Errorf("%s: expected a quiet index got %d (%g)", v.Start, v.K, v.Range)
+		}
+	}
+
+	// missing data gives a missing index
+	for i := 0; i < 60; i++ {
+		x.Values[i] = math.NaN()
+	}
+	if indices := station.Indices(x, y); indices[0].K != Missing || indices[1].K == Missing {
+		t.Errorf("expected only the first index to be missing: %v", indices[:2])
+	}
+
+	station.Quiet["y"] = station.Quiet["y"][:12]
+	if err := station.Validate(); err == nil {
+		t.Error("expected an incomplete quiet curve to be invalid")
+	}
+}
+
+func TestStation_IndicesHD(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// a five minute of arc declination pulse on the second day
+	pulse := func(i int) float64 {
+		if i >= 24*60+13*60 && i < 24*60+13*60+30 {
+			return 5.0
+		}
+		return 0.0
+	}
+
+	h := testSeries(start, "NZ_EYWM_51_LFH", 20.0, nil)
+	d := testSeries(start, "NZ_EYWM_51_LFD", 0.0, pulse)
+
+	quiet := func(sq float64) []float64 {
+		var values []float64
+		for h := 0; h < 24; h++ {
+			values = append(values, 20000.0+sq*math.Sin(2.0*math.Pi*(float64(h)+0.5)/24.0))
+		}
+		return values
+	}
+
+	station := Station{
+		Station: "NZ_EYWM_51",
+		K9:      500,
+		H:       "NZ_EYWM_51_LFH",
+		D:       "NZ_EYWM_51_LFD",
+		Method:  MethodQuiet,
+		Quiet: map[string][]float64{
+			"h": quiet(20.0),
+			"d": quiet(0.0),
+		},
+	}
+	if err := station.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the declination is converted using the mean horizontal field, not the quiet residual
+	nT := 5.0 * 20000.0 * math.Pi / (180.0 * 60.0)
+	for _, v := range station.Indices(h, d) {
+		switch {
+		case v.Start.Equal(start.Add(36 * time.Hour)):
+			if v.K != K(nT, 500) || math.Abs(v.Range-nT) > 0.5 {
+				t.Errorf("%s: expected a %g nT range got %d (%g)", v.Start, nT, v.K, v.Range)
+			}
+		case v.K != 0:
+			t.Errorf("%s: expected a quiet index got %d (%g)", v.Start, v.K, v.Range)
+		}
+	}
+}
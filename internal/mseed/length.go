@@ -0,0 +1,119 @@
+package mseed
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// MinRecordLength is the smallest supported miniseed record length.
+	MinRecordLength = 128
+	// MaxRecordLength is the largest supported miniseed record length.
+	MaxRecordLength = 1048576
+
+	// fixedHeaderLength is the size of the miniseed fixed section of data header.
+	fixedHeaderLength = 48
+)
+
+var (
+	// ErrNotSEED is returned when a buffer does not start with a miniseed record header.
+	ErrNotSEED = errors.New("data is not in SEED format")
+	// ErrRecordLength is returned when a record length is out of the supported range.
+	ErrRecordLength = errors.New("data record length is out of range")
+)
+
+// isValidHeader checks the fixed section of a data header for the known
+// signature values, matching the libmseed MS_ISVALIDHEADER macro.
+func isValidHeader(buf []byte) bool {
+	if len(buf) < fixedHeaderLength {
+		return false
+	}
+	for _, b := range buf[0:6] {
+		if !(b >= '0' && b <= '9') && b != ' ' && b != 0 {
+			return false
+		}
+	}
+	switch buf[6] {
+	case 'D', 'R', 'Q', 'M':
+	default:
+		return false
+	}
+	if buf[7] != ' ' && buf[7] != 0 {
+		return false
+	}
+	if buf[24] > 23 || buf[25] > 59 || buf[26] > 60 {
+		return false
+	}
+	return true
+}
+
+// isValidBlank checks for a blank or noise record, matching the libmseed
+// MS_ISVALIDBLANK macro.
+func isValidBlank(buf []byte) bool {
+	if len(buf) < fixedHeaderLength {
+		return false
+	}
+	for _, b := range buf[0:6] {
+		if !(b >= '0' && b <= '9') && b != 0 {
+			return false
+		}
+	}
+	for _, b := range buf[6:fixedHeaderLength] {
+		if b != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// headerByteOrder returns the byte order of the fixed header binary fields
+// by checking for a sane start year and day.
+func headerByteOrder(buf []byte) binary.ByteOrder {
+	year, day := binary.BigEndian.Uint16(buf[20:]), binary.BigEndian.Uint16(buf[22:])
+	if year >= 1900 && year <= 2100 && day >= 1 && day <= 366 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// RecordLength detects the length of the miniseed record at the start of buf. The
+// length of a miniseed 3 record is given by its header, a miniseed 2 length is taken
+// from a blockette 1000 if present, otherwise the buffer is searched at
+// MinRecordLength offsets for the start of the next record. A zero length with
+// no error indicates that a record was found but that its length could not be
+// determined from the available data.
+func RecordLength(buf []byte) (int, error) {
+	if isValidHeader3(buf) {
+		return recordLength3(buf), nil
+	}
+	if !isValidHeader(buf) {
+		return 0, ErrNotSEED
+	}
+
+	order := headerByteOrder(buf)
+
+	offset := int(order.Uint16(buf[46:]))
+	for offset != 0 && offset+4 <= len(buf) {
+		kind, next := order.Uint16(buf[offset:]), int(order.Uint16(buf[offset+2:]))
+		if kind == 1000 && offset+8 <= len(buf) {
+			if exp := uint(buf[offset+6]); exp < 32 {
+				if n := 1 << exp; n >= MinRecordLength && n <= MaxRecordLength {
+					return n, nil
+				}
+			}
+			return 0, ErrRecordLength
+		}
+		if next != 0 && (next < 4 || next-4 <= offset) {
+			return 0, errors.New("invalid blockette offset")
+		}
+		offset = next
+	}
+
+	for n := MinRecordLength; n+fixedHeaderLength < len(buf); n += MinRecordLength {
+		if isValidHeader(buf[n:]) || isValidHeader3(buf[n:]) || isValidBlank(buf[n:]) {
+			return n, nil
+		}
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,41 @@
+package mseed
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestRecordLength(t *testing.T) {
+	tests := map[string]int{
+		"test/data/Int32-128byte.mseed":            128,
+		"test/data/Int32-256byte.mseed":            256,
+		"test/data/Int32-512byte.mseed":            512,
+		"test/data/Int32-1024byte.mseed":           1024,
+		"test/data/Int32-2048byte.mseed":           2048,
+		"test/data/Int32-4096byte.mseed":           4096,
+		"test/data/Int32-8192byte.mseed":           8192,
+		"test/data/Steim2-AllDifferences-LE.mseed": 4096,
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			data, err := ioutil.ReadFile(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := RecordLength(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != v {
+				t.Errorf("expected record length %d got %d", v, n)
+			}
+		})
+	}
+}
+
+func TestRecordLength_NotSEED(t *testing.T) {
+	if _, err := RecordLength([]byte("time,label,value\n")); err != ErrNotSEED {
+		t.Errorf("expected ErrNotSEED got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package mseed
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fixedHeaderLength3 is the size of the miniseed 3 fixed section of data header.
+const fixedHeaderLength3 = 40
+
+// ErrCRC is returned when a miniseed 3 record fails its CRC check.
+var ErrCRC = errors.New("data record CRC does not match")
+
+// crcTable is used for the CRC-32C checksum of miniseed 3 records.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// isValidHeader3 checks for the miniseed 3 record indicator and format version.
+func isValidHeader3(buf []byte) bool {
+	if len(buf) < fixedHeaderLength3 {
+		return false
+	}
+	if buf[0] != 'M' || buf[1] != 'S' || buf[2] != 3 {
+		return false
+	}
+	if buf[12] > 23 || buf[13] > 59 || buf[14] > 60 {
+		return false
+	}
+	return true
+}
+
+// recordLength3 returns the length of a miniseed 3 record from the fixed header field lengths.
+func recordLength3(buf []byte) int {
+	return fixedHeaderLength3 + int(buf[33]) + int(binary.LittleEndian.Uint16(buf[34:])) + int(binary.LittleEndian.Uint32(buf[36:]))
+}
+
+// pubVersionQuality maps miniseed 3 publication versions to miniseed 2 data quality indicators.
+var pubVersionQuality = map[byte]byte{
+	1: 'R',
+	2: 'D',
+	3: 'Q',
+	4: 'M',
+}
+
+// unpack3 decodes a miniseed 3 record, all header values are little endian and the
+// CRC is checked before any decoding takes place.
+func (r *Record) unpack3(buf []byte) error {
+	n := recordLength3(buf)
+	if n > len(buf) {
+		return ErrRecordLength
+	}
+	buf = buf[:n]
+
+	check := make([]byte, n)
+	copy(check, buf)
+	copy(check[28:32], []byte{0, 0, 0, 0})
+	if crc32.Checksum(check, crcTable) != binary.LittleEndian.Uint32(buf[28:]) {
+		return ErrCRC
+	}
+
+	order := binary.LittleEndian
+
+	sidLength, extraLength := int(buf[33]), int(order.Uint16(buf[34:]))
+
+	*r = Record{
+		formatVersion: buf[2],
+		flags:         buf[3],
+		pubVersion:    buf[32],
+		sid:           string(buf[fixedHeaderLength3 : fixedHeaderLength3+sidLength]),
+		extra:         append([]byte{}, buf[fixedHeaderLength3+sidLength:fixedHeaderLength3+sidLength+extraLength]...),
+		samplecnt:     int32(order.Uint32(buf[24:])),
+		reclen:        n,
+		encoding:      int8(buf[15]),
+		byteorder:     0,
+	}
+
+	if q, ok := pubVersionQuality[r.pubVersion]; ok {
+		r.dataquality = q
+	}
+
+	if strings.HasPrefix(r.sid, SourceIDPrefix) {
+		net, sta, loc, cha, err := ParseSourceID(r.sid)
+		if err != nil {
+			return err
+		}
+		r.network, r.station, r.location, r.channel = net, sta, loc, cha
+	}
+
+	start := time.Date(int(order.Uint16(buf[8:])), time.January, 1, int(buf[12]), int(buf[13]), int(buf[14]), 0, time.UTC)
+	start = start.AddDate(0, 0, int(order.Uint16(buf[10:]))-1)
+	r.starttime = start.Add(time.Duration(order.Uint32(buf[4:])))
+
+	// a negative value is the sample period in seconds
+	switch rate := math.Float64frombits(order.Uint64(buf[16:])); {
+	case rate < 0.0:
+		r.samprate = -1.0 / rate
+	default:
+		r.samprate = rate
+	}
+
+	if !(r.samplecnt > 0) {
+		return nil
+	}
+
+	data := buf[fixedHeaderLength3+sidLength+extraLength:]
+
+	switch r.encoding {
+	case EncodingSteim1, EncodingSteim2:
+		// steim frames are always big endian
+		r.byteorder = 1
+		return r.unpackData(data, binary.BigEndian)
+	default:
+		return r.unpackData(data, order)
+	}
+}
+
+// FormatVersion returns the miniseed format version of the record, either 2 or 3.
+func (r *Record) FormatVersion() int {
+	if r.formatVersion == 0 {
+		return 2
+	}
+	return int(r.formatVersion)
+}
+
+// PubVersion returns the miniseed 3 data publication version, zero for miniseed 2 records.
+func (r *Record) PubVersion() int {
+	return int(r.pubVersion)
+}
+
+// Flags returns the miniseed 3 record flags.
+func (r *Record) Flags() byte {
+	return r.flags
+}
+
+// SID returns the FDSN source identifier of the record, for miniseed 2 records this is
+// built from the SEED codes.
+func (r *Record) SID() string {
+	if r.sid != "" {
+		return r.sid
+	}
+	return SourceID(r.network, r.station, r.location, r.channel)
+}
+
+// RawExtraHeaders returns the miniseed 3 extra header JSON document, if any.
+func (r *Record) RawExtraHeaders() []byte {
+	return r.extra
+}
+
+// ExtraHeader returns the extra header value found at the given JSON pointer path,
+// e.g. "/FDSN/Time/Quality", the boolean result indicates whether the value was found.
+func (r *Record) ExtraHeader(path string) (interface{}, bool, error) {
+	if !(len(r.extra) > 0) {
+		return nil, false, nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(r.extra, &value); err != nil {
+		return nil, false, fmt.Errorf("invalid extra headers: %v", err)
+	}
+
+	for _, key := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		if key == "" {
+			continue
+		}
+		key = strings.Replace(strings.Replace(key, "~1", "/", -1), "~0", "~", -1)
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return nil, false, nil
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false, nil
+			}
+			value = v[i]
+		default:
+			return nil, false, nil
+		}
+	}
+
+	return value, true, nil
+}
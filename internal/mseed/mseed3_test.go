@@ -0,0 +1,190 @@
+package mseed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+)
+
+// pack3 builds a miniseed 3 record holding the given encoded data payload.
+func pack3(sid string, extra string, start time.Time, rate float64, encoding byte, samples int, data []byte) []byte {
+	buf := make([]byte, fixedHeaderLength3+len(sid)+len(extra)+len(data))
+
+	copy(buf, "MS")
+	buf[2] = 3
+	binary.LittleEndian.PutUint32(buf[4:], uint32(start.Nanosecond()))
+	binary.LittleEndian.PutUint16(buf[8:], uint16(start.Year()))
+	binary.LittleEndian.PutUint16(buf[10:], uint16(start.YearDay()))
+	buf[12], buf[13], buf[14] = byte(start.Hour()), byte(start.Minute()), byte(start.Second())
+	buf[15] = encoding
+	binary.LittleEndian.PutUint64(buf[16:], math.Float64bits(rate))
+	binary.LittleEndian.PutUint32(buf[24:], uint32(samples))
+	buf[32] = 2
+	buf[33] = byte(len(sid))
+	binary.LittleEndian.PutUint16(buf[34:], uint16(len(extra)))
+	binary.LittleEndian.PutUint32(buf[36:], uint32(len(data)))
+
+	copy(buf[fixedHeaderLength3:], sid)
+	copy(buf[fixedHeaderLength3+len(sid):], extra)
+	copy(buf[fixedHeaderLength3+len(sid)+len(extra):], data)
+
+	binary.LittleEndian.PutUint32(buf[28:], crc32.Checksum(buf, crcTable))
+
+	return buf
+}
+
+// testRecord3 converts the first record of a miniseed 2 file into a miniseed 3 record.
+func testRecord3(t *testing.T, name string, extra string) ([]byte, *Record) {
+	data, err := ioutil.ReadFile("test/data/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := firstRecord(t, data)
+
+	var msr Record
+	if err := msr.Unpack(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload []byte
+	switch msr.Encoding() {
+	case EncodingInt32:
+		payload = make([]byte, 4*len(msr.ints))
+		for i, v := range msr.ints {
+			binary.LittleEndian.PutUint32(payload[i*4:], uint32(v))
+		}
+	default:
+		offset := int(binary.BigEndian.Uint16(rec[44:]))
+		payload = rec[offset:]
+	}
+
+	sid := SourceID(msr.Network(), msr.Station(), msr.Location(), msr.Channel())
+
+	return pack3(sid, extra, msr.Starttime(), msr.Samprate(), byte(msr.Encoding()), int(msr.Samplecnt()), payload), &msr
+}
+
+func TestRecord_Unpack3(t *testing.T) {
+	for _, name := range []string{"Steim1-AllDifferences-BE.mseed", "Steim2-AllDifferences-BE.mseed", "Int32-512byte.mseed"} {
+		t.Run(name, func(t *testing.T) {
+			rec, ref := testRecord3(t, name, `{"FDSN":{"Time":{"Quality":100}}}`)
+
+			var msr Record
+			if err := msr.Unpack(rec); err != nil {
+				t.Fatal(err)
+			}
+
+			if msr.FormatVersion() != 3 {
+				t.Errorf("expected format version 3 got %d", msr.FormatVersion())
+			}
+			if msr.SrcName(0) != ref.SrcName(0) {
+				t.Errorf("expected srcname %s got %s", ref.SrcName(0), msr.SrcName(0))
+			}
+			if msr.SID() != ref.SID() {
+				t.Errorf("expected source id %s got %s", ref.SID(), msr.SID())
+			}
+			if msr.Dataquality() != 'D' {
+				t.Errorf("expected data quality D got %c", msr.Dataquality())
+			}
+			if !msr.Starttime().Equal(ref.Starttime()) {
+				t.Errorf("expected start time %s got %s", ref.Starttime(), msr.Starttime())
+			}
+			if msr.Samprate() != ref.Samprate() {
+				t.Errorf("expected sample rate %g got %g", ref.Samprate(), msr.Samprate())
+			}
+
+			a, err := ref.DataSamples()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := msr.DataSamples()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(a) != len(b) {
+				t.Fatalf("expected %d samples got %d", len(a), len(b))
+			}
+			for i := range a {
+				if a[i] != b[i] {
+					t.Fatalf("sample %d mismatch, expected %d got %d", i, a[i], b[i])
+				}
+			}
+
+			switch v, ok, err := msr.ExtraHeader("/FDSN/Time/Quality"); {
+			case err != nil:
+				t.Fatal(err)
+			case !ok:
+				t.Error("missing extra header")
+			case v.(float64) != 100:
+				t.Errorf("unexpected extra header value: %v", v)
+			}
+			if _, ok, _ := msr.ExtraHeader("/FDSN/Missing"); ok {
+				t.Error("unexpected extra header found")
+			}
+		})
+	}
+}
+
+func TestRecord_Unpack3CRC(t *testing.T) {
+	rec, _ := testRecord3(t, "Steim2-AllDifferences-BE.mseed", "")
+	rec[len(rec)-1] ^= 0xff
+
+	var msr Record
+	if err := msr.Unpack(rec); err != ErrCRC {
+		t.Errorf("expected CRC error got %v", err)
+	}
+}
+
+func TestReader_Mixed3(t *testing.T) {
+	a, _ := testRecord3(t, "Steim2-AllDifferences-BE.mseed", `{"FDSN":{}}`)
+	b, _ := testRecord3(t, "Int32-512byte.mseed", "")
+
+	v2, err := ioutil.ReadFile("test/data/Int32-512byte.mseed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := append(append(append([]byte{}, a...), v2[:512]...), b...)
+
+	var srcnames []string
+	reader := NewReader(bytes.NewReader(data))
+	for {
+		msr, err := reader.Next()
+		if err != nil {
+			break
+		}
+		srcnames = append(srcnames, SourceID(msr.Network(), msr.Station(), msr.Location(), msr.Channel()))
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(srcnames) != 3 {
+		t.Fatalf("expected 3 records got %d: %v", len(srcnames), srcnames)
+	}
+	if srcnames[0] != "FDSN:XX_TEST__L_H_Z" || srcnames[1] != "FDSN:XX_TEST_00_L_H_Z" {
+		t.Errorf("unexpected source ids: %v", srcnames)
+	}
+}
+
+func TestParseSourceID(t *testing.T) {
+	net, sta, loc, cha, err := ParseSourceID("FDSN:NZ_EYWM_51_L_F_F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if net != "NZ" || sta != "EYWM" || loc != "51" || cha != "LFF" {
+		t.Errorf("unexpected codes: %s %s %s %s", net, sta, loc, cha)
+	}
+	if s := SourceID(net, sta, loc, cha); s != "FDSN:NZ_EYWM_51_L_F_F" {
+		t.Errorf("unexpected source id: %s", s)
+	}
+
+	for _, s := range []string{"NZ_EYWM_51_LFF", "FDSN:NZ_EYWM_51_LFF"} {
+		if _, _, _, _, err := ParseSourceID(s); err == nil {
+			t.Errorf("shouldn't be able to parse source id: %s", s)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package mseed
+
+import (
+	"errors"
+	"io"
+)
+
+// readChunk is the amount of data requested from the underlying reader at a time.
+const readChunk = 8192
+
+// Reader reads consecutive miniseed records from an input stream, the whole stream
+// is not buffered so only enough memory to hold the current record is required.
+type Reader struct {
+	rd  io.Reader
+	buf []byte
+	eof bool
+	err error
+
+	msr *Record
+}
+
+// NewReader returns a Reader that reads miniseed records from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		rd: r,
+	}
+}
+
+// Close releases any record allocated by calls to Next, it does not close the underlying reader.
+func (r *Reader) Close() {
+	r.msr = nil
+}
+
+// fill reads from the underlying reader until at least n bytes are buffered or
+// the end of the stream has been reached.
+func (r *Reader) fill(n int) error {
+	for !r.eof && len(r.buf) < n {
+		chunk := readChunk
+		if n-len(r.buf) > chunk {
+			chunk = n - len(r.buf)
+		}
+		if cap(r.buf)-len(r.buf) < chunk {
+			buf := make([]byte, len(r.buf), len(r.buf)+chunk)
+			copy(buf, r.buf)
+			r.buf = buf
+		}
+		m, err := r.rd.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+m]
+		switch {
+		case err == io.EOF:
+			r.eof = true
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+// detect finds the length of the next record, reading ahead as needed when
+// the record has no blockette 1000.
+func (r *Reader) detect() (int, error) {
+	for want := MinRecordLength; ; want *= 2 {
+		if err := r.fill(want); err != nil {
+			return 0, err
+		}
+		n, err := RecordLength(r.buf)
+		switch {
+		case err != nil:
+			return 0, err
+		case n > 0:
+			return n, nil
+		case r.eof || len(r.buf) < want:
+			// the remainder of the stream must be the record
+			if len(r.buf) < MinRecordLength {
+				return 0, errors.New("truncated data record")
+			}
+			return len(r.buf), nil
+		case want >= MaxRecordLength:
+			return 0, ErrRecordLength
+		}
+	}
+}
+
+// ReadRecord returns the next raw miniseed record from the stream, skipping any
+// blank or noise records. It returns io.EOF at the end of the stream, any other
+// error is sticky and will be returned by subsequent calls.
+func (r *Reader) ReadRecord() ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// skip any blank or noise records
+	for {
+		if err := r.fill(fixedHeaderLength); err != nil {
+			r.err = err
+			return nil, err
+		}
+		if len(r.buf) == 0 && r.eof {
+			r.err = io.EOF
+			return nil, io.EOF
+		}
+		if !isValidBlank(r.buf) {
+			break
+		}
+		if err := r.fill(MinRecordLength); err != nil {
+			r.err = err
+			return nil, err
+		}
+		if len(r.buf) < MinRecordLength {
+			r.buf = r.buf[:0]
+			continue
+		}
+		r.buf = r.buf[MinRecordLength:]
+	}
+
+	n, err := r.detect()
+	if err != nil {
+		r.err = err
+		return nil, err
+	}
+	if err := r.fill(n); err != nil {
+		r.err = err
+		return nil, err
+	}
+	if len(r.buf) < n {
+		r.err = errors.New("truncated data record")
+		return nil, r.err
+	}
+
+	record := make([]byte, n)
+	copy(record, r.buf[:n])
+	r.buf = r.buf[n:]
+
+	return record, nil
+}
+
+// Next reads and unpacks the next miniseed record from the stream. The returned
+// record is reused by subsequent calls and is released by Close. An unpacking error
+// only applies to the current record and reading may continue, whereas an error
+// reading the stream is also returned by Err.
+func (r *Reader) Next() (*Record, error) {
+	record, err := r.ReadRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.msr == nil {
+		r.msr = &Record{}
+	}
+	if err := r.msr.Unpack(record); err != nil {
+		return nil, err
+	}
+
+	return r.msr, nil
+}
+
+// Err returns the first error encountered reading the stream, other than io.EOF.
+func (r *Reader) Err() error {
+	if r.err == io.EOF {
+		return nil
+	}
+	return r.err
+}
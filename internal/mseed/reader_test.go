@@ -0,0 +1,81 @@
+package mseed
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReader(t *testing.T) {
+	data, err := ioutil.ReadFile("test/data/Int32-oneseries-mixedlengths-mixedorder.mseed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(iotest.OneByteReader(bytes.NewReader(data)))
+	defer reader.Close()
+
+	var samples int
+	lengths := make(map[int]int)
+	for {
+		msr, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := msr.SrcName(0); s != "XX_TEST_00_LHZ" {
+			t.Errorf("expected srcname XX_TEST_00_LHZ got %s", s)
+		}
+		lengths[msr.Reclen()]++
+		samples += int(msr.Numsamples())
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if samples != 3952 {
+		t.Errorf("expected 3952 samples got %d", samples)
+	}
+	if len(lengths) < 2 {
+		t.Errorf("expected mixed record lengths got %v", lengths)
+	}
+}
+
+func TestReader_NoBlockette1000(t *testing.T) {
+	data, err := ioutil.ReadFile("test/data/no-blockette1000-steim1.mseed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var length int
+	reader := NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		length += len(record)
+	}
+	if length != len(data) {
+		t.Errorf("expected to read %d bytes got %d", len(data), length)
+	}
+}
+
+func TestReader_NotSEED(t *testing.T) {
+	reader := NewReader(bytes.NewReader(bytes.Repeat([]byte("time,label,value\n"), 10)))
+	defer reader.Close()
+
+	if _, err := reader.ReadRecord(); err != ErrNotSEED {
+		t.Errorf("expected ErrNotSEED got %v", err)
+	}
+	if err := reader.Err(); err != ErrNotSEED {
+		t.Errorf("expected sticky ErrNotSEED got %v", err)
+	}
+}
@@ -0,0 +1,409 @@
+package mseed
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Data encoding formats supported by the native decoder.
+const (
+	EncodingText    = 0
+	EncodingInt16   = 1
+	EncodingInt32   = 3
+	EncodingFloat32 = 4
+	EncodingFloat64 = 5
+	EncodingSteim1  = 10
+	EncodingSteim2  = 11
+)
+
+// ErrEncoding is returned when a record uses an unsupported data encoding.
+var ErrEncoding = errors.New("data has unknown encoding format")
+
+// Record is a miniseed record unpacked without using libmseed, the zero value is
+// ready to use and may be reused for consecutive calls to Unpack.
+type Record struct {
+	sequenceNumber int32
+	dataquality    byte
+	network        string
+	station        string
+	location       string
+	channel        string
+	starttime      time.Time
+	samprate       float64
+	samplecnt      int32
+	activity       byte
+	reclen         int
+	encoding       int8
+	byteorder      int8
+
+	formatVersion byte
+	flags         byte
+	pubVersion    byte
+	sid           string
+	extra         []byte
+
+	sampletype byte
+	ints       []int32
+	floats     []float32
+	doubles    []float64
+	text       []byte
+}
+
+// Unpack decodes the miniseed record held in buf, the fixed header, blockettes 100, 1000
+// and 1001 are parsed and the data samples are decoded. As with libmseed a record without
+// a blockette 1000 is assumed to be Steim1 encoded using the header byte order. Miniseed 3
+// records are also recognised and decoded once their CRC has been checked.
+func (r *Record) Unpack(buf []byte) error {
+	if isValidHeader3(buf) {
+		return r.unpack3(buf)
+	}
+	if !isValidHeader(buf) {
+		return ErrNotSEED
+	}
+
+	order := headerByteOrder(buf)
+
+	*r = Record{
+		dataquality: buf[6],
+		network:     cleanString(string(buf[18:20])),
+		station:     cleanString(string(buf[8:13])),
+		location:    cleanString(string(buf[13:15])),
+		channel:     cleanString(string(buf[15:18])),
+		samplecnt:   int32(order.Uint16(buf[30:])),
+		activity:    buf[36],
+		reclen:      len(buf),
+		encoding:    -1,
+		byteorder:   -1,
+	}
+
+	if n, err := strconv.Atoi(strings.TrimSpace(strings.Trim(string(buf[0:6]), "\x00"))); err == nil {
+		r.sequenceNumber = int32(n)
+	}
+
+	start := time.Date(int(order.Uint16(buf[20:])), time.January, 1, int(buf[24]), int(buf[25]), int(buf[26]), 0, time.UTC)
+	start = start.AddDate(0, 0, int(order.Uint16(buf[22:]))-1)
+	start = start.Add(time.Duration(order.Uint16(buf[28:])) * 100 * time.Microsecond)
+
+	// apply any time correction not already applied as flagged in bit 1 of the activity flags
+	if correction := int32(order.Uint32(buf[40:])); correction != 0 && buf[36]&0x02 == 0 {
+		start = start.Add(time.Duration(correction) * 100 * time.Microsecond)
+	}
+
+	r.samprate = nominalRate(int16(order.Uint16(buf[32:])), int16(order.Uint16(buf[34:])))
+
+	var b1000 bool
+	for offset := int(order.Uint16(buf[46:])); offset > 0; {
+		if offset < fixedHeaderLength {
+			return fmt.Errorf("invalid blockette offset (%d) within fixed header", offset)
+		}
+		if offset+4 > len(buf) {
+			break
+		}
+
+		next := int(order.Uint16(buf[offset+2:]))
+
+		switch order.Uint16(buf[offset:]) {
+		case 100:
+			if offset+8 <= len(buf) {
+				r.samprate = float64(math.Float32frombits(order.Uint32(buf[offset+4:])))
+			}
+		case 1000:
+			if offset+7 <= len(buf) {
+				b1000 = true
+				r.encoding = int8(buf[offset+4])
+				r.byteorder = int8(buf[offset+5])
+				r.reclen = 1 << buf[offset+6]
+			}
+		case 1001:
+			if offset+6 <= len(buf) {
+				start = start.Add(time.Duration(int8(buf[offset+5])) * time.Microsecond)
+			}
+		}
+
+		if next != 0 && next <= offset {
+			return fmt.Errorf("invalid blockette offset (%d) less than or equal to current offset (%d)", next, offset)
+		}
+		offset = next
+	}
+
+	r.starttime = start
+
+	dataOrder := order
+	switch {
+	case !b1000:
+		r.encoding = EncodingSteim1
+		if order == binary.BigEndian {
+			r.byteorder = 1
+		} else {
+			r.byteorder = 0
+		}
+	case r.byteorder == 0:
+		dataOrder = binary.LittleEndian
+	default:
+		dataOrder = binary.BigEndian
+	}
+
+	if r.reclen > len(buf) {
+		return ErrRecordLength
+	}
+	if !(r.samplecnt > 0) {
+		return nil
+	}
+
+	offset := int(order.Uint16(buf[44:]))
+	if offset < fixedHeaderLength || offset > r.reclen {
+		return fmt.Errorf("invalid data offset (%d)", offset)
+	}
+
+	return r.unpackData(buf[offset:r.reclen], dataOrder)
+}
+
+// unpackData decodes the data samples using the record encoding format.
+func (r *Record) unpackData(data []byte, order binary.ByteOrder) error {
+	n := int(r.samplecnt)
+
+	switch r.encoding {
+	case EncodingText:
+		if n > len(data) {
+			n = len(data)
+		}
+		r.sampletype, r.text = 'a', append([]byte{}, data[:n]...)
+	case EncodingInt16:
+		if n*2 > len(data) {
+			return fmt.Errorf("not enough data for %d int16 samples", n)
+		}
+		r.sampletype, r.ints = 'i', make([]int32, n)
+		for i := range r.ints {
+			r.ints[i] = int32(int16(order.Uint16(data[i*2:])))
+		}
+	case EncodingInt32:
+		if n*4 > len(data) {
+			return fmt.Errorf("not enough data for %d int32 samples", n)
+		}
+		r.sampletype, r.ints = 'i', make([]int32, n)
+		for i := range r.ints {
+			r.ints[i] = int32(order.Uint32(data[i*4:]))
+		}
+	case EncodingFloat32:
+		if n*4 > len(data) {
+			return fmt.Errorf("not enough data for %d float32 samples", n)
+		}
+		r.sampletype, r.floats = 'f', make([]float32, n)
+		for i := range r.floats {
+			r.floats[i] = math.Float32frombits(order.Uint32(data[i*4:]))
+		}
+	case EncodingFloat64:
+		if n*8 > len(data) {
+			return fmt.Errorf("not enough data for %d float64 samples", n)
+		}
+		r.sampletype, r.doubles = 'd', make([]float64, n)
+		for i := range r.doubles {
+			r.doubles[i] = math.Float64frombits(order.Uint64(data[i*8:]))
+		}
+	case EncodingSteim1:
+		samples, err := decodeSteim1(data, n, order)
+		if err != nil {
+			return err
+		}
+		r.sampletype, r.ints = 'i', samples
+	case EncodingSteim2:
+		samples, err := decodeSteim2(data, n, order)
+		if err != nil {
+			return err
+		}
+		r.sampletype, r.ints = 'i', samples
+	default:
+		return ErrEncoding
+	}
+
+	return nil
+}
+
+// nominalRate converts the fixed header sample rate factor and multiplier into samples per second.
+func nominalRate(factor, multiplier int16) float64 {
+	var rate float64
+	switch {
+	case factor > 0:
+		rate = float64(factor)
+	case factor < 0:
+		rate = -1.0 / float64(factor)
+	}
+	switch {
+	case multiplier > 0:
+		rate = rate * float64(multiplier)
+	case multiplier < 0:
+		rate = -1.0 * (rate / float64(multiplier))
+	}
+	return rate
+}
+
+func (r *Record) SequenceNumber() int32 {
+	return r.sequenceNumber
+}
+func (r *Record) Network() string {
+	return r.network
+}
+func (r *Record) Station() string {
+	return r.station
+}
+func (r *Record) Location() string {
+	return r.location
+}
+func (r *Record) Channel() string {
+	return r.channel
+}
+func (r *Record) Dataquality() byte {
+	return r.dataquality
+}
+func (r *Record) Starttime() time.Time {
+	return r.starttime
+}
+func (r *Record) Samprate() float64 {
+	return r.samprate
+}
+func (r *Record) Samplecnt() int32 {
+	return r.samplecnt
+}
+func (r *Record) Reclen() int {
+	return r.reclen
+}
+func (r *Record) Encoding() int8 {
+	return r.encoding
+}
+func (r *Record) Byteorder() int8 {
+	return r.byteorder
+}
+func (r *Record) Sampletype() byte {
+	return r.sampletype
+}
+func (r *Record) Numsamples() int32 {
+	switch r.sampletype {
+	case 'a':
+		return int32(len(r.text))
+	case 'i':
+		return int32(len(r.ints))
+	case 'f':
+		return int32(len(r.floats))
+	case 'd':
+		return int32(len(r.doubles))
+	default:
+		return 0
+	}
+}
+
+// Endtime returns the time of the last sample in the record.
+func (r *Record) Endtime() time.Time {
+	if !(r.samprate > 0.0) || !(r.samplecnt > 0) {
+		return r.starttime
+	}
+
+	span := time.Duration(float64(r.samplecnt-1)/r.samprate*float64(time.Second) + 0.5)
+
+	// a positive leap second occurred during the record
+	if r.activity&0x10 != 0 {
+		span -= time.Second
+	}
+
+	return r.starttime.Add(span)
+}
+
+func (r *Record) MsgSamples() (string, error) {
+	if r.sampletype != 'a' {
+		return "", errors.New("not an ascii formatted record")
+	}
+	return string(r.text), nil
+}
+
+func (r *Record) DataSamples() ([]int32, error) {
+	if r.sampletype == 'a' {
+		return nil, errors.New("not a numerical formatted record")
+	}
+
+	switch r.sampletype {
+	case 'i':
+		return append([]int32{}, r.ints...), nil
+	case 'f':
+		samples := make([]int32, len(r.floats))
+		for i, v := range r.floats {
+			samples[i] = int32(v)
+		}
+		return samples, nil
+	case 'd':
+		samples := make([]int32, len(r.doubles))
+		for i, v := range r.doubles {
+			samples[i] = int32(v)
+		}
+		return samples, nil
+	default:
+		return nil, errors.New("format not coded")
+	}
+}
+
+// DataSamplesFloat64 returns the record samples without any loss of precision, float32
+// samples are converted using their shortest decimal representation so that they match
+// the values as published rather than their nearest binary float64 equivalent.
+func (r *Record) DataSamplesFloat64() ([]float64, error) {
+	if r.sampletype == 'a' {
+		return nil, errors.New("not a numerical formatted record")
+	}
+
+	samples := make([]float64, r.Numsamples())
+
+	switch r.sampletype {
+	case 'i':
+		for i, v := range r.ints {
+			samples[i] = float64(v)
+		}
+	case 'f':
+		for i, v := range r.floats {
+			f, err := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+			if err != nil {
+				return nil, err
+			}
+			samples[i] = f
+		}
+	case 'd':
+		copy(samples, r.doubles)
+	default:
+		return nil, errors.New("format not coded")
+	}
+
+	return samples, nil
+}
+
+// SrcName returns the record source name as NET_STA_LOC_CHAN, with the data quality appended if requested.
+func (r *Record) SrcName(quality int8) string {
+	srcname := strings.Join([]string{r.network, r.station, r.location, r.channel}, "_")
+	if quality != 0 {
+		srcname += "_" + string(r.dataquality)
+	}
+	return srcname
+}
+
+// cleanString removes all non UTF8, spaces, and null termination
+// characters from s.
+func cleanString(s string) string {
+	if !utf8.ValidString(s) {
+		v := make([]rune, 0, len(s))
+		for i, r := range s {
+			if r == utf8.RuneError {
+				_, size := utf8.DecodeRuneInString(s[i:])
+				if size == 1 {
+					continue
+				}
+			}
+			v = append(v, r)
+		}
+		s = string(v)
+	}
+
+	s = strings.Replace(s, " ", "", -1)
+
+	return strings.Replace(s, "\x00", "", -1)
+}
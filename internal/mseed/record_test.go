@@ -0,0 +1,250 @@
+package mseed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seedTime formats a time as a libmseed SEED time string.
+func seedTime(t time.Time) string {
+	return fmt.Sprintf("%04d,%03d,%02d:%02d:%02d.%06d", t.Year(), t.YearDay(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1000)
+}
+
+// printRecord mimics the libmseed test program output for a record and its samples.
+func printRecord(buf *bytes.Buffer, msr *Record) {
+	fmt.Fprintf(buf, "%s, %06d, %c, %d, %d samples, %s Hz, %s\n", msr.SrcName(0), msr.SequenceNumber(), msr.Dataquality(),
+		msr.Reclen(), msr.Samplecnt(), strconv.FormatFloat(msr.Samprate(), 'g', 10, 64), seedTime(msr.Starttime()))
+
+	if !(msr.Numsamples() > 0) {
+		return
+	}
+
+	if msr.Sampletype() == 'a' {
+		text, _ := msr.MsgSamples()
+		fmt.Fprintf(buf, "ASCII Data:\n%s\n", text)
+		return
+	}
+
+	n := int(msr.Numsamples())
+	for i := 0; i < n/6+1; i++ {
+		for j := i * 6; j < n && j < (i+1)*6; j++ {
+			switch msr.Sampletype() {
+			case 'i':
+				fmt.Fprintf(buf, "%10d  ", msr.ints[j])
+			case 'f':
+				fmt.Fprintf(buf, "%10.8g  ", msr.floats[j])
+			case 'd':
+				fmt.Fprintf(buf, "%10.10g  ", msr.doubles[j])
+			}
+		}
+		buf.WriteString("\n")
+	}
+}
+
+func TestRecord_Reference(t *testing.T) {
+	for _, v := range []struct {
+		name string
+		data string
+	}{
+		{"read-Int16-encoded", "Int16-encoded.mseed"},
+		{"read-Int32-128byte-encoded", "Int32-128byte.mseed"},
+		{"read-Int32-256byte-encoded", "Int32-256byte.mseed"},
+		{"read-Int32-512byte-encoded", "Int32-512byte.mseed"},
+		{"read-Int32-1024byte-encoded", "Int32-1024byte.mseed"},
+		{"read-Int32-2048byte-encoded", "Int32-2048byte.mseed"},
+		{"read-Int32-4096byte-encoded", "Int32-4096byte.mseed"},
+		{"read-Int32-8192byte-encoded", "Int32-8192byte.mseed"},
+		{"read-Float32-encoded", "Float32-encoded.mseed"},
+		{"read-Float64-encoded", "Float64-encoded.mseed"},
+		{"read-Steim1-bigendian", "Steim1-AllDifferences-BE.mseed"},
+		{"read-Steim1-littleendian", "Steim1-AllDifferences-LE.mseed"},
+		{"read-Steim2-bigendian", "Steim2-AllDifferences-BE.mseed"},
+		{"read-Steim2-littleendian", "Steim2-AllDifferences-LE.mseed"},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile("test/data/" + v.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ref, err := ioutil.ReadFile("test/" + v.name + ".test.ref")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var msr Record
+			var buf bytes.Buffer
+
+			reader := NewReader(bytes.NewReader(data))
+			for {
+				record, err := reader.ReadRecord()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := msr.Unpack(record); err != nil {
+					t.Fatal(err)
+				}
+				printRecord(&buf, &msr)
+			}
+
+			if !bytes.Equal(buf.Bytes(), ref) {
+				t.Errorf("output doesn't match reference %s\n%s", v.name, buf.String())
+			}
+		})
+	}
+}
+
+func TestRecord_Unpack(t *testing.T) {
+	for _, v := range []struct {
+		data    string
+		start   string
+		samples int32
+		enc     int8
+	}{
+		{"no-blockette1000-steim1.mseed", "1995,265,00:00:18.238400", 3632, EncodingSteim1},
+		{"unapplied-timecorrection.mseed", "2003,149,02:13:23.043400", 5980, EncodingSteim2},
+	} {
+		t.Run(v.data, func(t *testing.T) {
+			data, err := ioutil.ReadFile("test/data/" + v.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var msr Record
+			if err := msr.Unpack(firstRecord(t, data)); err != nil {
+				t.Fatal(err)
+			}
+			if s := seedTime(msr.Starttime()); s != v.start {
+				t.Errorf("expected start time %s got %s", v.start, s)
+			}
+			if msr.Numsamples() != v.samples {
+				t.Errorf("expected %d samples got %d", v.samples, msr.Numsamples())
+			}
+			if msr.Encoding() != v.enc {
+				t.Errorf("expected encoding %d got %d", v.enc, msr.Encoding())
+			}
+		})
+	}
+}
+
+func TestRecord_Text(t *testing.T) {
+	data, err := ioutil.ReadFile("test/data/text-encoded.mseed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msr Record
+	if err := msr.Unpack(firstRecord(t, data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the libmseed reference output is truncated by its logging so only check the text directly
+	text, err := msr.MsgSamples()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(text) != 3994 || !strings.HasPrefix(text, "\r\nQuanterra Packet Baler Model 14 Restart.") {
+		t.Errorf("unexpected text samples: %q", text[:64])
+	}
+	if _, err := msr.DataSamples(); err == nil {
+		t.Error("shouldn't be able to get numerical samples from a text record")
+	}
+}
+
+func TestRecord_NotSEED(t *testing.T) {
+	var msr Record
+	if err := msr.Unpack(make([]byte, 512)); err != ErrNotSEED {
+		t.Errorf("expected not SEED error got %v", err)
+	}
+}
+
+// firstRecord returns the first record found in data.
+func firstRecord(t *testing.T, data []byte) []byte {
+	record, err := NewReader(bytes.NewReader(data)).ReadRecord()
+	if err != nil {
+		t.Fatalf("no records found: %v", err)
+	}
+	return record
+}
+
+func TestRecord_SteimIntegrity(t *testing.T) {
+	for _, name := range []string{"Steim1-AllDifferences-BE.mseed", "Steim2-AllDifferences-BE.mseed"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := ioutil.ReadFile("test/data/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			record := firstRecord(t, data)
+
+			var msr Record
+			if err := msr.Unpack(record); err != nil {
+				t.Fatal(err)
+			}
+
+			offset := int(binary.BigEndian.Uint16(record[44:]))
+
+			// the frames after the first have been lost, leaving fewer samples than the header count
+			truncated := append([]byte{}, record...)
+			for i := offset + steimFrameLength; i < len(truncated); i++ {
+				truncated[i] = 0
+			}
+			if err := msr.Unpack(truncated); err == nil || !strings.Contains(err.Error(), "steim frames hold") {
+				t.Errorf("expected a sample count error got %v", err)
+			}
+
+			// the last sample no longer matches the reverse integration constant
+			corrupt := append([]byte{}, record...)
+			corrupt[offset+11] ^= 0x01
+			if err := msr.Unpack(corrupt); err == nil || !strings.Contains(err.Error(), "steim integrity check failed") {
+				t.Errorf("expected an integrity check error got %v", err)
+			}
+		})
+	}
+}
+
+func TestRecord_DataSamplesFloat64(t *testing.T) {
+	var values [][]float64
+	for _, name := range []string{"Float32-encoded.mseed", "Float64-encoded.mseed"} {
+		data, err := ioutil.ReadFile("test/data/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var msr Record
+		if err := msr.Unpack(firstRecord(t, data)); err != nil {
+			t.Fatal(err)
+		}
+
+		samples, err := msr.DataSamplesFloat64()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, samples)
+
+		// the integer samples are truncated
+		ints, err := msr.DataSamples()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ints[0] != -1 {
+			t.Errorf("expected truncated sample -1 got %d", ints[0])
+		}
+	}
+
+	// the float32 samples should match the same values encoded as float64
+	for i := range values[1] {
+		if values[0][i] != values[1][i] {
+			t.Fatalf("sample %d mismatch: %v != %v", i, values[0][i], values[1][i])
+		}
+	}
+}
@@ -1,3 +1,5 @@
+//go:build cgo
+
 //nolint //cgo generates code that doesn't pass linting
 package mseed
 
@@ -0,0 +1,39 @@
+package mseed
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SourceIDPrefix is the namespace prefix of an FDSN source identifier.
+const SourceIDPrefix = "FDSN:"
+
+// ParseSourceID splits an FDSN source identifier (e.g. FDSN:NZ_EYWM_51_L_F_F) into
+// SEED network, station, location and channel codes. The band, source and subsource
+// codes are concatenated to form the channel code.
+func ParseSourceID(sid string) (string, string, string, string, error) {
+	if !strings.HasPrefix(sid, SourceIDPrefix) {
+		return "", "", "", "", fmt.Errorf("invalid source identifier, missing %q prefix: %s", SourceIDPrefix, sid)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(sid, SourceIDPrefix), "_")
+	if len(parts) != 6 {
+		return "", "", "", "", fmt.Errorf("invalid source identifier, expected NET_STA_LOC_BAND_SOURCE_SUBSOURCE: %s", sid)
+	}
+
+	return parts[0], parts[1], parts[2], strings.Join(parts[3:], ""), nil
+}
+
+// SourceID builds an FDSN source identifier from SEED codes, a three character channel
+// code is split into band, source and subsource codes.
+func SourceID(network, station, location, channel string) string {
+	var codes []string
+	switch len(channel) {
+	case 3:
+		codes = []string{channel[0:1], channel[1:2], channel[2:3]}
+	default:
+		codes = []string{channel, "", ""}
+	}
+
+	return SourceIDPrefix + strings.Join(append([]string{network, station, location}, codes...), "_")
+}
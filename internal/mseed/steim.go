@@ -0,0 +1,139 @@
+package mseed
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// steimFrameLength is the size of a Steim compression frame, sixteen 32-bit words.
+const steimFrameLength = 64
+
+// signExtend converts the low bits of v into a signed value.
+func signExtend(v uint32, bits uint) int32 {
+	mask := uint32(1) << (bits - 1)
+	v &= (uint32(1) << bits) - 1
+	return int32(v^mask) - int32(mask)
+}
+
+// integrate converts the decoded differences into samples starting from the forward
+// integration constant, the first difference is ignored. As with libmseed the number of
+// differences must match the expected sample count and the last sample must match the
+// reverse integration constant.
+func integrate(diffs []int32, x0, xn int32, count int) ([]int32, error) {
+	if len(diffs) != count {
+		return nil, fmt.Errorf("steim frames hold %d samples, expected %d", len(diffs), count)
+	}
+
+	samples := make([]int32, count)
+	for i := range samples {
+		switch i {
+		case 0:
+			samples[i] = x0
+		default:
+			samples[i] = samples[i-1] + diffs[i]
+		}
+	}
+
+	if count > 0 && samples[count-1] != xn {
+		return nil, fmt.Errorf("steim integrity check failed, last sample %d does not match %d", samples[count-1], xn)
+	}
+
+	return samples, nil
+}
+
+// decodeSteim1 decodes count samples from Steim1 compressed frames.
+func decodeSteim1(data []byte, count int, order binary.ByteOrder) ([]int32, error) {
+	if len(data) < steimFrameLength {
+		return nil, errors.New("no steim1 frames found")
+	}
+
+	var x0, xn int32
+
+	diffs := make([]int32, 0, count)
+	for frame := 0; (frame+1)*steimFrameLength <= len(data) && len(diffs) < count; frame++ {
+		words := data[frame*steimFrameLength : (frame+1)*steimFrameLength]
+		nibbles := order.Uint32(words)
+
+		start := 1
+		if frame == 0 {
+			x0, xn = int32(order.Uint32(words[4:])), int32(order.Uint32(words[8:]))
+			// skip the forward and reverse integration constants
+			start = 3
+		}
+
+		for w := start; w < 16 && len(diffs) < count; w++ {
+			word := words[w*4 : (w+1)*4]
+			switch (nibbles >> uint(30-2*w)) & 0x03 {
+			case 1:
+				for _, b := range word {
+					diffs = append(diffs, int32(int8(b)))
+				}
+			case 2:
+				diffs = append(diffs, int32(int16(order.Uint16(word))), int32(int16(order.Uint16(word[2:]))))
+			case 3:
+				diffs = append(diffs, int32(order.Uint32(word)))
+			}
+		}
+	}
+
+	return integrate(diffs, x0, xn, count)
+}
+
+// decodeSteim2 decodes count samples from Steim2 compressed frames.
+func decodeSteim2(data []byte, count int, order binary.ByteOrder) ([]int32, error) {
+	if len(data) < steimFrameLength {
+		return nil, errors.New("no steim2 frames found")
+	}
+
+	var x0, xn int32
+
+	diffs := make([]int32, 0, count)
+	for frame := 0; (frame+1)*steimFrameLength <= len(data) && len(diffs) < count; frame++ {
+		words := data[frame*steimFrameLength : (frame+1)*steimFrameLength]
+		nibbles := order.Uint32(words)
+
+		start := 1
+		if frame == 0 {
+			x0, xn = int32(order.Uint32(words[4:])), int32(order.Uint32(words[8:]))
+			// skip the forward and reverse integration constants
+			start = 3
+		}
+
+		for w := start; w < 16 && len(diffs) < count; w++ {
+			word := words[w*4 : (w+1)*4]
+			v := order.Uint32(word)
+
+			var n, bits uint
+			switch nibble, dnib := (nibbles>>uint(30-2*w))&0x03, v>>30; {
+			case nibble == 0:
+				continue
+			case nibble == 1:
+				for _, b := range word {
+					diffs = append(diffs, int32(int8(b)))
+				}
+				continue
+			case nibble == 2 && dnib == 1:
+				n, bits = 1, 30
+			case nibble == 2 && dnib == 2:
+				n, bits = 2, 15
+			case nibble == 2 && dnib == 3:
+				n, bits = 3, 10
+			case nibble == 3 && dnib == 0:
+				n, bits = 5, 6
+			case nibble == 3 && dnib == 1:
+				n, bits = 6, 5
+			case nibble == 3 && dnib == 2:
+				n, bits = 7, 4
+			default:
+				return nil, fmt.Errorf("impossible steim2 dnib=%02b for nibble=%02b", dnib, nibble)
+			}
+
+			for i := uint(0); i < n; i++ {
+				diffs = append(diffs, signExtend(v>>((n-1-i)*bits), bits))
+			}
+		}
+	}
+
+	return integrate(diffs, x0, xn, count)
+}
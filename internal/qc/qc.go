@@ -0,0 +1,355 @@
+// Package qc provides quality control checks that flag suspect raw readings.
+package qc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// Reading flags, a flagged reading may have more than one.
+const (
+	FlagSpike = "S"
+	FlagStep  = "J"
+	FlagFlat  = "F"
+	FlagRange = "R"
+)
+
+// Spike flags readings that differ from the median of the surrounding window of readings by
+// more than Threshold times the scaled median absolute deviation (MAD) of the window, and by
+// at least the Minimum value.
+type Spike struct {
+	Window    int     `json:"window" yaml:"window"`
+	Threshold float64 `json:"threshold" yaml:"threshold"`
+	Minimum   float64 `json:"minimum,omitempty" yaml:"minimum,omitempty"`
+}
+
+// Step flags the first reading after a change of more than Threshold between the medians of
+// the Window readings either side.
+type Step struct {
+	Window    int     `json:"window" yaml:"window"`
+	Threshold float64 `json:"threshold" yaml:"threshold"`
+}
+
+// Flat flags runs of at least Count readings that are within Tolerance of the first reading of the run.
+type Flat struct {
+	Count     int     `json:"count" yaml:"count"`
+	Tolerance float64 `json:"tolerance,omitempty" yaml:"tolerance,omitempty"`
+}
+
+// Check holds the detectors to run over the readings of matching channels, any missing detectors are skipped.
+type Check struct {
+	// Srcname is a channel srcname glob pattern, e.g. NZ_EYWM_51_LF?
+	Srcname string `json:"srcname" yaml:"srcname"`
+
+	Spike *Spike `json:"spike,omitempty" yaml:"spike,omitempty"`
+	Step  *Step  `json:"step,omitempty" yaml:"step,omitempty"`
+	Flat  *Flat  `json:"flat,omitempty" yaml:"flat,omitempty"`
+
+	// Min and Max give optional limits for valid readings.
+	Min *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+}
+
+// Match returns whether the check applies to the channel.
+func (c Check) Match(srcname string) bool {
+	ok, err := path.Match(c.Srcname, srcname)
+	return err == nil && ok
+}
+
+// addFlag includes a flag in an existing set of flags, the flags are kept sorted.
+func addFlag(flags, flag string) string {
+	if strings.Contains(flags, flag) {
+		return flags
+	}
+	list := strings.Split(flags+flag, "")
+	sort.Strings(list)
+	return strings.Join(list, "")
+}
+
+// median returns the median of the values, the values are sorted in place.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return 0.5 * (values[n/2-1] + values[n/2])
+}
+
+// window returns a copy of the values between the given indexes, limited to the valid range.
+func window(values []float64, start, end int) []float64 {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(values) {
+		end = len(values)
+	}
+	if !(start < end) {
+		return nil
+	}
+	return append([]float64{}, values[start:end]...)
+}
+
+// madScale converts a median absolute deviation into an equivalent normal standard deviation.
+const madScale = 1.4826
+
+func (s Spike) flags(values []float64) []bool {
+	flags := make([]bool, len(values))
+	for i, v := range values {
+		w := window(values, i-s.Window, i+s.Window+1)
+		if len(w) < 3 {
+			continue
+		}
+
+		m := median(w)
+		for j := range w {
+			w[j] = math.Abs(w[j] - m)
+		}
+		mad := madScale * median(w)
+
+		if d := math.Abs(v - m); d > s.Threshold*mad && d > s.Minimum {
+			flags[i] = true
+		}
+	}
+	return flags
+}
+
+func (s Step) flags(values []float64) []bool {
+	flags := make([]bool, len(values))
+
+	diffs := make([]float64, len(values))
+	for i := s.Window; i+s.Window <= len(values); i++ {
+		diffs[i] = math.Abs(median(window(values, i, i+s.Window)) - median(window(values, i-s.Window, i)))
+	}
+
+	// the median windows give a run of candidates either side of a step, only the centre is flagged
+	for i := 0; i < len(diffs); i++ {
+		if !(diffs[i] > s.Threshold) {
+			continue
+		}
+		start := i
+		for i < len(diffs) && diffs[i] > s.Threshold {
+			i++
+		}
+		flags[(start+i-1)/2] = true
+	}
+
+	return flags
+}
+
+func (f Flat) flags(values []float64) []bool {
+	flags := make([]bool, len(values))
+	for i := 0; i < len(values); {
+		j := i + 1
+		for j < len(values) && math.Abs(values[j]-values[i]) <= f.Tolerance {
+			j++
+		}
+		if j-i >= f.Count {
+			for k := i; k < j; k++ {
+				flags[k] = true
+			}
+		}
+		i = j
+	}
+	return flags
+}
+
+// Apply runs the detectors over the readings, which are sorted by time, and flags any suspect readings.
+func (c Check) Apply(readings []raw.Reading) {
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Less(readings[j])
+	})
+
+	values := make([]float64, len(readings))
+	for i, r := range readings {
+		values[i] = r.Field
+	}
+
+	flag := func(flags []bool, flag string) {
+		for i, ok := range flags {
+			if ok {
+				readings[i].Flag = addFlag(readings[i].Flag, flag)
+			}
+		}
+	}
+
+	if c.Spike != nil && c.Spike.Window > 0 {
+		flag(c.Spike.flags(values), FlagSpike)
+	}
+	if c.Step != nil && c.Step.Window > 0 {
+		flag(c.Step.flags(values), FlagStep)
+	}
+	if c.Flat != nil && c.Flat.Count > 1 {
+		flag(c.Flat.flags(values), FlagFlat)
+	}
+
+	for i, v := range values {
+		if (c.Min != nil && v < *c.Min) || (c.Max != nil && v > *c.Max) {
+			readings[i].Flag = addFlag(readings[i].Flag, FlagRange)
+		}
+	}
+}
+
+// Table holds a list of checks, the first matching entry is used.
+type Table []Check
+
+// Find returns the first check that applies to the channel.
+func (t Table) Find(srcname string) (Check, bool) {
+	for _, c := range t {
+		if c.Match(srcname) {
+			return c, true
+		}
+	}
+	return Check{}, false
+}
+
+// Apply flags the suspect readings of a raw data set using any matching check.
+func (t Table) Apply(r *raw.Raw) {
+	if c, ok := t.Find(r.Label); ok {
+		c.Apply(r.Readings)
+	}
+}
+
+// reach returns how many readings either side of a reading may affect its flags, a step is
+// flagged at the centre of a run of candidates which may extend past its window.
+func (c Check) reach() int {
+	n := 1
+	if c.Spike != nil && c.Spike.Window > n {
+		n = c.Spike.Window
+	}
+	if c.Step != nil && 2*c.Step.Window > n {
+		n = 2 * c.Step.Window
+	}
+	if c.Flat != nil && c.Flat.Count > n {
+		n = c.Flat.Count
+	}
+	return n
+}
+
+// trail holds the latest readings of a channel, as they were before being checked, along with
+// the flags they were last given.
+type trail struct {
+	readings []raw.Reading
+	flags    []string
+}
+
+// Stream applies checks to streamed readings, such as SeedLink packets. A trailing window of
+// readings is kept for each channel so the detectors see across the boundaries between blocks
+// of readings, readings near the end of a block may then have their flags revised once the
+// following block has been added.
+type Stream struct {
+	Table Table
+
+	trails map[string]*trail
+}
+
+// NewStream returns a Stream that applies the given checks.
+func NewStream(table Table) *Stream {
+	return &Stream{
+		Table:  table,
+		trails: make(map[string]*trail),
+	}
+}
+
+// Apply flags the suspect readings of a raw data set using any matching check and the trailing
+// readings of the channel. Any earlier readings with flags revised by the new readings are
+// returned, or nil if there are none.
+func (s *Stream) Apply(r *raw.Raw) *raw.Raw {
+	c, ok := s.Table.Find(r.Label)
+	if !ok || !(len(r.Readings) > 0) {
+		return nil
+	}
+
+	if s.trails == nil {
+		s.trails = make(map[string]*trail)
+	}
+
+	sort.Slice(r.Readings, func(i, j int) bool {
+		return r.Readings[i].Less(r.Readings[j])
+	})
+
+	// only carry on from readings that came before
+	t, ok := s.trails[r.Label]
+	if !ok || (len(t.readings) > 0 && !r.Readings[0].Timestamp.After(t.readings[len(t.readings)-1].Timestamp)) {
+		t = &trail{}
+		s.trails[r.Label] = t
+	}
+
+	readings := append(append([]raw.Reading{}, t.readings...), r.Readings...)
+
+	checked := append([]raw.Reading{}, readings...)
+	c.Apply(checked)
+
+	// only the latest trailing readings lacked the following readings when last checked, the
+	// others are there to give them a full window
+	var revised *raw.Raw
+	for i, f := range t.flags {
+		if i < len(t.flags)-c.reach() {
+			checked[i].Flag = f
+			continue
+		}
+		if checked[i].Flag == f {
+			continue
+		}
+		if revised == nil {
+			revised = raw.NewRaw(r.Label, r.Precision)
+		}
+		revised.Add(checked[i])
+	}
+
+	var flags []string
+	for _, v := range checked {
+		flags = append(flags, v.Flag)
+	}
+	for i := range r.Readings {
+		r.Readings[i].Flag = checked[len(t.readings)+i].Flag
+	}
+
+	// enough readings to check the latest ones again along with those that follow
+	if n := len(readings) - 2*c.reach(); n > 0 {
+		readings, flags = readings[n:], flags[n:]
+	}
+	t.readings, t.flags = readings, flags
+
+	return revised
+}
+
+// LoadTable reads a table of checks from a YAML or JSON file, the format is chosen using the file extension.
+func LoadTable(name string) (Table, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var table Table
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown qc file format: %s", ext)
+	}
+
+	for _, c := range table {
+		if _, err := path.Match(c.Srcname, ""); err != nil {
+			return nil, fmt.Errorf("invalid qc srcname pattern %q: %v", c.Srcname, err)
+		}
+	}
+
+	return table, nil
+}
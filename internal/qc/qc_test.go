@@ -0,0 +1,222 @@
+package qc
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+func testReadings(values []float64) []raw.Reading {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var readings []raw.Reading
+	for i, v := range values {
+		readings = append(readings, raw.NewReading(start.Add(time.Duration(i)*time.Second), "NZ_EYWM_51_LFZ", v))
+	}
+	return readings
+}
+
+func flagged(readings []raw.Reading) map[int]string {
+	flags := make(map[int]string)
+	for i, r := range readings {
+		if r.Flag != "" {
+			flags[i] = r.Flag
+		}
+	}
+	return flags
+}
+
+func TestCheck_Spike(t *testing.T) {
+	var values []float64
+	for i := 0; i < 100; i++ {
+		values = append(values, 100.0+math.Sin(float64(i)/5.0))
+	}
+	values[40] += 25.0
+	values[41] -= 30.0
+
+	readings := testReadings(values)
+	Check{Spike: &Spike{Window: 10, Threshold: 5.0}}.Apply(readings)
+
+	flags := flagged(readings)
+	if len(flags) != 2 || flags[40] != FlagSpike || flags[41] != FlagSpike {
+		t.Errorf("unexpected spike flags: %v", flags)
+	}
+
+	// a minimum deviation avoids flagging small changes in otherwise quiet data
+	readings = testReadings([]float64{10, 10, 10, 11, 10, 10, 10})
+	Check{Spike: &Spike{Window: 3, Threshold: 5.0, Minimum: 2.0}}.Apply(readings)
+	if flags := flagged(readings); len(flags) != 0 {
+		t.Errorf("unexpected minimum spike flags: %v", flags)
+	}
+}
+
+func TestCheck_Step(t *testing.T) {
+	var values []float64
+	for i := 0; i < 100; i++ {
+		v := 100.0 + 0.1*math.Sin(float64(i))
+		if i >= 60 {
+			v += 20.0
+		}
+		values = append(values, v)
+	}
+	// a spike shouldn't be seen as a step
+	values[20] += 50.0
+
+	readings := testReadings(values)
+	Check{Step: &Step{Window: 10, Threshold: 5.0}}.Apply(readings)
+
+	if flags := flagged(readings); len(flags) != 1 || flags[60] != FlagStep {
+		t.Errorf("unexpected step flags: %v", flags)
+	}
+}
+
+func TestCheck_FlatRange(t *testing.T) {
+	min, max := 0.0, 100.0
+
+	readings := testReadings([]float64{1, 2, 3, 5, 5, 5, 5.1, 5, 7, 120, 8, -1})
+	Check{Flat: &Flat{Count: 4, Tolerance: 0.2}, Min: &min, Max: &max}.Apply(readings)
+
+	flags := flagged(readings)
+	for i, f := range map[int]string{3: "F", 4: "F", 5: "F", 6: "F", 7: "F", 9: "R", 11: "R"} {
+		if flags[i] != f {
+			t.Errorf("unexpected flag at %d: %q != %q", i, flags[i], f)
+		}
+	}
+	if len(flags) != 7 {
+		t.Errorf("unexpected flags: %v", flags)
+	}
+
+	if f := addFlag(addFlag("S", FlagRange), FlagFlat); f != "FRS" {
+		t.Errorf("unexpected combined flags: %s", f)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var values []float64
+	for i := 0; i < 100; i++ {
+		values = append(values, 100.0+math.Sin(float64(i)/5.0))
+		if i >= 58 {
+			values[i] += 20.0
+		}
+	}
+	// spikes either side of the packet boundaries, and a step just before one which at first
+	// looks like a spike
+	values[39] += 25.0
+	values[40] -= 30.0
+	values[79] += 25.0
+
+	check := Check{
+		Srcname: "NZ_EYWM_51_LF?",
+		Spike:   &Spike{Window: 10, Threshold: 5.0},
+		Step:    &Step{Window: 5, Threshold: 10.0},
+	}
+
+	expected := testReadings(values)
+	check.Apply(expected)
+	if flags := flagged(expected); !(len(flags) > 3) {
+		t.Fatalf("expected spike and step flags: %v", flags)
+	}
+
+	// readings arrive in packets of twenty, any revised flags replace the earlier ones
+	stream := NewStream(Table{check})
+
+	var revisions int
+	final := make(map[time.Time]string)
+	readings := testReadings(values)
+	for i := 0; i < len(readings); i += 20 {
+		packet := raw.NewRaw("NZ_EYWM_51_LFZ", 0)
+		for _, v := range readings[i : i+20] {
+			packet.Add(v)
+		}
+
+		revised := stream.Apply(packet)
+		for _, v := range packet.Readings {
+			final[v.Timestamp] = v.Flag
+		}
+		if revised != nil {
+			for _, v := range revised.Readings {
+				if _, ok := final[v.Timestamp]; !ok {
+					t.Errorf("unexpected revision of a new reading: %v", v)
+				}
+				final[v.Timestamp] = v.Flag
+				revisions++
+			}
+		}
+	}
+	if revisions == 0 {
+		t.Error("expected the flags of readings before the step to be revised")
+	}
+
+	for _, v := range expected {
+		if f := final[v.Timestamp]; f != v.Flag {
+			t.Errorf("%s: expected flags %q got %q", v.Timestamp, v.Flag, f)
+		}
+	}
+
+	// unmatched channels are left alone
+	if revised := stream.Apply(raw.NewRaw("NZ_SMHS_51_LFZ", 0)); revised != nil {
+		t.Errorf("unexpected revision of an unmatched channel: %v", revised)
+	}
+}
+
+func TestLoadTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"qc.yaml": `
+- srcname: NZ_EYWM_51_LF?
+  spike: {window: 15, threshold: 5, minimum: 0.5}
+  step: {window: 30, threshold: 10}
+  flat: {count: 60}
+  min: 10000
+  max: 70000
+`,
+		"qc.json": `[
+  {"srcname": "NZ_EYWM_51_LF?", "spike": {"window": 15, "threshold": 5, "minimum": 0.5}, "step": {"window": 30, "threshold": 10}, "flat": {"count": 60}, "min": 10000, "max": 70000}
+]`,
+	}
+
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			table, err := LoadTable(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, ok := table.Find("NZ_EYWM_51_LFZ")
+			switch {
+			case !ok:
+				t.Fatal("missing qc check")
+			case c.Spike == nil || c.Spike.Window != 15 || c.Spike.Minimum != 0.5:
+				t.Errorf("unexpected spike check: %+v", c.Spike)
+			case c.Step == nil || c.Step.Threshold != 10:
+				t.Errorf("unexpected step check: %+v", c.Step)
+			case c.Flat == nil || c.Flat.Count != 60:
+				t.Errorf("unexpected flat check: %+v", c.Flat)
+			case c.Min == nil || *c.Min != 10000 || c.Max == nil || *c.Max != 70000:
+				t.Errorf("unexpected range check: %+v", c)
+			}
+			if _, ok := table.Find("NZ_EYWM_50_LKO"); ok {
+				t.Error("unexpected qc check match")
+			}
+		})
+	}
+
+	if _, err := LoadTable(filepath.Join(dir, "qc.csv")); err == nil {
+		t.Error("shouldn't be able to load an unknown file format")
+	}
+}
@@ -0,0 +1,313 @@
+package qc
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// Gap is a run of missing samples on the expected sample grid.
+type Gap struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Missing int       `json:"missing"`
+}
+
+// Duplicate is a repeated timestamp, a conflict indicates the readings have different values.
+type Duplicate struct {
+	Timestamp time.Time `json:"timestamp"`
+	Values    []float64 `json:"values"`
+	Conflict  bool      `json:"conflict"`
+}
+
+// Overlap is a sample grid slot with more than one reading at different times.
+type Overlap struct {
+	Slot       time.Time   `json:"slot"`
+	Timestamps []time.Time `json:"timestamps"`
+}
+
+// Jitter summarises the timing offsets of readings from the expected sample grid.
+type Jitter struct {
+	Max       time.Duration `json:"max"`
+	Mean      time.Duration `json:"mean"`
+	Tolerance time.Duration `json:"tolerance"`
+	Exceeded  int           `json:"exceeded"`
+}
+
+// Report holds the gaps, overlaps, duplicates and timing jitter found in the readings of a
+// single channel over a time window, usually the span of a stored raw file.
+type Report struct {
+	File     string        `json:"file,omitempty"`
+	Label    string        `json:"label"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Period   time.Duration `json:"period"`
+	Expected int           `json:"expected"`
+	Found    int           `json:"found"`
+
+	Gaps       []Gap       `json:"gaps,omitempty"`
+	Overlaps   []Overlap   `json:"overlaps,omitempty"`
+	Duplicates []Duplicate `json:"duplicates,omitempty"`
+	Jitter     Jitter      `json:"jitter"`
+}
+
+// OK returns whether no problems were found.
+func (r Report) OK() bool {
+	return len(r.Gaps) == 0 && len(r.Overlaps) == 0 && len(r.Duplicates) == 0 && r.Jitter.Exceeded == 0
+}
+
+// Conflicts returns the number of duplicated timestamps with conflicting values.
+func (r Report) Conflicts() int {
+	var n int
+	for _, d := range r.Duplicates {
+		if d.Conflict {
+			n++
+		}
+	}
+	return n
+}
+
+// Missing returns the total number of missing samples.
+func (r Report) Missing() int {
+	var n int
+	for _, g := range r.Gaps {
+		n += g.Missing
+	}
+	return n
+}
+
+// NominalPeriod estimates the sample period from the median spacing of the distinct reading times.
+func NominalPeriod(readings []raw.Reading) time.Duration {
+	var times []time.Time
+	for _, r := range readings {
+		times = append(times, r.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+
+	var spacings []time.Duration
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d > 0 {
+			spacings = append(spacings, d)
+		}
+	}
+	if !(len(spacings) > 0) {
+		return 0
+	}
+
+	sort.Slice(spacings, func(i, j int) bool {
+		return spacings[i] < spacings[j]
+	})
+
+	return spacings[len(spacings)/2]
+}
+
+// offset returns the signed offset of a time from the nearest grid point of the given period.
+func offset(at, start time.Time, period time.Duration) time.Duration {
+	d := at.Sub(start) % period
+	switch {
+	case d > period/2:
+		d -= period
+	case d < -period/2:
+		d += period
+	}
+	return d
+}
+
+// Analyse checks the readings of a single channel between the start and end times against
+// the expected sample grid. The grid uses the given sample period, or one estimated from
+// the readings if zero, and is aligned using the median offset of the readings. Readings are
+// treated as jittered if they are more than the tolerance from the grid, a zero tolerance
+// uses a tenth of the sample period.
+func Analyse(label string, readings []raw.Reading, start, end time.Time, period, tolerance time.Duration) Report {
+	report := Report{
+		Label:  label,
+		Start:  start,
+		End:    end,
+		Period: period,
+	}
+
+	if report.Period <= 0 {
+		report.Period = NominalPeriod(readings)
+	}
+	if report.Period <= 0 {
+		report.Found = len(readings)
+		return report
+	}
+	period = report.Period
+
+	if tolerance <= 0 {
+		tolerance = period / 10
+	}
+	report.Jitter.Tolerance = tolerance
+
+	// align the grid using the median reading offset
+	var offsets []time.Duration
+	for _, r := range readings {
+		offsets = append(offsets, offset(r.Timestamp, start, period))
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+
+	grid := start
+	if len(offsets) > 0 {
+		grid = start.Add(offsets[len(offsets)/2])
+	}
+	if grid.Before(start) {
+		grid = grid.Add(period)
+	}
+
+	slot := func(at time.Time) int {
+		return int(math.Floor(float64(at.Sub(grid))/float64(period) + 0.5))
+	}
+
+	if d := end.Sub(grid); d > 0 {
+		report.Expected = int((d + period - 1) / period)
+	}
+
+	// group the readings by timestamp and by grid slot
+	times := make(map[time.Time][]float64)
+	slots := make(map[int][]time.Time)
+
+	var total time.Duration
+	for _, r := range readings {
+		if _, ok := times[r.Timestamp]; !ok {
+			n := slot(r.Timestamp)
+			slots[n] = append(slots[n], r.Timestamp)
+
+			d := r.Timestamp.Sub(grid.Add(time.Duration(n) * period))
+			if d < 0 {
+				d = -d
+			}
+			if d > report.Jitter.Max {
+				report.Jitter.Max = d
+			}
+			if d > tolerance {
+				report.Jitter.Exceeded++
+			}
+			total += d
+
+			report.Found++
+		}
+		times[r.Timestamp] = append(times[r.Timestamp], r.Field)
+	}
+	if report.Found > 0 {
+		report.Jitter.Mean = total / time.Duration(report.Found)
+	}
+
+	for t, v := range times {
+		if len(v) < 2 {
+			continue
+		}
+		d := Duplicate{
+			Timestamp: t,
+			Values:    v,
+		}
+		for _, x := range v[1:] {
+			if x != v[0] && !(math.IsNaN(x) && math.IsNaN(v[0])) {
+				d.Conflict = true
+			}
+		}
+		report.Duplicates = append(report.Duplicates, d)
+	}
+	sort.Slice(report.Duplicates, func(i, j int) bool {
+		return report.Duplicates[i].Timestamp.Before(report.Duplicates[j].Timestamp)
+	})
+
+	var keys []int
+	for k := range slots {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	for _, k := range keys {
+		if len(slots[k]) < 2 {
+			continue
+		}
+		sort.Slice(slots[k], func(i, j int) bool {
+			return slots[k][i].Before(slots[k][j])
+		})
+		report.Overlaps = append(report.Overlaps, Overlap{
+			Slot:       grid.Add(time.Duration(k) * period),
+			Timestamps: slots[k],
+		})
+	}
+
+	for n := 0; n < report.Expected; n++ {
+		if _, ok := slots[n]; ok {
+			continue
+		}
+		m := n
+		for m < report.Expected {
+			if _, ok := slots[m]; ok {
+				break
+			}
+			m++
+		}
+		report.Gaps = append(report.Gaps, Gap{
+			Start:   grid.Add(time.Duration(n) * period),
+			End:     grid.Add(time.Duration(m-1) * period),
+			Missing: m - n,
+		})
+		n = m
+	}
+
+	return report
+}
+
+// WriteText writes a plain text summary of the report with details of any problems found.
+func (r Report) WriteText(wr io.Writer) error {
+	name := r.File
+	if name == "" {
+		name = r.Label
+	}
+
+	status := "ok"
+	if !r.OK() {
+		status = "problems"
+	}
+
+	if _, err := fmt.Fprintf(wr, "%s %s %s %s period=%s expected=%d found=%d missing=%d gaps=%d overlaps=%d duplicates=%d conflicts=%d jitter=%s/%s exceeded=%d %s\n",
+		name, r.Label, r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Period, r.Expected, r.Found, r.Missing(),
+		len(r.Gaps), len(r.Overlaps), len(r.Duplicates), r.Conflicts(), r.Jitter.Max, r.Jitter.Mean, r.Jitter.Exceeded, status); err != nil {
+		return err
+	}
+
+	for _, g := range r.Gaps {
+		if _, err := fmt.Fprintf(wr, "  gap %s %s missing=%d\n", g.Start.Format(time.RFC3339Nano), g.End.Format(time.RFC3339Nano), g.Missing); err != nil {
+			return err
+		}
+	}
+	for _, o := range r.Overlaps {
+		var times []string
+		for _, t := range o.Timestamps {
+			times = append(times, t.Format(time.RFC3339Nano))
+		}
+		if _, err := fmt.Fprintf(wr, "  overlap %s %s\n", o.Slot.Format(time.RFC3339Nano), strings.Join(times, " ")); err != nil {
+			return err
+		}
+	}
+	for _, d := range r.Duplicates {
+		var values []string
+		for _, v := range d.Values {
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		kind := "duplicate"
+		if d.Conflict {
+			kind = "conflict"
+		}
+		if _, err := fmt.Fprintf(wr, "  %s %s %s\n", kind, d.Timestamp.Format(time.RFC3339Nano), strings.Join(values, " ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package qc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+func TestAnalyse(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+
+	// readings offset from the second by 20ms
+	var readings []raw.Reading
+	for i := 0; i < 60; i++ {
+		at := start.Add(time.Duration(i)*time.Second + 20*time.Millisecond)
+		switch {
+		case i >= 10 && i < 15:
+			// a five second gap
+			continue
+		case i == 20:
+			// a late reading
+			at = at.Add(300 * time.Millisecond)
+		case i == 30:
+			// a duplicated reading with a different value
+			readings = append(readings, raw.NewReading(at, "NZ_EYWM_51_LFZ", 2.0))
+		case i == 40:
+			// a duplicated reading with the same value
+			readings = append(readings, raw.NewReading(at, "NZ_EYWM_51_LFZ", 1.0))
+		case i == 50:
+			// an overlapping reading
+			readings = append(readings, raw.NewReading(at.Add(-40*time.Millisecond), "NZ_EYWM_51_LFZ", 1.0))
+		}
+		readings = append(readings, raw.NewReading(at, "NZ_EYWM_51_LFZ", 1.0))
+	}
+
+	report := Analyse("NZ_EYWM_51_LFZ", readings, start, end, 0, 0)
+
+	if report.Period != time.Second || report.Expected != 60 || report.Found != 56 {
+		t.Errorf("unexpected report summary: period=%s expected=%d found=%d", report.Period, report.Expected, report.Found)
+	}
+	if len(report.Gaps) != 1 || report.Missing() != 5 || !report.Gaps[0].Start.Equal(start.Add(10*time.Second+20*time.Millisecond)) {
+		t.Errorf("unexpected gaps: %+v", report.Gaps)
+	}
+	if len(report.Duplicates) != 2 || report.Conflicts() != 1 || !report.Duplicates[0].Conflict {
+		t.Errorf("unexpected duplicates: %+v", report.Duplicates)
+	}
+	if len(report.Overlaps) != 1 || len(report.Overlaps[0].Timestamps) != 2 {
+		t.Errorf("unexpected overlaps: %+v", report.Overlaps)
+	}
+	if report.Jitter.Max != 300*time.Millisecond || report.Jitter.Exceeded != 1 {
+		t.Errorf("unexpected jitter: %+v", report.Jitter)
+	}
+	if report.OK() {
+		t.Error("expected problems to be reported")
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 5 || !strings.HasSuffix(lines[0], " problems") {
+		t.Errorf("unexpected text report:\n%s", buf.String())
+	}
+
+	// a complete set of readings
+	readings = nil
+	for i := 0; i < 60; i++ {
+		readings = append(readings, raw.NewReading(start.Add(time.Duration(i)*time.Second), "NZ_EYWM_51_LFZ", 1.0))
+	}
+	if report := Analyse("NZ_EYWM_51_LFZ", readings, start, end, time.Second, 0); !report.OK() || report.Expected != 60 {
+		t.Errorf("unexpected problems: %+v", report)
+	}
+}
@@ -0,0 +1,170 @@
+package raw
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Buffer holds readings in memory until they are written to their files, rather than rewriting
+// each file for every new block of readings. Readings are stored when the flush interval has
+// passed since the last write, or once a channel has moved on to a later file, so disk access
+// depends on the number of files rather than the number of blocks. Files are still merged with
+// any stored readings and written atomically, although a merge policy which rejects conflicts
+// will now reject all the readings buffered for a file.
+type Buffer struct {
+	Format     string
+	TimeFormat string
+	Headers    map[string]Header
+	Merger     *Merger
+	Base       string
+	Path       string
+	Truncate   time.Duration
+	Interval   time.Duration
+
+	mu      sync.Mutex
+	pending map[bufferKey]*Raw
+	latest  map[string]time.Time
+	last    time.Time
+
+	// now is used to check the flush interval, mainly for testing.
+	now func() time.Time
+}
+
+type bufferKey struct {
+	label string
+	at    time.Time
+}
+
+// NewBuffer returns a Buffer that stores readings using the given file format, timestamp precision
+// and path template, an interval of zero stores readings as soon as they are added.
+func NewBuffer(format, timeformat string, headers map[string]Header, merger *Merger, base, path string, truncate, interval time.Duration) *Buffer {
+	return &Buffer{
+		Format:     format,
+		TimeFormat: timeformat,
+		Headers:    headers,
+		Merger:     merger,
+		Base:       base,
+		Path:       path,
+		Truncate:   truncate,
+		Interval:   interval,
+
+		pending: make(map[bufferKey]*Raw),
+		latest:  make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Add buffers the readings, any files that are due are then stored.
+func (b *Buffer) Add(raws ...*Raw) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.last.IsZero() {
+		b.last = b.now()
+	}
+
+	for _, r := range raws {
+		for _, f := range r.Split(b.Truncate) {
+			k := bufferKey{label: f.Label, at: f.Timestamp}
+			if _, ok := b.pending[k]; !ok {
+				b.pending[k] = NewRaw(f.Label, f.Precision)
+			}
+			for _, v := range f.Readings {
+				b.pending[k].Add(v)
+			}
+			if t, ok := b.latest[f.Label]; !ok || f.Timestamp.After(t) {
+				b.latest[f.Label] = f.Timestamp
+			}
+		}
+	}
+
+	if !(b.now().Sub(b.last) < b.Interval) {
+		return b.flush(func(bufferKey) bool { return true })
+	}
+
+	// channels that have rolled over into a later file
+	return b.flush(func(k bufferKey) bool {
+		return k.at.Before(b.latest[k.label])
+	})
+}
+
+// Flush stores all the buffered readings.
+func (b *Buffer) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.flush(func(bufferKey) bool { return true })
+}
+
+// Expire stores all the buffered readings if the flush interval has passed since the last write,
+// this allows quiet channels to be written out.
+func (b *Buffer) Expire() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.now().Sub(b.last) < b.Interval {
+		return nil
+	}
+
+	return b.flush(func(bufferKey) bool { return true })
+}
+
+// Len returns the number of buffered files.
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.pending)
+}
+
+// flush stores the buffered files that match, the caller needs to hold the lock.
+func (b *Buffer) flush(match func(bufferKey) bool) error {
+	var keys []bufferKey
+	for k := range b.pending {
+		if match(k) {
+			keys = append(keys, k)
+		}
+	}
+
+	if len(keys) == len(b.pending) {
+		b.last = b.now()
+	}
+
+	if !(len(keys) > 0) {
+		return nil
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		switch {
+		case keys[i].at.Equal(keys[j].at):
+			return keys[i].label < keys[j].label
+		default:
+			return keys[i].at.Before(keys[j].at)
+		}
+	})
+
+	var raws []*Raw
+	for _, k := range keys {
+		r := b.pending[k]
+
+		// repeated blocks are reduced to the latest readings
+		readings, err := (*Merger)(nil).Merge(nil, r.Readings)
+		if err != nil {
+			return err
+		}
+		r.Readings = readings
+
+		raws = append(raws, r)
+	}
+
+	if err := StoreFormat(b.Format, b.TimeFormat, raws, b.Headers, b.Merger, b.Base, b.Path, b.Truncate); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		delete(b.pending, k)
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package raw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const path = "{{year}}.{{yearday}}.{{hour}}.{{.Label}}.csv"
+	const label = "NZ_EYWM_51_LFZ"
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	clock := start
+	buffer := NewBuffer("csv", "", nil, nil, dir, path, time.Hour, time.Minute)
+	buffer.now = func() time.Time { return clock }
+
+	block := func(offset time.Duration, n int) *Raw {
+		r := NewRaw(label, 0)
+		for i := 0; i < n; i++ {
+			t := start.Add(offset + time.Duration(i)*time.Second)
+			r.Add(NewReading(t, label, float64(t.Sub(start)/time.Second)))
+		}
+		return r
+	}
+
+	stored := func(from, to time.Time) int {
+		loaded, err := Load(dir, path, time.Hour, label, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(loaded.Readings)
+	}
+
+	// blocks within the interval are only held in memory, repeats included
+	for _, offset := range []time.Duration{0, 10 * time.Second, 10 * time.Second} {
+		if err := buffer.Add(block(offset, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := stored(start, start.Add(time.Hour)); n != 0 {
+		t.Errorf("expected no stored readings before the interval got %d", n)
+	}
+
+	// rolling over into the next hour stores the earlier file
+	if err := buffer.Add(block(time.Hour-5*time.Second, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if n := stored(start, start.Add(time.Hour)); n != 25 {
+		t.Errorf("expected 25 stored readings after the rollover got %d", n)
+	}
+	if n := buffer.Len(); n != 1 {
+		t.Errorf("expected a single buffered file got %d", n)
+	}
+
+	// nothing is due until the interval has passed
+	if err := buffer.Expire(); err != nil {
+		t.Fatal(err)
+	}
+	if n := stored(start.Add(time.Hour), start.Add(2*time.Hour)); n != 0 {
+		t.Errorf("expected no stored readings in the next hour got %d", n)
+	}
+
+	clock = clock.Add(time.Minute)
+	if err := buffer.Expire(); err != nil {
+		t.Fatal(err)
+	}
+	if n := stored(start.Add(time.Hour), start.Add(2*time.Hour)); n != 5 {
+		t.Errorf("expected 5 stored readings in the next hour got %d", n)
+	}
+	if n := buffer.Len(); n != 0 {
+		t.Errorf("expected an empty buffer got %d", n)
+	}
+
+	// later blocks are merged with the stored file
+	if err := buffer.Add(block(time.Hour+5*time.Second, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := buffer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := stored(start.Add(time.Hour), start.Add(2*time.Hour)); n != 15 {
+		t.Errorf("expected 15 stored readings in the next hour got %d", n)
+	}
+
+	// a zero interval stores readings straight away
+	direct := NewBuffer("csv", "", nil, nil, dir, path, time.Hour, 0)
+	if err := direct.Add(block(2*time.Hour, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if n := stored(start.Add(2*time.Hour), start.Add(3*time.Hour)); n != 10 {
+		t.Errorf("expected 10 stored readings without an interval got %d", n)
+	}
+}
+
+func TestBuffer_Revision(t *testing.T) {
+	const path = "{{year}}.{{yearday}}.{{hour}}.{{.Label}}.csv"
+	const label = "NZ_EYWM_51_LFZ"
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, policy := range Policies {
+		t.Run(string(policy), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "raw")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var buf bytes.Buffer
+			buffer := NewBuffer("csv", "", nil, NewMerger(policy, &buf), dir, path, time.Hour, 0)
+
+			r := NewRaw(label, 0)
+			for i := 0; i < 10; i++ {
+				r.Add(NewReading(start.Add(time.Duration(i)*time.Second), label, float64(i)))
+			}
+			if err := buffer.Add(r); err != nil {
+				t.Fatal(err)
+			}
+
+			// quality control has flagged a stored reading once later readings arrived
+			revised := NewRaw(label, 0)
+			reading := NewReading(start.Add(9*time.Second), label, 9.0)
+			reading.Flag = "J"
+			revised.Add(reading)
+			if err := buffer.Add(revised); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := Load(dir, path, time.Hour, label, start, start.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(loaded.Readings); n != 10 {
+				t.Fatalf("expected 10 stored readings got %d", n)
+			}
+			if f := loaded.Readings[9].Flag; f != "J" {
+				t.Errorf("expected the revised flag to be stored got %q", f)
+			}
+			if buf.Len() > 0 {
+				t.Errorf("unexpected conflict: %s", buf.String())
+			}
+		})
+	}
+}
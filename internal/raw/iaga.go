@@ -0,0 +1,451 @@
+package raw
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const iagaFormat = "2006-01-02 15:04:05.000"
+
+const (
+	// IAGAMissing is the IAGA-2002 marker for missing data.
+	IAGAMissing = 99999.0
+	// IAGANotRecorded is the IAGA-2002 marker for an unrecorded component.
+	IAGANotRecorded = 88888.0
+)
+
+// iagaOrder is the preferred order of reported components.
+const iagaOrder = "XYHDEZIVFG"
+
+// Header holds the IAGA-2002 station header details, it also provides an optional
+// mapping from channel codes to the reported component.
+type Header struct {
+	Source      string            `json:"source,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Code        string            `json:"code,omitempty"`
+	Latitude    float64           `json:"latitude,omitempty"`
+	Longitude   float64           `json:"longitude,omitempty"`
+	Elevation   float64           `json:"elevation,omitempty"`
+	Orientation string            `json:"orientation,omitempty"`
+	Sampling    string            `json:"sampling,omitempty"`
+	Interval    string            `json:"interval,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Comments    []string          `json:"comments,omitempty"`
+	Channels    map[string]string `json:"channels,omitempty"`
+}
+
+// Component returns the reported component for a given channel code, either from
+// the explicit channel mapping or using the channel orientation code.
+func (h Header) Component(channel string) string {
+	if c, ok := h.Channels[channel]; ok {
+		return strings.ToUpper(c)
+	}
+	if channel == "" {
+		return ""
+	}
+	switch c := strings.ToUpper(channel[len(channel)-1:]); c {
+	case "N":
+		return "X"
+	case "E":
+		return "Y"
+	default:
+		if strings.Contains(iagaOrder, c) {
+			return c
+		}
+		return ""
+	}
+}
+
+// LoadHeaders reads a JSON file of station headers keyed by the station label.
+func LoadHeaders(path string) (map[string]Header, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]Header)
+	if err := json.Unmarshal(data, &headers); err != nil {
+		return nil, err
+	}
+
+	return headers, nil
+}
+
+// IAGA holds multi-component readings from a single station in IAGA-2002 format,
+// readings are stored by their reported component.
+type IAGA struct {
+	Station
+
+	Header Header
+}
+
+// NewIAGA returns an IAGA for the given station label and header.
+func NewIAGA(label string, header Header) *IAGA {
+	return &IAGA{
+		Station: Station{
+			Label:     label,
+			Precision: 2,
+			Readings:  make(map[string][]Reading),
+		},
+		Header: header,
+	}
+}
+
+// GroupIAGA combines channel based raw data into station based IAGA data, channels
+// that do not map to a reported component are ignored.
+func GroupIAGA(raws []*Raw, headers map[string]Header) []*IAGA {
+	cache := make(map[string]*IAGA)
+	for _, r := range raws {
+		station, channel := SplitSrcName(r.Label)
+
+		header, ok := headers[station]
+		if parts := strings.Split(station, "_"); !ok && len(parts) > 2 {
+			header = headers[strings.Join(parts[:2], "_")]
+		}
+
+		component := header.Component(channel)
+		if component == "" {
+			continue
+		}
+
+		if _, ok := cache[station]; !ok {
+			cache[station] = NewIAGA(station, header)
+		}
+		for _, v := range r.Readings {
+			cache[station].Add(component, v)
+		}
+	}
+
+	var res []*IAGA
+	for _, v := range cache {
+		res = append(res, v)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Label < res[j].Label
+	})
+
+	return res
+}
+
+// Code returns the IAGA station code used in the header and column names.
+func (g *IAGA) Code() string {
+	if g.Header.Code != "" {
+		return strings.ToUpper(g.Header.Code)
+	}
+	if parts := strings.Split(g.Label, "_"); len(parts) > 1 {
+		return strings.ToUpper(parts[1])
+	}
+	return strings.ToUpper(g.Label)
+}
+
+// Components returns the reported components, padded to four where possible.
+func (g *IAGA) Components() []string {
+	var components []string
+	for k := range g.Readings {
+		components = append(components, k)
+	}
+
+	defaults := "XYZF"
+	if _, ok := g.Readings["H"]; ok {
+		defaults = "HDZF"
+	}
+	for _, c := range defaults {
+		if len(components) >= 4 {
+			break
+		}
+		if _, ok := g.Readings[string(c)]; !ok {
+			components = append(components, string(c))
+		}
+	}
+
+	sort.Slice(components, func(i, j int) bool {
+		return strings.Index(iagaOrder, components[i]) < strings.Index(iagaOrder, components[j])
+	})
+
+	return components
+}
+
+// interval guesses the data interval type from the reading spacing.
+func (g *IAGA) interval() string {
+	if g.Header.Interval != "" {
+		return g.Header.Interval
+	}
+	for _, v := range g.Readings {
+		if len(v) < 2 {
+			continue
+		}
+		dt := v[1].Timestamp.Sub(v[0].Timestamp)
+		switch {
+		case dt == time.Second:
+			return "1-second"
+		case dt == time.Minute:
+			return "1-minute"
+		case dt == time.Hour:
+			return "1-hour"
+		case dt == 24*time.Hour:
+			return "1-day"
+		case dt > 0:
+			return fmt.Sprintf("%g-second", dt.Seconds())
+		}
+	}
+	return ""
+}
+
+func (g *IAGA) Marshal() ([]byte, error) {
+
+	var buf bytes.Buffer
+	if err := g.Encode(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (g *IAGA) Unmarshal(data []byte) error {
+
+	if err := g.Decode(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Merge adds readings from existing encoded data, readings with the same component and
+// timestamp are resolved using the Merger policy.
+func (g *IAGA) Merge(data []byte) error {
+
+	var iaga IAGA
+	if err := iaga.Unmarshal(data); err != nil {
+		return err
+	}
+
+	return g.merge(&iaga.Station)
+}
+
+// Decode reads IAGA-2002 formatted data, header details are stored but unrecorded
+// or missing values are skipped.
+func (g *IAGA) Decode(rd io.Reader) error {
+
+	if g.Readings == nil {
+		g.Readings = make(map[string][]Reading)
+	}
+
+	var columns []string
+
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.TrimSpace(line) == "":
+		case strings.HasPrefix(line, " #"):
+			g.Header.Comments = append(g.Header.Comments, strings.TrimSpace(strings.TrimSuffix(line[2:], "|")))
+		case strings.HasPrefix(line, "DATE"):
+			fields := strings.Fields(strings.TrimSuffix(line, "|"))
+			if len(fields) < 3 {
+				return fmt.Errorf("invalid iaga column header: %s", line)
+			}
+			columns = fields[3:]
+		case strings.HasPrefix(line, " "):
+			line = strings.TrimSuffix(strings.TrimRight(line, " "), "|")
+			if len(line) < 24 {
+				continue
+			}
+			if err := g.Header.decode(strings.TrimSpace(line[1:24]), strings.TrimSpace(line[24:])); err != nil {
+				return err
+			}
+		default:
+			fields := strings.Fields(line)
+			if len(fields) < 3 || len(fields) > 3+len(columns) {
+				return fmt.Errorf("invalid iaga data line: %s", line)
+			}
+			t, err := time.Parse(iagaFormat, fields[0]+" "+fields[1])
+			if err != nil {
+				return err
+			}
+			for i, f := range fields[3:] {
+				v, err := strconv.ParseFloat(f, 64)
+				if err != nil {
+					return err
+				}
+				if v >= IAGANotRecorded {
+					continue
+				}
+				column := columns[i]
+				g.Add(column[len(column)-1:], NewReading(t, column, v))
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Header) decode(key, value string) error {
+	var err error
+
+	switch strings.ToLower(key) {
+	case "format":
+		if !strings.EqualFold(value, "IAGA-2002") {
+			return fmt.Errorf("unknown iaga format: %s", value)
+		}
+	case "source of data":
+		h.Source = value
+	case "station name":
+		h.Name = value
+	case "iaga code", "iaga code (or)":
+		h.Code = value
+	case "geodetic latitude":
+		h.Latitude, err = strconv.ParseFloat(value, 64)
+	case "geodetic longitude":
+		h.Longitude, err = strconv.ParseFloat(value, 64)
+	case "elevation":
+		h.Elevation, err = strconv.ParseFloat(value, 64)
+	case "sensor orientation":
+		h.Orientation = value
+	case "digital sampling":
+		h.Sampling = value
+	case "data interval type":
+		h.Interval = value
+	case "data type":
+		h.Type = value
+	}
+
+	return err
+}
+
+func (g *IAGA) Encode(wr io.Writer) error {
+
+	components := g.Components()
+	if len(components) > 4 {
+		return fmt.Errorf("too many iaga components for %s: %s", g.Label, strings.Join(components, ""))
+	}
+
+	code := g.Code()
+	kind := g.Header.Type
+	if kind == "" {
+		kind = "variation"
+	}
+
+	header := [][2]string{
+		{"Format", "IAGA-2002"},
+		{"Source of Data", g.Header.Source},
+		{"Station Name", g.Header.Name},
+		{"IAGA Code", code},
+		{"Geodetic Latitude", strconv.FormatFloat(g.Header.Latitude, 'f', 3, 64)},
+		{"Geodetic Longitude", strconv.FormatFloat(g.Header.Longitude, 'f', 3, 64)},
+		{"Elevation", strconv.FormatFloat(g.Header.Elevation, 'f', -1, 64)},
+		{"Reported", strings.Join(components, "")},
+		{"Sensor Orientation", g.Header.Orientation},
+		{"Digital Sampling", g.Header.Sampling},
+		{"Data Interval Type", g.interval()},
+		{"Data Type", kind},
+	}
+
+	w := bufio.NewWriter(wr)
+	for _, h := range header {
+		fmt.Fprintf(w, " %-23s%-45s|\n", h[0], h[1])
+	}
+	for _, c := range g.Header.Comments {
+		fmt.Fprintf(w, " # %-66s|\n", c)
+	}
+
+	columns := fmt.Sprintf("%-32s", "DATE       TIME         DOY")
+	for _, c := range components {
+		columns += fmt.Sprintf("%-10s", code+c)
+	}
+	fmt.Fprintf(w, "%-69s|\n", strings.TrimRight(columns, " "))
+
+	for _, r := range g.Rows(components) {
+		fmt.Fprintf(w, "%s %03d   ", r.Timestamp.UTC().Format(iagaFormat), r.Timestamp.UTC().YearDay())
+		for i, v := range r.Values {
+			switch _, ok := g.Readings[components[i]]; {
+			case !ok:
+				v = IAGANotRecorded
+			case math.IsNaN(v):
+				v = IAGAMissing
+			}
+			fmt.Fprintf(w, " %9s", strconv.FormatFloat(v, 'f', g.Precision, 64))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
+	return w.Flush()
+}
+
+// Filename builds a standard file path name.
+func (g *IAGA) Filename(path string) ([]byte, error) {
+	return filename(path, g.Tag(), g.Label, g.At(), g)
+}
+
+func (g *IAGA) Split(truncate time.Duration) []*IAGA {
+
+	var res []*IAGA
+	for k, v := range g.split(truncate) {
+		res = append(res, &IAGA{
+			Station: Station{
+				Label:     g.Label,
+				Precision: g.Precision,
+				Timestamp: k,
+				Readings:  v,
+				Merger:    g.Merger,
+			},
+			Header: g.Header,
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].At().Before(res[j].At())
+	})
+
+	return res
+}
+
+func (g *IAGA) Store(base, path string, truncate time.Duration) error {
+	for _, f := range g.Split(truncate) {
+		basename, err := f.Filename(path)
+		if err != nil {
+			return err
+		}
+
+		filename := filepath.Join(base, string(basename))
+
+		if _, err := os.Stat(filename); err == nil {
+			data, err := readFile(filename)
+			if err != nil {
+				return err
+			}
+			switch err := f.Merge(data); {
+			case err == ErrConflict:
+				// leave the stored file alone, the conflicts have been logged
+				continue
+			case err != nil:
+				return err
+			}
+		}
+
+		data, err := f.Marshal()
+		if err != nil {
+			return err
+		}
+
+		if err := writeFile(filename, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package raw
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIAGA_Encode(t *testing.T) {
+	at := time.Date(2019, time.May, 26, 0, 0, 0, 0, time.UTC)
+
+	lfn, lfe, lfz := NewRaw("NZ_EYWM_51_LFN", 0), NewRaw("NZ_EYWM_51_LFE", 0), NewRaw("NZ_EYWM_51_LFZ", 0)
+	for i := 0; i < 3; i++ {
+		ts := at.Add(time.Duration(i) * time.Second)
+		lfn.Add(NewReading(ts, lfn.Label, 18000.25+float64(i)))
+		lfe.Add(NewReading(ts, lfe.Label, 2000.5))
+		if i != 1 {
+			lfz.Add(NewReading(ts, lfz.Label, -52000.0))
+		}
+	}
+
+	headers := map[string]Header{
+		"NZ_EYWM": {
+			Name:      "Eyrewell",
+			Code:      "EYR",
+			Latitude:  -43.474,
+			Longitude: 172.393,
+			Elevation: 102,
+		},
+	}
+
+	stations := GroupIAGA([]*Raw{lfn, lfe, lfz}, headers)
+	if len(stations) != 1 {
+		t.Fatalf("expected a single station got %d", len(stations))
+	}
+
+	data, err := stations[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	for _, l := range lines[:13] {
+		if len(l) != 70 || !strings.HasSuffix(l, "|") {
+			t.Errorf("invalid header line length %d: %q", len(l), l)
+		}
+	}
+
+	expected := []string{
+		" Reported               XYZF                                         |",
+		"DATE       TIME         DOY     EYRX      EYRY      EYRZ      EYRF   |",
+		"2019-05-26 00:00:00.000 146     18000.25   2000.50 -52000.00  88888.00",
+		"2019-05-26 00:00:01.000 146     18001.25   2000.50  99999.00  88888.00",
+		"2019-05-26 00:00:02.000 146     18002.25   2000.50 -52000.00  88888.00",
+	}
+	for _, e := range expected {
+		if !strings.Contains(string(data), e+"\n") {
+			t.Errorf("missing expected line: %q\n%s", e, string(data))
+		}
+	}
+
+	var iaga IAGA
+	if err := iaga.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if iaga.Header.Code != "EYR" || iaga.Header.Name != "Eyrewell" || iaga.Header.Latitude != -43.474 {
+		t.Errorf("unexpected header: %+v", iaga.Header)
+	}
+	for k, v := range map[string]int{"X": 3, "Y": 3, "Z": 2, "F": 0} {
+		if n := len(iaga.Readings[k]); n != v {
+			t.Errorf("expected %d %s readings got %d", v, k, n)
+		}
+	}
+}
+
+func TestHeader_Component(t *testing.T) {
+	h := Header{Channels: map[string]string{"LFA": "f"}}
+	for k, v := range map[string]string{"LFN": "X", "LFE": "Y", "LFZ": "Z", "LFH": "H", "LFD": "D", "LFA": "F", "LKO": ""} {
+		if c := h.Component(k); c != v {
+			t.Errorf("expected component %q for %s got %q", v, k, c)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package raw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Policy decides which reading is kept when new and stored readings conflict.
+type Policy string
+
+const (
+	// PreferNew replaces stored readings with new ones, the original behaviour.
+	PreferNew Policy = "new"
+	// PreferExisting keeps stored readings and only adds new readings.
+	PreferExisting Policy = "existing"
+	// PreferQuality keeps the reading with the fewest quality control flags, readings
+	// without a value are the lowest quality, stored readings win any tie.
+	PreferQuality Policy = "quality"
+	// RejectConflict leaves a stored file untouched if any new reading conflicts with it.
+	RejectConflict Policy = "reject"
+)
+
+// Policies lists the supported merge policies.
+var Policies = []Policy{PreferNew, PreferExisting, PreferQuality, RejectConflict}
+
+// ValidPolicy returns whether the merge policy is supported.
+func ValidPolicy(policy string) bool {
+	for _, p := range Policies {
+		if string(p) == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrConflict is returned when a merge is rejected due to conflicting readings.
+var ErrConflict = errors.New("conflicting readings")
+
+// Merger combines new readings with stored readings using a merge Policy. Readings conflict if
+// they have the same label and timestamp but a different value, each conflict is written to the
+// Log, if given, as a single line. New readings that only differ by their flags, such as when
+// quality control checks revise earlier readings, replace the stored readings without conflict.
+// A nil Merger prefers new readings without logging.
+type Merger struct {
+	Policy Policy
+	Log    io.Writer
+}
+
+// NewMerger returns a Merger for the given policy and optional conflict log.
+func NewMerger(policy Policy, log io.Writer) *Merger {
+	return &Merger{
+		Policy: policy,
+		Log:    log,
+	}
+}
+
+// equal returns whether two readings hold the same value and flags.
+func equal(a, b Reading) bool {
+	return a.Flag == b.Flag && same(a, b)
+}
+
+// same returns whether two readings hold the same value, ignoring any flags.
+func same(a, b Reading) bool {
+	if math.IsNaN(a.Field) || math.IsNaN(b.Field) {
+		return math.IsNaN(a.Field) && math.IsNaN(b.Field)
+	}
+	return a.Field == b.Field
+}
+
+// quality returns a ranking of a reading, lower values are better.
+func quality(r Reading) int {
+	if math.IsNaN(r.Field) {
+		return math.MaxInt32
+	}
+	return len(r.Flag)
+}
+
+// format returns a reading value and any flags for logging.
+func format(r Reading) string {
+	v := strconv.FormatFloat(r.Field, 'f', -1, 64)
+	if r.Flag != "" {
+		return v + "/" + r.Flag
+	}
+	return v
+}
+
+func (m *Merger) policy() Policy {
+	if m == nil || m.Policy == "" {
+		return PreferNew
+	}
+	return m.Policy
+}
+
+func (m *Merger) log(existing, reading Reading, kept string) {
+	if m == nil || m.Log == nil {
+		return
+	}
+	fmt.Fprintf(m.Log, "%s %s existing=%s new=%s diff=%s policy=%s kept=%s\n",
+		reading.Timestamp.Format(time.RFC3339Nano), reading.Label, format(existing), format(reading),
+		strconv.FormatFloat(reading.Field-existing.Field, 'g', -1, 64), m.policy(), kept)
+}
+
+// Merge combines the new readings with the existing ones, the result is sorted by time and
+// label. Duplicated new readings are reduced to the last one given. If the policy rejects
+// conflicts then ErrConflict is returned along with the unchanged existing readings.
+func (m *Merger) Merge(existing, readings []Reading) ([]Reading, error) {
+	type key struct {
+		t     int64
+		label string
+	}
+
+	cache := make(map[key]Reading)
+	for _, r := range existing {
+		cache[key{r.Timestamp.UnixNano(), r.Label}] = r
+	}
+
+	latest := make(map[key]Reading)
+	var keys []key
+	for _, r := range readings {
+		k := key{r.Timestamp.UnixNano(), r.Label}
+		if _, ok := latest[k]; !ok {
+			keys = append(keys, k)
+		}
+		latest[k] = r
+	}
+
+	var conflicts int
+	for _, k := range keys {
+		r := latest[k]
+
+		v, ok := cache[k]
+		switch {
+		case !ok:
+			cache[k] = r
+		case equal(v, r):
+		case same(v, r):
+			cache[k] = r
+		default:
+			conflicts++
+			switch m.policy() {
+			case PreferExisting:
+				m.log(v, r, "existing")
+			case PreferQuality:
+				if quality(r) < quality(v) {
+					cache[k] = r
+					m.log(v, r, "new")
+				} else {
+					m.log(v, r, "existing")
+				}
+			case RejectConflict:
+				m.log(v, r, "rejected")
+			default:
+				cache[k] = r
+				m.log(v, r, "new")
+			}
+		}
+	}
+
+	if conflicts > 0 && m.policy() == RejectConflict {
+		return existing, ErrConflict
+	}
+
+	var res []Reading
+	for _, v := range cache {
+		res = append(res, v)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		switch {
+		case res[i].Timestamp.Equal(res[j].Timestamp):
+			return res[i].Label < res[j].Label
+		default:
+			return res[i].Less(res[j])
+		}
+	})
+
+	return res, nil
+}
@@ -0,0 +1,210 @@
+package raw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMerger_Merge(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	reading := func(n int, v float64, flag string) Reading {
+		r := NewReading(start.Add(time.Duration(n)*time.Second), "NZ_EYWM_51_LFZ", v)
+		r.Flag = flag
+		return r
+	}
+
+	existing := []Reading{
+		reading(0, 1.0, ""),
+		reading(1, 2.0, ""),
+		reading(2, 3.0, "S"),
+		reading(3, math.NaN(), ""),
+	}
+	readings := []Reading{
+		reading(4, 5.0, ""),
+		reading(1, 2.5, "S"),
+		reading(2, 3.5, ""),
+		reading(3, 4.0, ""),
+		reading(0, 1.0, ""),
+	}
+
+	tests := map[Policy][]float64{
+		PreferNew:      {1.0, 2.5, 3.5, 4.0, 5.0},
+		PreferExisting: {1.0, 2.0, 3.0, math.NaN(), 5.0},
+		PreferQuality:  {1.0, 2.0, 3.5, 4.0, 5.0},
+	}
+
+	for policy, values := range tests {
+		var buf bytes.Buffer
+
+		merged, err := NewMerger(policy, &buf).Merge(existing, readings)
+		if err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if len(merged) != len(values) {
+			t.Fatalf("%s: expected %d readings got %d", policy, len(values), len(merged))
+		}
+		for i, v := range values {
+			if !merged[i].Timestamp.Equal(start.Add(time.Duration(i) * time.Second)) {
+				t.Errorf("%s: readings are not sorted: %v", policy, merged)
+			}
+			if f := merged[i].Field; f != v && !(math.IsNaN(f) && math.IsNaN(v)) {
+				t.Errorf("%s: reading %d expected %g got %g", policy, i, v, f)
+			}
+		}
+		if n := strings.Count(buf.String(), "\n"); n != 3 {
+			t.Errorf("%s: expected 3 logged conflicts got %d: %s", policy, n, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	merged, err := NewMerger(RejectConflict, &buf).Merge(existing, readings)
+	if err != ErrConflict {
+		t.Fatalf("expected a rejected merge got %v", err)
+	}
+	if len(merged) != len(existing) {
+		t.Errorf("expected the existing readings got %v", merged)
+	}
+	if !strings.Contains(buf.String(), "2020-01-01T00:00:01Z NZ_EYWM_51_LFZ existing=2 new=2.5/S diff=0.5 policy=reject kept=rejected") {
+		t.Errorf("unexpected conflict log: %s", buf.String())
+	}
+
+	// a nil merger keeps the original behaviour
+	if merged, err = (*Merger)(nil).Merge(existing, readings); err != nil || merged[1].Field != 2.5 {
+		t.Errorf("expected new readings to be preferred: %v (%v)", merged, err)
+	}
+
+	// readings with different labels don't conflict
+	other := reading(1, 9.0, "")
+	other.Label = "NZ_EYWM_51_LFX"
+	if merged, err = NewMerger(RejectConflict, nil).Merge(existing, []Reading{other}); err != nil || len(merged) != 5 {
+		t.Errorf("unexpected merge of a different label: %v (%v)", merged, err)
+	}
+}
+
+func TestRaw_StoreReject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const path = "{{year}}.{{yearday}}.{{hour}}.{{.Label}}.csv"
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	r := NewRaw("NZ_EYWM_51_LFZ", 1)
+	r.Add(NewReading(start, r.Label, 1.0))
+	r.Add(NewReading(start.Add(time.Hour), r.Label, 2.0))
+	if err := StoreFormat("csv", "", []*Raw{r}, nil, nil, dir, path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first hour conflicts and is left alone, the second is extended
+	update := NewRaw(r.Label, 1)
+	update.Add(NewReading(start, r.Label, 1.5))
+	update.Add(NewReading(start.Add(time.Second), r.Label, 1.6))
+	update.Add(NewReading(start.Add(time.Hour+time.Second), r.Label, 2.1))
+
+	var buf bytes.Buffer
+	if err := StoreFormat("csv", "", []*Raw{update}, nil, NewMerger(RejectConflict, &buf), dir, path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := ioutil.ReadFile(filepath.Join(dir, "2020.001.00.NZ_EYWM_51_LFZ.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(first); s != "2020-01-01T00:00:00Z,NZ_EYWM_51_LFZ,1.0\n" {
+		t.Errorf("expected the conflicting file to be unchanged: %q", s)
+	}
+
+	second, err := ioutil.ReadFile(filepath.Join(dir, "2020.001.01.NZ_EYWM_51_LFZ.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(second), "\n"); n != 2 {
+		t.Errorf("expected the second file to be merged: %q", string(second))
+	}
+
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Errorf("expected a single logged conflict: %s", buf.String())
+	}
+}
+
+func TestStoreFormat_Merge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const path = "{{year}}.{{yearday}}.{{hour}}.{{.Label}}.txt"
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	raws := func(v float64) []*Raw {
+		var res []*Raw
+		for _, c := range []string{"LFX", "LFY", "LFZ", "LFF"} {
+			r := NewRaw("NZ_EYWM_51_"+c, 1)
+			r.Add(NewReading(start, r.Label, v))
+			res = append(res, r)
+		}
+		return res
+	}
+
+	for _, format := range []string{"station", "iaga2002"} {
+		for policy, value := range map[Policy]float64{PreferNew: 2.0, PreferExisting: 1.0} {
+			if err := StoreFormat(format, "", raws(1.0), nil, nil, dir, path, time.Hour); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+
+			var buf bytes.Buffer
+			if err := StoreFormat(format, "", raws(2.0), nil, NewMerger(policy, &buf), dir, path, time.Hour); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+
+			if n := strings.Count(buf.String(), "\n"); n != 4 {
+				t.Errorf("%s/%s: expected 4 logged conflicts got %d: %s", format, policy, n, buf.String())
+			}
+			if !strings.Contains(buf.String(), " NZ_EYWM_51_LFZ existing=1 new=2 ") {
+				t.Errorf("%s/%s: expected conflicts to be logged by srcname: %s", format, policy, buf.String())
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(dir, "2020.001.00.NZ_EYWM_51.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var station Station
+			switch format {
+			case "iaga2002":
+				var iaga IAGA
+				if err := iaga.Unmarshal(data); err != nil {
+					t.Fatal(err)
+				}
+				station = iaga.Station
+			default:
+				if err := station.Unmarshal(data); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for k, v := range station.Readings {
+				if len(v) != 1 || v[0].Field != value {
+					t.Errorf("%s/%s: expected a single %g reading for %s got %v", format, policy, value, k, v)
+				}
+			}
+
+			if err := os.Remove(filepath.Join(dir, "2020.001.00.NZ_EYWM_51.txt")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
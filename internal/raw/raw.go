@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -16,10 +17,45 @@ import (
 
 const rawFormat = "2006-01-02T15:04:05Z"
 
+// Timestamp precisions supported by raw CSV files, older files without fractional seconds
+// can be read whatever the precision.
+const (
+	TimeSecond      = "second"
+	TimeMillisecond = "millisecond"
+	TimeMicrosecond = "microsecond"
+)
+
+// TimeFormats lists the supported timestamp precisions.
+var TimeFormats = []string{TimeSecond, TimeMillisecond, TimeMicrosecond}
+
+// ValidTimeFormat returns whether the timestamp precision is supported.
+func ValidTimeFormat(format string) bool {
+	for _, f := range TimeFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// timeLayout returns the layout used to write timestamps with the given precision, whole seconds by default.
+func timeLayout(format string) string {
+	switch format {
+	case TimeMillisecond:
+		return "2006-01-02T15:04:05.000Z"
+	case TimeMicrosecond:
+		return "2006-01-02T15:04:05.000000Z"
+	default:
+		return rawFormat
+	}
+}
+
 type Reading struct {
 	Timestamp time.Time
 	Label     string
 	Field     float64
+	// Flag holds any quality control flags, empty if the reading hasn't been flagged.
+	Flag string
 }
 
 func NewReading(t time.Time, l string, v float64) Reading {
@@ -52,6 +88,11 @@ type Raw struct {
 	Timestamp time.Time
 
 	Readings []Reading
+
+	// Merger resolves conflicts with stored readings, new readings are preferred if not set.
+	Merger *Merger
+	// TimeFormat sets the precision of stored timestamps, whole seconds if not set.
+	TimeFormat string
 }
 
 func NewRaw(label string, precision int) *Raw {
@@ -61,6 +102,20 @@ func NewRaw(label string, precision int) *Raw {
 	}
 }
 
+// Precision returns the number of decimal places used to store samples, a negative dp
+// selects no decimal places for integer samples and otherwise the shortest representation
+// that preserves the float64 sample value.
+func Precision(dp int, integer bool) int {
+	switch {
+	case dp >= 0:
+		return dp
+	case integer:
+		return 0
+	default:
+		return -1
+	}
+}
+
 func (r *Raw) Add(v Reading) {
 	if t := v.Timestamp; r.Timestamp.IsZero() || r.Timestamp.After(t) {
 		r.Timestamp = t
@@ -76,6 +131,27 @@ func (r *Raw) Tag() string {
 	return r.Label
 }
 
+// Spacing returns the smallest time between consecutive readings, or zero if there are
+// fewer than two distinct reading times.
+func (r *Raw) Spacing() time.Duration {
+	var times []time.Time
+	for _, v := range r.Readings {
+		times = append(times, v.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+
+	var spacing time.Duration
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d > 0 && (spacing == 0 || d < spacing) {
+			spacing = d
+		}
+	}
+
+	return spacing
+}
+
 func (r *Raw) Marshal() ([]byte, error) {
 
 	var buf bytes.Buffer
@@ -95,6 +171,8 @@ func (r *Raw) Unmarshal(data []byte) error {
 	return nil
 }
 
+// Merge adds readings from existing encoded data using the Merger policy, ErrConflict is
+// returned if the merge is rejected.
 func (r *Raw) Merge(data []byte) error {
 
 	var raw Raw
@@ -102,17 +180,9 @@ func (r *Raw) Merge(data []byte) error {
 		return err
 	}
 
-	cache := make(map[time.Time]Reading)
-	for _, r := range raw.Readings {
-		cache[r.Timestamp] = r
-	}
-	for _, r := range r.Readings {
-		cache[r.Timestamp] = r
-	}
-
-	var readings []Reading
-	for _, v := range cache {
-		readings = append(readings, v)
+	readings, err := r.Merger.Merge(raw.Readings, r.Readings)
+	if err != nil {
+		return err
 	}
 
 	r.Readings = readings
@@ -120,9 +190,14 @@ func (r *Raw) Merge(data []byte) error {
 	return nil
 }
 
+// Decode reads raw CSV data, an optional fourth column holds any reading flags. Timestamps
+// may have fractional seconds of any precision.
 func (r *Raw) Decode(rd io.Reader) error {
 
-	records, err := csv.NewReader(rd).ReadAll()
+	reader := csv.NewReader(rd)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
 	if err != nil {
 		return err
 	}
@@ -142,25 +217,42 @@ func (r *Raw) Decode(rd io.Reader) error {
 			return err
 		}
 
-		r.Readings = append(r.Readings, NewReading(t, l[1], v))
+		reading := NewReading(t, l[1], v)
+		if len(l) > 3 {
+			reading.Flag = strings.TrimSpace(l[3])
+		}
+
+		r.Readings = append(r.Readings, reading)
 	}
 
 	return nil
 }
 
+// Encode writes raw CSV data, a flag column is only added if any readings have been flagged.
 func (r *Raw) Encode(wr io.Writer) error {
 
 	sort.Slice(r.Readings, func(i, j int) bool {
 		return r.Readings[i].Less(r.Readings[j])
 	})
 
+	var flagged bool
+	for _, v := range r.Readings {
+		if v.Flag != "" {
+			flagged = true
+		}
+	}
+
 	var lines [][]string
 	for _, v := range r.Readings {
-		lines = append(lines, []string{
-			v.Timestamp.Format(rawFormat),
+		line := []string{
+			v.Timestamp.Format(timeLayout(r.TimeFormat)),
 			v.Label,
 			strconv.FormatFloat(v.Field, 'f', r.Precision, 64),
-		})
+		}
+		if flagged {
+			line = append(line, v.Flag)
+		}
+		lines = append(lines, line)
 	}
 
 	w := csv.NewWriter(wr)
@@ -176,48 +268,146 @@ func (r *Raw) Encode(wr io.Writer) error {
 
 // Filename builds a standard hourly file path name.
 func (r *Raw) Filename(path string) ([]byte, error) {
-	tmpl, err := template.New("raw").Funcs(
-		template.FuncMap{
-			"tag": func() string {
-				return r.Tag()
-			},
-			"at": func(s string) string {
-				return r.At().Format(s)
-			},
-			"year": func() string {
-				return fmt.Sprintf("%04d", r.At().Year())
-			},
-			"yearday": func() string {
-				return fmt.Sprintf("%03d", r.At().YearDay())
-			},
-			"hour": func() string {
-				return fmt.Sprintf("%02d", r.At().Hour())
-			},
-			"minute": func() string {
-				return fmt.Sprintf("%02d", r.At().Minute())
-			},
-			"second": func() string {
-				return fmt.Sprintf("%02d", r.At().Second())
-			},
-			"tolower": func(s string) string {
-				return strings.ToLower(s)
-			},
-			"toupper": func(s string) string {
-				return strings.ToUpper(s)
-			},
-		}).Parse(path)
+	station, _ := SplitSrcName(r.Label)
+	return filename(path, r.Tag(), station, r.At(), r)
+}
+
+// templateFuncs returns the file name template functions for the given tag, station label and time.
+func templateFuncs(tag, station string, at time.Time) template.FuncMap {
+	return template.FuncMap{
+		"tag": func() string {
+			return tag
+		},
+		"station": func() string {
+			return station
+		},
+		"at": func(s string) string {
+			return at.Format(s)
+		},
+		"year": func() string {
+			return fmt.Sprintf("%04d", at.Year())
+		},
+		"yearday": func() string {
+			return fmt.Sprintf("%03d", at.YearDay())
+		},
+		"hour": func() string {
+			return fmt.Sprintf("%02d", at.Hour())
+		},
+		"minute": func() string {
+			return fmt.Sprintf("%02d", at.Minute())
+		},
+		"second": func() string {
+			return fmt.Sprintf("%02d", at.Second())
+		},
+		"tolower": func(s string) string {
+			return strings.ToLower(s)
+		},
+		"toupper": func(s string) string {
+			return strings.ToUpper(s)
+		},
+	}
+}
+
+// filename builds a file path name from a template using the given tag, station label
+// and time, data is passed to the template for direct field access.
+func filename(path, tag, station string, at time.Time, data interface{}) ([]byte, error) {
+	tmpl, err := template.New("raw").Funcs(templateFuncs(tag, station, at)).Parse(path)
 	if err != nil {
 		return nil, err
 	}
 
 	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, r); err != nil {
+	if err := tmpl.Execute(&buf, data); err != nil {
 		return nil, err
 	}
 
 	return buf.Bytes(), nil
 }
 
+// Glob converts a file name template into a file glob pattern matching any file names it could build,
+// all the template values are replaced with wildcards.
+func Glob(path string) (string, error) {
+	funcs := templateFuncs("*", "*", time.Time{})
+	for _, k := range []string{"at", "year", "yearday", "hour", "minute", "second"} {
+		funcs[k] = func(...string) string {
+			return "*"
+		}
+	}
+
+	tmpl, err := template.New("raw").Funcs(funcs).Parse(path)
+	if err != nil {
+		return "", err
+	}
+
+	data := struct {
+		Label string
+	}{
+		Label: "*",
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	glob := buf.String()
+	for strings.Contains(glob, "**") {
+		glob = strings.Replace(glob, "**", "*", -1)
+	}
+
+	return glob, nil
+}
+
+// Pattern converts a file name template into an anchored regular expression matching only the
+// file names it could build, time values must be digits and labels can't hold dots, so derived
+// files with extra suffixes, such as one-minute or resampled files, aren't matched.
+func Pattern(path string) (*regexp.Regexp, error) {
+	// placeholders which are left alone by any case conversion
+	const (
+		label = "\x00\x01\x00"
+		any   = "\x00\x02\x00"
+	)
+	digits := func(n int) string {
+		return fmt.Sprintf("\x00\x03%d\x00", n)
+	}
+
+	funcs := templateFuncs(label, label, time.Time{})
+	funcs["at"] = func(string) string {
+		return any
+	}
+	for k, n := range map[string]int{"year": 4, "yearday": 3, "hour": 2, "minute": 2, "second": 2} {
+		s := digits(n)
+		funcs[k] = func() string {
+			return s
+		}
+	}
+
+	tmpl, err := template.New("raw").Funcs(funcs).Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Label string
+	}{
+		Label: label,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	pattern := regexp.QuoteMeta(buf.String())
+	pattern = strings.Replace(pattern, label, "[^./]+", -1)
+	pattern = strings.Replace(pattern, any, "[^/]*", -1)
+	for _, n := range []int{2, 3, 4} {
+		pattern = strings.Replace(pattern, digits(n), fmt.Sprintf("[0-9]{%d}", n), -1)
+	}
+
+	return regexp.Compile("^" + pattern + "$")
+}
+
 func (r *Raw) Split(truncate time.Duration) []*Raw {
 
 	cache := make(map[time.Time][]Reading)
@@ -234,10 +424,12 @@ func (r *Raw) Split(truncate time.Duration) []*Raw {
 		})
 
 		res = append(res, &Raw{
-			Label:     r.Label,
-			Precision: r.Precision,
-			Timestamp: k,
-			Readings:  v,
+			Label:      r.Label,
+			Precision:  r.Precision,
+			Timestamp:  k,
+			Readings:   v,
+			Merger:     r.Merger,
+			TimeFormat: r.TimeFormat,
 		})
 	}
 
@@ -262,7 +454,11 @@ func (r *Raw) Store(base, path string, truncate time.Duration) error {
 			if err != nil {
 				return err
 			}
-			if err := f.Merge(data); err != nil {
+			switch err := f.Merge(data); {
+			case err == ErrConflict:
+				// leave the stored file alone, the conflicts have been logged
+				continue
+			case err != nil:
 				return err
 			}
 		}
@@ -279,3 +475,47 @@ func (r *Raw) Store(base, path string, truncate time.Duration) error {
 
 	return nil
 }
+
+// Load reads the stored readings of a channel from between the start and end times, the raw
+// files are found using the given path template and truncation interval. Missing files are skipped.
+func Load(base, path string, truncate time.Duration, label string, start, end time.Time) (*Raw, error) {
+
+	res := NewRaw(label, -1)
+
+	files := make(map[string]bool)
+	for t := start.Truncate(truncate); t.Before(end); t = t.Add(truncate) {
+		basename, err := (&Raw{Label: label, Timestamp: t}).Filename(path)
+		if err != nil {
+			return nil, err
+		}
+
+		filename := filepath.Join(base, string(basename))
+		if files[filename] {
+			continue
+		}
+		files[filename] = true
+
+		if _, err := os.Stat(filename); err != nil {
+			continue
+		}
+
+		data, err := readFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		var raw Raw
+		if err := raw.Unmarshal(data); err != nil {
+			return nil, err
+		}
+
+		for _, v := range raw.Readings {
+			if v.Label != label || v.Timestamp.Before(start) || !v.Timestamp.Before(end) {
+				continue
+			}
+			res.Add(v)
+		}
+	}
+
+	return res, nil
+}
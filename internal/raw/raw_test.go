@@ -0,0 +1,282 @@
+package raw
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/mseed"
+)
+
+// readFloat64 decodes the samples of a miniseed file into readings.
+func readFloat64(t *testing.T, name string) (*Raw, byte) {
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var r *Raw
+	var sampletype byte
+
+	reader := mseed.NewReader(file)
+	defer reader.Close()
+
+	for {
+		msr, err := reader.Next()
+		if err != nil {
+			break
+		}
+
+		samples, err := msr.DataSamplesFloat64()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r == nil {
+			sampletype = msr.Sampletype()
+			r = NewRaw(msr.SrcName(0), Precision(-1, sampletype == 'i'))
+		}
+
+		dt := time.Duration(float64(time.Second) / msr.Samprate())
+		for i, s := range samples {
+			r.Add(NewReading(msr.Starttime().Add(time.Duration(i)*dt), r.Label, s))
+		}
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if r == nil {
+		t.Fatalf("no records found in %s", name)
+	}
+
+	return r, sampletype
+}
+
+func TestRaw_FloatRoundTrip(t *testing.T) {
+	for _, v := range []struct {
+		name       string
+		sampletype byte
+		first      string
+	}{
+		{"../mseed/test/data/Float32-encoded.mseed", 'f', "-1.0625"},
+		{"../mseed/test/data/Float64-encoded.mseed", 'd', "-1.0625"},
+		{"../mseed/test/data/Int32-512byte.mseed", 'i', "-242196"},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			r, sampletype := readFloat64(t, v.name)
+			if sampletype != v.sampletype {
+				t.Errorf("expected sample type %c got %c", v.sampletype, sampletype)
+			}
+
+			data, err := r.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var decoded Raw
+			if err := decoded.Unmarshal(data); err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded.Readings) != len(r.Readings) {
+				t.Fatalf("expected %d readings got %d", len(r.Readings), len(decoded.Readings))
+			}
+			for i := range r.Readings {
+				if a, b := r.Readings[i].Field, decoded.Readings[i].Field; a != b {
+					t.Fatalf("reading %d changed value from %v to %v", i, a, b)
+				}
+			}
+
+			fields := strings.Split(strings.SplitN(string(data), "\n", 2)[0], ",")
+			if len(fields) != 3 || fields[2] != v.first {
+				t.Errorf("expected first value %s got %v", v.first, fields)
+			}
+		})
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	for _, v := range []struct {
+		dp      int
+		integer bool
+		expect  int
+	}{
+		{2, false, 2},
+		{2, true, 2},
+		{-1, true, 0},
+		{-1, false, -1},
+	} {
+		if p := Precision(v.dp, v.integer); p != v.expect {
+			t.Errorf("expected precision %d for (%d, %v) got %d", v.expect, v.dp, v.integer, p)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const path = "{{year}}.{{yearday}}.{{hour}}.{{.Label}}.csv"
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	r := NewRaw("NZ_EYWM_51_LFZ", 0)
+	for i := 0; i < 3*3600; i += 10 {
+		r.Add(NewReading(start.Add(time.Duration(i)*time.Second), r.Label, float64(i)))
+	}
+	if err := r.Store(dir, path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(dir, path, time.Hour, r.Label, start.Add(30*time.Minute), start.Add(4*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(loaded.Readings); n != 900 {
+		t.Fatalf("expected 900 readings got %d", n)
+	}
+	if !loaded.At().Equal(start.Add(30 * time.Minute)) {
+		t.Errorf("unexpected first reading time: %s", loaded.At())
+	}
+
+	if loaded, err = Load(dir, path, time.Hour, "NZ_SMHS_51_LFZ", start, start.Add(time.Hour)); err != nil || len(loaded.Readings) != 0 {
+		t.Errorf("expected no readings for a missing channel: %d (%v)", len(loaded.Readings), err)
+	}
+}
+
+func TestRaw_Flags(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	r := NewRaw("NZ_EYWM_51_LFZ", 1)
+	for i := 0; i < 3; i++ {
+		r.Add(NewReading(start.Add(time.Duration(i)*time.Second), r.Label, float64(i)))
+	}
+
+	data, err := r.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); strings.Count(lines[0], ",") != 2 {
+		t.Errorf("unexpected flag column without flags: %s", lines[0])
+	}
+
+	r.Readings[1].Flag = "JS"
+	if data, err = r.Marshal(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); lines[0] != "2020-01-01T00:00:00Z,NZ_EYWM_51_LFZ,0.0," || lines[1] != "2020-01-01T00:00:01Z,NZ_EYWM_51_LFZ,1.0,JS" {
+		t.Errorf("unexpected flag column: %q", lines[:2])
+	}
+
+	// files with and without the flag column can be merged
+	var check Raw
+	if err := check.Unmarshal(append(data, []byte("2020-01-01T00:00:03Z,NZ_EYWM_51_LFZ,3.0\n")...)); err != nil {
+		t.Fatal(err)
+	}
+	if len(check.Readings) != 4 || check.Readings[1].Flag != "JS" || check.Readings[0].Flag != "" {
+		t.Errorf("unexpected decoded flags: %v", check.Readings)
+	}
+}
+
+func TestRaw_TimeFormat(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 1, 968393000, time.UTC)
+
+	tests := map[string]struct {
+		encoded string
+		decoded time.Time
+	}{
+		"":              {"2020-01-01T00:00:01Z", start.Truncate(time.Second)},
+		TimeSecond:      {"2020-01-01T00:00:01Z", start.Truncate(time.Second)},
+		TimeMillisecond: {"2020-01-01T00:00:01.968Z", start.Truncate(time.Millisecond)},
+		TimeMicrosecond: {"2020-01-01T00:00:01.968393Z", start.Truncate(time.Microsecond)},
+	}
+
+	for format, expected := range tests {
+		r := NewRaw("NZ_EYWM_51_LFZ", 0)
+		r.TimeFormat = format
+		r.Add(NewReading(start, r.Label, 1.0))
+
+		data, err := r.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line := strings.TrimSpace(string(data)); line != expected.encoded+",NZ_EYWM_51_LFZ,1" {
+			t.Errorf("%s: unexpected encoded reading: %s", format, line)
+		}
+
+		var check Raw
+		if err := check.Unmarshal(data); err != nil {
+			t.Fatal(err)
+		}
+		if ts := check.Readings[0].Timestamp; !ts.Equal(expected.decoded) {
+			t.Errorf("%s: expected a decoded timestamp of %s got %s", format, expected.decoded, ts)
+		}
+	}
+
+	// old and new style timestamps can be read together and don't collapse when merged
+	var mixed Raw
+	if err := mixed.Unmarshal([]byte("2020-01-01T00:00:01Z,NZ_EYWM_51_LFZ,1\n2020-01-01T00:00:01.500Z,NZ_EYWM_51_LFZ,2\n")); err != nil {
+		t.Fatal(err)
+	}
+	readings, err := (*Merger)(nil).Merge(nil, mixed.Readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 2 || readings[1].Timestamp.Nanosecond() != 500000000 {
+		t.Errorf("unexpected mixed timestamps: %v", readings)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	for _, v := range []struct {
+		path   string
+		expect string
+	}{
+		{"{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.csv", "*/*.*/*.*.*.*.*.csv"},
+		{"{{station}}/{{at \"2006\"}}/{{tolower .Label}}.{{tag}}.csv", "*/*/*.*.csv"},
+	} {
+		glob, err := Glob(v.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if glob != v.expect {
+			t.Errorf("expected glob %q got %q", v.expect, glob)
+		}
+	}
+}
+
+func TestPattern(t *testing.T) {
+	const path = "{{year}}/{{year}}.{{yearday}}/{{year}}.{{yearday}}.{{hour}}{{minute}}.{{second}}.{{toupper .Label}}.csv"
+
+	pattern, err := Pattern(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expect := range map[string]bool{
+		"2019/2019.146/2019.146.0000.00.NZ_EYWM_51_LFF.csv":          true,
+		"2019/2019.146/2019.146.0000.00.NZ_EYWM_51_LFF.min.csv":      false,
+		"2019/2019.146/2019.146.0000.00.NZ_EYWM_51_LFF.res.csv":      false,
+		"2019/2019.146/2019.146.0000.00.NZ_EYWM_51_LFF.baseline.csv": false,
+		"2019/2019.146/2019.146.0000.00.NZ_EYWM_51_LFF.csv.tmp":      false,
+		"2019/2019.146/2019.1460.0000.00.NZ_EYWM_51_LFF.csv":         false,
+	} {
+		if ok := pattern.MatchString(name); ok != expect {
+			t.Errorf("%s: expected match %v got %v", name, expect, ok)
+		}
+	}
+
+	// the at function accepts any layout within a directory
+	pattern, err = Pattern("{{station}}/{{at \"2006.002\"}}/{{tolower .Label}}.{{tag}}.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pattern.MatchString("NZ_EYWM/2019.146/nz_eywm_51_lff.NZ_EYWM_51_LFF.csv") {
+		t.Errorf("unexpected pattern mismatch: %s", pattern)
+	}
+}
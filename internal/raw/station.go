@@ -0,0 +1,359 @@
+package raw
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StationMissing is the marker written for a channel without a reading at a given time.
+const StationMissing = "NaN"
+
+// SplitSrcName separates a channel srcname into the station label and the channel code.
+func SplitSrcName(srcname string) (string, string) {
+	if n := strings.LastIndex(srcname, "_"); n >= 0 {
+		return srcname[:n], srcname[n+1:]
+	}
+	return srcname, ""
+}
+
+// Row holds the readings of all station channels at a single time, a channel without
+// a reading is given a NaN value.
+type Row struct {
+	Timestamp time.Time
+	Values    []float64
+}
+
+// Station holds readings from multiple channels of a single station, readings are
+// stored by their channel code.
+type Station struct {
+	Label     string
+	Precision int
+	Timestamp time.Time
+
+	Readings map[string][]Reading
+
+	// Merger resolves conflicts with stored readings, new readings are preferred if not set.
+	Merger *Merger
+	// TimeFormat sets the precision of stored timestamps, whole seconds if not set.
+	TimeFormat string
+}
+
+// NewStation returns a Station for the given station label.
+func NewStation(label string, precision int) *Station {
+	return &Station{
+		Label:     label,
+		Precision: precision,
+		Readings:  make(map[string][]Reading),
+	}
+}
+
+// GroupStations combines channel based raw data into station based data.
+func GroupStations(raws []*Raw) []*Station {
+	cache := make(map[string]*Station)
+	for _, r := range raws {
+		station, channel := SplitSrcName(r.Label)
+		if _, ok := cache[station]; !ok {
+			cache[station] = NewStation(station, r.Precision)
+		}
+		for _, v := range r.Readings {
+			cache[station].Add(channel, v)
+		}
+	}
+
+	var res []*Station
+	for _, v := range cache {
+		res = append(res, v)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Label < res[j].Label
+	})
+
+	return res
+}
+
+// Add inserts a reading for the given channel.
+func (s *Station) Add(channel string, v Reading) {
+	if t := v.Timestamp; s.Timestamp.IsZero() || s.Timestamp.After(t) {
+		s.Timestamp = t
+	}
+	if s.Readings == nil {
+		s.Readings = make(map[string][]Reading)
+	}
+	s.Readings[channel] = append(s.Readings[channel], v)
+}
+
+func (s *Station) At() time.Time {
+	return s.Timestamp
+}
+
+func (s *Station) Tag() string {
+	return s.Label
+}
+
+// Channels returns the sorted channel codes held by the station.
+func (s *Station) Channels() []string {
+	var channels []string
+	for k := range s.Readings {
+		channels = append(channels, k)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// Rows aligns the readings of the given channels by timestamp, a reading for a
+// channel that is missing at a given time is marked as NaN.
+func (s *Station) Rows(channels []string) []Row {
+	cache := make(map[time.Time][]float64)
+	for i, c := range channels {
+		for _, r := range s.Readings[c] {
+			if _, ok := cache[r.Timestamp]; !ok {
+				cache[r.Timestamp] = make([]float64, len(channels))
+				for j := range channels {
+					cache[r.Timestamp][j] = math.NaN()
+				}
+			}
+			cache[r.Timestamp][i] = r.Field
+		}
+	}
+
+	var rows []Row
+	for k, v := range cache {
+		rows = append(rows, Row{Timestamp: k, Values: v})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Timestamp.Before(rows[j].Timestamp)
+	})
+
+	return rows
+}
+
+func (s *Station) Marshal() ([]byte, error) {
+
+	var buf bytes.Buffer
+	if err := s.Encode(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *Station) Unmarshal(data []byte) error {
+
+	if err := s.Decode(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// merge adds the readings from another station, readings with the same channel and
+// timestamp are resolved using the Merger policy. Decoded readings are labelled by their
+// column rather than their srcname, so they take the label of the new readings they are
+// merged with.
+func (s *Station) merge(station *Station) error {
+	if s.Readings == nil {
+		s.Readings = make(map[string][]Reading)
+	}
+
+	merged := make(map[string][]Reading)
+	for k, v := range station.Readings {
+		existing := v
+		if r := s.Readings[k]; len(r) > 0 {
+			existing = relabel(v, r[0].Label)
+		}
+		readings, err := s.Merger.Merge(existing, s.Readings[k])
+		if err != nil {
+			return err
+		}
+		merged[k] = readings
+	}
+
+	for k, v := range merged {
+		s.Readings[k] = v
+	}
+
+	return nil
+}
+
+// relabel returns a copy of the readings with the given label.
+func relabel(readings []Reading, label string) []Reading {
+	res := make([]Reading, len(readings))
+	for i, r := range readings {
+		r.Label = label
+		res[i] = r
+	}
+	return res
+}
+
+// Merge adds readings from existing encoded data using the Merger policy, ErrConflict is
+// returned if the merge is rejected.
+func (s *Station) Merge(data []byte) error {
+
+	var station Station
+	if err := station.Unmarshal(data); err != nil {
+		return err
+	}
+
+	return s.merge(&station)
+}
+
+// Decode reads station CSV data, the first line is expected to hold the channel
+// codes and missing readings are skipped.
+func (s *Station) Decode(rd io.Reader) error {
+
+	records, err := csv.NewReader(rd).ReadAll()
+	if err != nil {
+		return err
+	}
+	if !(len(records) > 0) {
+		return nil
+	}
+
+	channels := records[0]
+	if len(channels) < 1 || strings.TrimSpace(channels[0]) != "time" {
+		return fmt.Errorf("invalid station header: %s", strings.Join(channels, ","))
+	}
+
+	for _, l := range records[1:] {
+		if len(l) != len(channels) {
+			continue
+		}
+
+		t, err := time.Parse(rawFormat, strings.TrimSpace(l[0]))
+		if err != nil {
+			return err
+		}
+
+		for i, c := range channels[1:] {
+			v, err := strconv.ParseFloat(l[i+1], 64)
+			if err != nil {
+				return err
+			}
+			if math.IsNaN(v) {
+				continue
+			}
+			s.Add(c, NewReading(t, s.Label+"_"+c, v))
+		}
+	}
+
+	return nil
+}
+
+func (s *Station) Encode(wr io.Writer) error {
+
+	channels := s.Channels()
+
+	lines := [][]string{
+		append([]string{"time"}, channels...),
+	}
+	for _, r := range s.Rows(channels) {
+		line := []string{r.Timestamp.Format(timeLayout(s.TimeFormat))}
+		for _, v := range r.Values {
+			switch {
+			case math.IsNaN(v):
+				line = append(line, StationMissing)
+			default:
+				line = append(line, strconv.FormatFloat(v, 'f', s.Precision, 64))
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	w := csv.NewWriter(wr)
+
+	w.WriteAll(lines)
+
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Filename builds a standard hourly file path name.
+func (s *Station) Filename(path string) ([]byte, error) {
+	return filename(path, s.Tag(), s.Label, s.At(), s)
+}
+
+// split divides the readings into truncated time windows.
+func (s *Station) split(truncate time.Duration) map[time.Time]map[string][]Reading {
+	cache := make(map[time.Time]map[string][]Reading)
+	for k, v := range s.Readings {
+		for _, r := range v {
+			t := r.Timestamp.Truncate(truncate)
+			if _, ok := cache[t]; !ok {
+				cache[t] = make(map[string][]Reading)
+			}
+			cache[t][k] = append(cache[t][k], r)
+		}
+	}
+	return cache
+}
+
+func (s *Station) Split(truncate time.Duration) []*Station {
+
+	var res []*Station
+	for k, v := range s.split(truncate) {
+		res = append(res, &Station{
+			Label:      s.Label,
+			Precision:  s.Precision,
+			Timestamp:  k,
+			Readings:   v,
+			Merger:     s.Merger,
+			TimeFormat: s.TimeFormat,
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].At().Before(res[j].At())
+	})
+
+	return res
+}
+
+func (s *Station) Store(base, path string, truncate time.Duration) error {
+	for _, f := range s.Split(truncate) {
+		basename, err := f.Filename(path)
+		if err != nil {
+			return err
+		}
+
+		filename := filepath.Join(base, string(basename))
+
+		if _, err := os.Stat(filename); err == nil {
+			data, err := readFile(filename)
+			if err != nil {
+				return err
+			}
+			switch err := f.Merge(data); {
+			case err == ErrConflict:
+				// leave the stored file alone, the conflicts have been logged
+				continue
+			case err != nil:
+				return err
+			}
+		}
+
+		data, err := f.Marshal()
+		if err != nil {
+			return err
+		}
+
+		if err := writeFile(filename, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
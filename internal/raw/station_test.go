@@ -0,0 +1,81 @@
+package raw
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStation_Encode(t *testing.T) {
+	at := time.Date(2019, time.May, 26, 0, 0, 0, 0, time.UTC)
+
+	lfe, lfn := NewRaw("NZ_EYWM_51_LFE", 1), NewRaw("NZ_EYWM_51_LFN", 1)
+	lfe.Add(NewReading(at, lfe.Label, 10.25))
+	lfe.Add(NewReading(at.Add(time.Second), lfe.Label, 11))
+	lfn.Add(NewReading(at.Add(time.Second), lfn.Label, -3))
+	lfn.Add(NewReading(at.Add(2*time.Second), lfn.Label, -4))
+
+	stations := GroupStations([]*Raw{lfn, lfe})
+	if len(stations) != 1 {
+		t.Fatalf("expected a single station got %d", len(stations))
+	}
+	if l := stations[0].Label; l != "NZ_EYWM_51" {
+		t.Errorf("expected station label NZ_EYWM_51 got %s", l)
+	}
+
+	data, err := stations[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"time,LFE,LFN",
+		"2019-05-26T00:00:00Z,10.2,NaN",
+		"2019-05-26T00:00:01Z,11.0,-3.0",
+		"2019-05-26T00:00:02Z,NaN,-4.0",
+	}, "\n") + "\n"
+	if string(data) != expected {
+		t.Errorf("unexpected station encoding:\n%s\nexpected:\n%s", string(data), expected)
+	}
+
+	station := NewStation("NZ_EYWM_51", 1)
+	if err := station.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := station.Rows([]string{"LFE", "LFN"})
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows got %d", len(rows))
+	}
+	if !math.IsNaN(rows[0].Values[1]) || !math.IsNaN(rows[2].Values[0]) {
+		t.Errorf("expected missing values to be NaN: %v", rows)
+	}
+	if rows[1].Values[0] != 11 || rows[1].Values[1] != -3 {
+		t.Errorf("unexpected row values: %v", rows[1].Values)
+	}
+	if l := station.Readings["LFN"][0].Label; l != "NZ_EYWM_51_LFN" {
+		t.Errorf("expected reading label NZ_EYWM_51_LFN got %s", l)
+	}
+}
+
+func TestStation_Filename(t *testing.T) {
+	r := NewRaw("NZ_EYWM_51_LFE", 0)
+	r.Add(NewReading(time.Date(2019, time.May, 26, 3, 0, 0, 0, time.UTC), r.Label, 1))
+
+	name, err := r.Filename("{{year}}/{{station}}/{{year}}.{{yearday}}.{{hour}}.{{tag}}.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(name); s != "2019/NZ_EYWM_51/2019.146.03.NZ_EYWM_51_LFE.csv" {
+		t.Errorf("unexpected file name: %s", s)
+	}
+
+	name, err = GroupStations([]*Raw{r})[0].Filename("{{year}}/{{station}}/{{year}}.{{yearday}}.{{hour}}.{{tag}}.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(name); s != "2019/NZ_EYWM_51/2019.146.03.NZ_EYWM_51.csv" {
+		t.Errorf("unexpected file name: %s", s)
+	}
+}
@@ -0,0 +1,52 @@
+package raw
+
+import (
+	"fmt"
+	"time"
+)
+
+// Formats lists the supported raw file formats.
+var Formats = []string{"csv", "station", "iaga2002"}
+
+// ValidFormat returns whether the raw file format is supported.
+func ValidFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// StoreFormat stores channel based raw data using the given file format and timestamp precision,
+// station headers are only needed for iaga2002 files which always use milliseconds. Conflicts
+// with stored readings are resolved by the merger, a nil merger prefers the new readings.
+func StoreFormat(format, timeformat string, raws []*Raw, headers map[string]Header, merger *Merger, base, path string, truncate time.Duration) error {
+	switch format {
+	case "station":
+		for _, v := range GroupStations(raws) {
+			v.Merger, v.TimeFormat = merger, timeformat
+			if err := v.Store(base, path, truncate); err != nil {
+				return err
+			}
+		}
+	case "iaga2002":
+		for _, v := range GroupIAGA(raws, headers) {
+			v.Merger = merger
+			if err := v.Store(base, path, truncate); err != nil {
+				return err
+			}
+		}
+	case "csv":
+		for _, v := range raws {
+			v.Merger, v.TimeFormat = merger, timeformat
+			if err := v.Store(base, path, truncate); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown raw file format: %s", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package resample
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Supported resampling methods.
+const (
+	MethodNearest = "nearest"
+	MethodLinear  = "linear"
+	MethodCubic   = "cubic"
+)
+
+// Rule holds the resampling settings of matching channels. Readings are aligned onto a grid of
+// the given Interval, e.g. "1s", using the Method, which defaults to linear. Without an interval
+// the input sample period is used, a longer interval decimates the readings after applying an
+// anti-alias filter.
+//
+// The Tolerance, e.g. "500ms", is the furthest an input sample used can be from an output time,
+// it defaults to half the input sample period when snapping to the nearest sample, and to the
+// sample period when interpolating. Decimated values need at least the Coverage fraction of
+// their filter samples, which defaults to 0.9.
+type Rule struct {
+	// Srcname is a channel srcname glob pattern, e.g. NZ_EYWM_51_LF?
+	Srcname   string  `json:"srcname" yaml:"srcname"`
+	Method    string  `json:"method,omitempty" yaml:"method,omitempty"`
+	Interval  string  `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Tolerance string  `json:"tolerance,omitempty" yaml:"tolerance,omitempty"`
+	Coverage  float64 `json:"coverage,omitempty" yaml:"coverage,omitempty"`
+}
+
+// Match returns whether the rule applies to the channel.
+func (r Rule) Match(srcname string) bool {
+	ok, err := path.Match(r.Srcname, srcname)
+	return err == nil && ok
+}
+
+func (r Rule) method() string {
+	if r.Method == "" {
+		return MethodLinear
+	}
+	return strings.ToLower(r.Method)
+}
+
+// interval returns the output sample interval, the input sample period if not given.
+func (r Rule) interval(period time.Duration) time.Duration {
+	if d, err := time.ParseDuration(r.Interval); err == nil && d > 0 {
+		return d
+	}
+	return period
+}
+
+// tolerance returns the furthest an input sample can be from an output time.
+func (r Rule) tolerance(period time.Duration) time.Duration {
+	if d, err := time.ParseDuration(r.Tolerance); err == nil && d > 0 {
+		return d
+	}
+	if r.method() == MethodNearest {
+		return period / 2
+	}
+	return period
+}
+
+func (r Rule) coverage() float64 {
+	if r.Coverage > 0.0 {
+		return r.Coverage
+	}
+	return DefaultCoverage
+}
+
+// Validate checks the rule settings are complete.
+func (r Rule) Validate() error {
+	if _, err := path.Match(r.Srcname, ""); err != nil || r.Srcname == "" {
+		return fmt.Errorf("invalid resample srcname: %q", r.Srcname)
+	}
+	switch r.method() {
+	case MethodNearest, MethodLinear, MethodCubic:
+	default:
+		return fmt.Errorf("unknown resample method for %s: %s", r.Srcname, r.Method)
+	}
+	for _, s := range []string{r.Interval, r.Tolerance} {
+		if s == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(s); err != nil || !(d > 0) {
+			return fmt.Errorf("invalid resample duration for %s: %s", r.Srcname, s)
+		}
+	}
+	if r.Coverage < 0.0 || r.Coverage > 1.0 {
+		return fmt.Errorf("invalid resample coverage for %s: %g", r.Srcname, r.Coverage)
+	}
+	return nil
+}
+
+// Table holds a list of resampling rules.
+type Table []Rule
+
+// Find returns the first rule that matches the channel.
+func (t Table) Find(srcname string) (Rule, bool) {
+	for _, r := range t {
+		if r.Match(srcname) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// LoadTable reads a table of resampling rules from a YAML or JSON file, the format is chosen using the file extension.
+func LoadTable(name string) (Table, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var table Table
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown resample file format: %s", ext)
+	}
+
+	for _, r := range table {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
@@ -0,0 +1,328 @@
+// Package resample aligns raw readings onto an exact grid of sample times, optionally decimating them.
+package resample
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+const (
+	// DefaultCoverage is the minimum fraction of filter samples needed for a decimated value.
+	DefaultCoverage = 0.9
+	// Lobes is the number of sinc lobes either side of the centre of the anti-alias filter.
+	Lobes = 4
+)
+
+const derivationFormat = "2006-01-02T15:04:05.000000Z"
+
+// Derivation records how a resampled value was found. The Method is that actually used, cubic
+// interpolation falls back to linear near gaps, and Samples is the number of distinct input samples
+// used. Offset is the time of the nearest input sample relative to the output time. Taps is the
+// number of anti-alias filter samples used for a decimated value, and zero otherwise.
+type Derivation struct {
+	Timestamp time.Time
+	Label     string
+	Method    string
+	Samples   int
+	Offset    time.Duration
+	Taps      int
+}
+
+// EncodeDerivations writes derivations as CSV lines of time, label, method, samples, offset in seconds and taps.
+func EncodeDerivations(wr io.Writer, derivations []Derivation) error {
+	w := csv.NewWriter(wr)
+	for _, d := range derivations {
+		w.Write([]string{
+			d.Timestamp.Format(derivationFormat),
+			d.Label,
+			d.Method,
+			strconv.Itoa(d.Samples),
+			strconv.FormatFloat(d.Offset.Seconds(), 'f', -1, 64),
+			strconv.Itoa(d.Taps),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// Lowpass returns normalised Blackman windowed sinc filter coefficients, with a cutoff at the
+// Nyquist frequency of the decimated samples, for reducing the sample rate by the given ratio.
+func Lowpass(ratio int) []float64 {
+	if ratio < 2 {
+		return []float64{1.0}
+	}
+
+	half := Lobes * ratio
+	weights := make([]float64, 2*half+1)
+
+	var sum float64
+	for i := range weights {
+		n := float64(i - half)
+
+		sinc := 1.0
+		if n != 0.0 {
+			x := math.Pi * n / float64(ratio)
+			sinc = math.Sin(x) / x
+		}
+
+		w := 2.0 * math.Pi * float64(i) / float64(len(weights)-1)
+		window := 0.42 - 0.5*math.Cos(w) + 0.08*math.Cos(2.0*w)
+
+		weights[i] = sinc * window
+		sum += weights[i]
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+
+	return weights
+}
+
+// rank orders the methods by precision, used to report the weakest method behind a decimated value.
+func rank(method string) int {
+	switch method {
+	case MethodCubic:
+		return 2
+	case MethodLinear:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Channel resamples the readings of a single channel, readings may be added incrementally and
+// output values are produced once the readings needed for them have been passed. Flagged or
+// missing readings are ignored.
+type Channel struct {
+	Label     string
+	Method    string
+	Period    time.Duration
+	Interval  time.Duration
+	Tolerance time.Duration
+	Coverage  float64
+	Weights   []float64
+
+	samples []raw.Reading
+	next    time.Time
+	latest  time.Time
+}
+
+// NewChannel returns a Channel that resamples readings of the given sample period using the rule.
+func NewChannel(label string, period time.Duration, rule Rule) *Channel {
+	c := Channel{
+		Label:     label,
+		Method:    rule.method(),
+		Period:    period,
+		Interval:  rule.interval(period),
+		Tolerance: rule.tolerance(period),
+		Coverage:  rule.coverage(),
+	}
+	if ratio := int(c.Interval / period); ratio > 1 {
+		c.Weights = Lowpass(ratio)
+	}
+	return &c
+}
+
+// reach returns how far either side of an output time input samples may be used.
+func (c *Channel) reach() time.Duration {
+	return time.Duration(len(c.Weights)/2)*c.Period + c.Tolerance + 2*c.Period
+}
+
+// Add includes a reading, readings too old to affect any remaining output values are ignored.
+func (c *Channel) Add(r raw.Reading) {
+	if math.IsNaN(r.Field) || r.Flag != "" {
+		return
+	}
+	if !c.next.IsZero() && r.Timestamp.Before(c.next.Add(-c.reach())) {
+		return
+	}
+
+	if c.next.IsZero() {
+		c.next = r.Timestamp.Truncate(c.Interval)
+		if c.next.Before(r.Timestamp) {
+			c.next = c.next.Add(c.Interval)
+		}
+	}
+	if r.Timestamp.After(c.latest) {
+		c.latest = r.Timestamp
+	}
+
+	n := len(c.samples)
+	switch i := sort.Search(n, func(i int) bool { return !c.samples[i].Timestamp.Before(r.Timestamp) }); {
+	case i == n:
+		c.samples = append(c.samples, r)
+	case c.samples[i].Timestamp.Equal(r.Timestamp):
+		c.samples[i] = r
+	default:
+		c.samples = append(c.samples, raw.Reading{})
+		copy(c.samples[i+1:], c.samples[i:])
+		c.samples[i] = r
+	}
+}
+
+// search returns the index of the first sample not before the given time.
+func (c *Channel) search(at time.Time) int {
+	return sort.Search(len(c.samples), func(i int) bool {
+		return !c.samples[i].Timestamp.Before(at)
+	})
+}
+
+// near returns whether two times are within the tolerance.
+func (c *Channel) near(a, b time.Time) bool {
+	d := a.Sub(b)
+	if d < 0 {
+		d = -d
+	}
+	return !(d > c.Tolerance)
+}
+
+// interpolate returns the value at a time along with the method and input samples used.
+func (c *Channel) interpolate(at time.Time) (float64, string, []time.Time, bool) {
+	s, i := c.samples, c.search(at)
+
+	if c.Method == MethodNearest {
+		best := -1
+		for _, j := range []int{i - 1, i} {
+			if j < 0 || j >= len(s) || !c.near(s[j].Timestamp, at) {
+				continue
+			}
+			if best < 0 || math.Abs(float64(s[j].Timestamp.Sub(at))) < math.Abs(float64(s[best].Timestamp.Sub(at))) {
+				best = j
+			}
+		}
+		if best < 0 {
+			return 0.0, "", nil, false
+		}
+		return s[best].Field, MethodNearest, []time.Time{s[best].Timestamp}, true
+	}
+
+	if i < len(s) && s[i].Timestamp.Equal(at) {
+		return s[i].Field, c.Method, []time.Time{at}, true
+	}
+	if i < 1 || i >= len(s) || !c.near(s[i-1].Timestamp, at) || !c.near(s[i].Timestamp, at) {
+		return 0.0, "", nil, false
+	}
+
+	if c.Method == MethodCubic && i > 1 && i+1 < len(s) &&
+		c.near(s[i-2].Timestamp, s[i-1].Timestamp) && c.near(s[i+1].Timestamp, s[i].Timestamp) {
+
+		// lagrange polynomial through the four surrounding samples
+		var v float64
+		var used []time.Time
+		for j := i - 2; j <= i+1; j++ {
+			w := 1.0
+			for k := i - 2; k <= i+1; k++ {
+				if k == j {
+					continue
+				}
+				w *= at.Sub(s[k].Timestamp).Seconds() / s[j].Timestamp.Sub(s[k].Timestamp).Seconds()
+			}
+			v += w * s[j].Field
+			used = append(used, s[j].Timestamp)
+		}
+		return v, MethodCubic, used, true
+	}
+
+	a, b := s[i-1], s[i]
+	f := at.Sub(a.Timestamp).Seconds() / b.Timestamp.Sub(a.Timestamp).Seconds()
+
+	return a.Field + f*(b.Field-a.Field), MethodLinear, []time.Time{a.Timestamp, b.Timestamp}, true
+}
+
+// value returns the resampled value at an output time and how it was found.
+func (c *Channel) value(at time.Time) (float64, Derivation, bool) {
+	d := Derivation{
+		Timestamp: at,
+		Label:     c.Label,
+	}
+
+	if i := c.search(at); len(c.samples) > 0 {
+		switch {
+		case i == 0:
+			d.Offset = c.samples[0].Timestamp.Sub(at)
+		case i == len(c.samples) || at.Sub(c.samples[i-1].Timestamp) < c.samples[i].Timestamp.Sub(at):
+			d.Offset = c.samples[i-1].Timestamp.Sub(at)
+		default:
+			d.Offset = c.samples[i].Timestamp.Sub(at)
+		}
+	}
+
+	if c.Weights == nil {
+		v, method, used, ok := c.interpolate(at)
+		if !ok {
+			return 0.0, d, false
+		}
+		d.Method, d.Samples = method, len(used)
+		return v, d, true
+	}
+
+	half := len(c.Weights) / 2
+
+	used := make(map[time.Time]bool)
+	var sum, weight float64
+	for n, w := range c.Weights {
+		v, method, samples, ok := c.interpolate(at.Add(time.Duration(n-half) * c.Period))
+		if !ok {
+			continue
+		}
+		if d.Method == "" || rank(method) < rank(d.Method) {
+			d.Method = method
+		}
+		for _, t := range samples {
+			used[t] = true
+		}
+		sum += w * v
+		weight += w
+		d.Taps++
+	}
+
+	if float64(d.Taps) < c.Coverage*float64(len(c.Weights)) || !(weight > 0.0) {
+		return 0.0, d, false
+	}
+	d.Samples = len(used)
+
+	return sum / weight, d, true
+}
+
+// Flush returns the resampled values that no longer depend on later readings, if final is set
+// then values are returned using whatever readings are available.
+func (c *Channel) Flush(final bool) ([]raw.Reading, []Derivation) {
+	var readings []raw.Reading
+	var derivations []Derivation
+
+	for !c.next.IsZero() && len(c.samples) > 0 {
+		if final && c.next.After(c.latest) {
+			break
+		}
+		if !final && c.next.Add(c.reach()).After(c.latest) {
+			break
+		}
+
+		// jump over any gap rather than stepping through it
+		if i := c.search(c.next.Add(-c.reach())); i < len(c.samples) {
+			if t := c.samples[i].Timestamp.Add(-c.reach()).Truncate(c.Interval); t.After(c.next) {
+				c.next = t
+				continue
+			}
+		}
+
+		if v, d, ok := c.value(c.next); ok {
+			readings = append(readings, raw.NewReading(c.next, c.Label, v))
+			derivations = append(derivations, d)
+		}
+		c.next = c.next.Add(c.Interval)
+	}
+
+	start := c.next.Add(-c.reach())
+	n := c.search(start)
+	c.samples = append([]raw.Reading{}, c.samples[n:]...)
+
+	return readings, derivations
+}
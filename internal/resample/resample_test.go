@@ -0,0 +1,171 @@
+package resample
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// drifted returns one-second readings that start off the whole second, as seen from digitizers.
+func drifted(n int, value func(float64) float64) *raw.Raw {
+	start := time.Date(2016, time.March, 19, 0, 0, 0, 968393000, time.UTC)
+
+	r := raw.NewRaw("NZ_EYWM_51_LFZ", 3)
+	for i := 0; i < n; i++ {
+		t := start.Add(time.Duration(i) * time.Second)
+		r.Add(raw.NewReading(t, r.Label, value(float64(t.UnixNano())/1e9-float64(start.Truncate(time.Hour).Unix()))))
+	}
+	return r
+}
+
+func TestResample(t *testing.T) {
+	cubic := func(x float64) float64 { return 0.001*x*x*x - 0.02*x*x + 0.5*x + 10.0 }
+
+	tests := map[string]struct {
+		value  func(float64) float64
+		method string
+	}{
+		MethodNearest: {func(x float64) float64 { return x }, MethodNearest},
+		MethodLinear:  {func(x float64) float64 { return 2.0*x + 1.0 }, MethodLinear},
+		MethodCubic:   {cubic, MethodCubic},
+	}
+
+	for method, test := range tests {
+		r := drifted(60, test.value)
+
+		res, derivations := Resample(r, time.Second, Rule{Method: method}, 6)
+		if len(res.Readings) != len(derivations) {
+			t.Fatalf("%s: expected a derivation for each reading: %d %d", method, len(res.Readings), len(derivations))
+		}
+		if !(len(res.Readings) > 50) {
+			t.Fatalf("%s: expected most readings to be resampled got %d", method, len(res.Readings))
+		}
+
+		for i, v := range res.Readings {
+			if v.Timestamp.Nanosecond() != 0 {
+				t.Errorf("%s: expected whole second timestamps got %s", method, v.Timestamp)
+			}
+
+			// cubic interpolation falls back to linear at either end
+			d := derivations[i]
+			switch {
+			case d.Method == test.method:
+			case method == MethodCubic && d.Method == MethodLinear && (i == 0 || i == len(derivations)-1):
+				continue
+			default:
+				t.Errorf("%s: unexpected derivation method at %s: %s", method, v.Timestamp, d.Method)
+			}
+
+			x := float64(v.Timestamp.Sub(v.Timestamp.Truncate(time.Hour))) / float64(time.Second)
+			switch method {
+			case MethodNearest:
+				if math.Abs(v.Field-(x-0.031607)) > 1e-5 {
+					t.Errorf("%s: expected the nearest sample at %s got %g", method, v.Timestamp, v.Field)
+				}
+			default:
+				if math.Abs(v.Field-test.value(x)) > 1e-5 {
+					t.Errorf("%s: expected %g at %s got %g", method, test.value(x), v.Timestamp, v.Field)
+				}
+			}
+
+			if d.Offset != -31607*time.Microsecond {
+				t.Errorf("%s: unexpected derivation offset: %s", method, d.Offset)
+			}
+		}
+	}
+}
+
+func TestResample_Gap(t *testing.T) {
+	r := drifted(60, func(x float64) float64 { return x })
+	r.Readings = append(r.Readings[:20], r.Readings[30:]...)
+
+	res, _ := Resample(r, time.Second, Rule{Method: MethodLinear}, 3)
+	for _, v := range res.Readings {
+		if v.Timestamp.After(r.Readings[19].Timestamp) && v.Timestamp.Before(r.Readings[20].Timestamp) {
+			t.Errorf("unexpected reading within the gap: %s", v.Timestamp)
+		}
+	}
+	if n := len(res.Readings); n != 48 {
+		t.Errorf("expected 48 resampled readings got %d", n)
+	}
+}
+
+func TestResample_Decimate(t *testing.T) {
+	// a slow trend with a fast oscillation above the decimated nyquist frequency
+	r := drifted(3600, func(x float64) float64 {
+		return 100.0 + 0.01*x + 5.0*math.Sin(2.0*math.Pi*x/4.0)
+	})
+
+	res, derivations := Resample(r, time.Second, Rule{Interval: "1m"}, 3)
+	if n := len(res.Readings); n < 50 || n > 60 {
+		t.Fatalf("expected about 55 decimated readings got %d", n)
+	}
+
+	for i, v := range res.Readings {
+		if v.Timestamp.Second() != 0 || v.Timestamp.Nanosecond() != 0 {
+			t.Errorf("expected whole minute timestamps got %s", v.Timestamp)
+		}
+		x := float64(v.Timestamp.Sub(v.Timestamp.Truncate(time.Hour))) / float64(time.Second)
+		if math.Abs(v.Field-(100.0+0.01*x)) > 0.05 {
+			t.Errorf("expected the oscillation to be removed at %s got %g", v.Timestamp, v.Field)
+		}
+		if d := derivations[i]; float64(d.Taps) < DefaultCoverage*float64(len(Lowpass(60))) || d.Samples < d.Taps {
+			t.Errorf("unexpected decimation derivation: %+v", d)
+		}
+	}
+}
+
+func TestResampler(t *testing.T) {
+	r := drifted(600, func(x float64) float64 { return math.Sin(x / 30.0) })
+
+	table := Table{{Srcname: "NZ_EYWM_51_LF?", Method: MethodCubic}}
+	if err := table[0].Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := Resample(r, time.Second, table[0], 6)
+
+	// readings added in blocks give the same results as all at once
+	resampler := NewResampler(table, 6)
+
+	var readings []raw.Reading
+	for i := 0; i < len(r.Readings); i += 64 {
+		block := raw.NewRaw(r.Label, r.Precision)
+		for j := i; j < i+64 && j < len(r.Readings); j++ {
+			block.Add(r.Readings[j])
+		}
+		resampler.Add(block, time.Second)
+
+		raws, _ := resampler.Flush(false)
+		for _, v := range raws {
+			readings = append(readings, v.Readings...)
+		}
+	}
+	raws, _ := resampler.Flush(true)
+	for _, v := range raws {
+		readings = append(readings, v.Readings...)
+	}
+
+	if len(readings) != len(expected.Readings) {
+		t.Fatalf("expected %d resampled readings got %d", len(expected.Readings), len(readings))
+	}
+	for i, v := range readings {
+		if e := expected.Readings[i]; !v.Timestamp.Equal(e.Timestamp) || math.Abs(v.Field-e.Field) > 1e-9 {
+			t.Errorf("unexpected resampled reading %s %g, expected %s %g", v.Timestamp, v.Field, e.Timestamp, e.Field)
+		}
+	}
+
+	// unmatched channels are left alone
+	resampler.Add(raw.NewRaw("NZ_SMHS_51_LFZ", 0), time.Second)
+	if _, ok := resampler.channels["NZ_SMHS_51_LFZ"]; ok {
+		t.Error("expected an unmatched channel to be skipped")
+	}
+
+	for _, rule := range []Rule{{}, {Srcname: "*", Method: "spline"}, {Srcname: "*", Interval: "fast"}, {Srcname: "*", Coverage: 2.0}} {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("expected an invalid rule to fail: %+v", rule)
+		}
+	}
+}
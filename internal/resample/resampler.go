@@ -0,0 +1,103 @@
+package resample
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// Resampler resamples the streamed readings of any channels matching the configured rules.
+type Resampler struct {
+	Table     Table
+	Precision int
+
+	channels map[string]*Channel
+}
+
+// NewResampler returns a Resampler for the given rules, resampled readings use the given precision.
+func NewResampler(table Table, precision int) *Resampler {
+	return &Resampler{
+		Table:     table,
+		Precision: precision,
+		channels:  make(map[string]*Channel),
+	}
+}
+
+// Enabled returns whether any channels are to be resampled.
+func (r *Resampler) Enabled() bool {
+	return len(r.Table) > 0
+}
+
+// Add includes the readings of a raw data set with the given sample period, channels without a
+// matching rule are skipped.
+func (r *Resampler) Add(v *raw.Raw, period time.Duration) {
+	if !(period > 0) {
+		return
+	}
+
+	c, ok := r.channels[v.Label]
+	if !ok {
+		rule, ok := r.Table.Find(v.Label)
+		if !ok {
+			return
+		}
+		c = NewChannel(v.Label, period, rule)
+		r.channels[v.Label] = c
+	}
+
+	for _, reading := range v.Readings {
+		c.Add(reading)
+	}
+}
+
+// Flush returns the resampled readings of each channel and how they were derived, if final is set
+// then any remaining values are also returned.
+func (r *Resampler) Flush(final bool) ([]*raw.Raw, []Derivation) {
+	var labels []string
+	for k := range r.channels {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+
+	var raws []*raw.Raw
+	var derivations []Derivation
+	for _, l := range labels {
+		readings, list := r.channels[l].Flush(final)
+		if !(len(readings) > 0) {
+			continue
+		}
+
+		v := raw.NewRaw(l, r.Precision)
+		for _, reading := range readings {
+			v.Add(reading)
+		}
+
+		raws = append(raws, v)
+		derivations = append(derivations, list...)
+	}
+
+	return raws, derivations
+}
+
+// Resample returns the resampled readings of a complete raw data set with the given sample period.
+func Resample(v *raw.Raw, period time.Duration, rule Rule, precision int) (*raw.Raw, []Derivation) {
+	readings := append([]raw.Reading{}, v.Readings...)
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Less(readings[j])
+	})
+
+	c := NewChannel(v.Label, period, rule)
+	for _, reading := range readings {
+		c.Add(reading)
+	}
+
+	res := raw.NewRaw(v.Label, precision)
+
+	values, derivations := c.Flush(true)
+	for _, reading := range values {
+		res.Add(reading)
+	}
+
+	return res, derivations
+}
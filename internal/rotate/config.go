@@ -0,0 +1,163 @@
+package rotate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Supported frames, sensor is only used for input readings.
+const (
+	FrameSensor = "sensor"
+	FrameXYZ    = "xyz"
+	FrameHDZ    = "hdz"
+	FrameDIF    = "dif"
+)
+
+// Output channel codes for the vector field intensity and delta-F, which is the vector
+// field intensity less the scalar field intensity.
+const (
+	CodeF      = "F"
+	CodeDeltaF = "G"
+)
+
+// Station holds the rotation settings of a single station. The three vector Channels are given
+// by channel code in the order of the Input frame, for the sensor frame the first axis points
+// at the Azimuth, in degrees east of geographic north, the second to the east of it and the
+// third down; HDZ and DIF input expect declinations and inclinations in minutes of arc. The
+// Baseline values are added to the input channels before any conversion. An optional Scalar
+// channel allows delta-F to be found.
+//
+// The converted readings are labelled using the station label and the output Codes, which
+// default to X, Y and Z (or H, D and Z, or D, I and F). The vector field intensity is also given
+// as F, unless already part of the output, and delta-F as G.
+type Station struct {
+	Station  string    `json:"station" yaml:"station"`
+	Input    string    `json:"input,omitempty" yaml:"input,omitempty"`
+	Output   string    `json:"output,omitempty" yaml:"output,omitempty"`
+	Channels []string  `json:"channels" yaml:"channels"`
+	Scalar   string    `json:"scalar,omitempty" yaml:"scalar,omitempty"`
+	Azimuth  float64   `json:"azimuth,omitempty" yaml:"azimuth,omitempty"`
+	Baseline []float64 `json:"baseline,omitempty" yaml:"baseline,omitempty"`
+	Codes    []string  `json:"codes,omitempty" yaml:"codes,omitempty"`
+}
+
+func (s Station) input() string {
+	if s.Input == "" {
+		return FrameSensor
+	}
+	return strings.ToLower(s.Input)
+}
+
+func (s Station) output() string {
+	if s.Output == "" {
+		return FrameXYZ
+	}
+	return strings.ToLower(s.Output)
+}
+
+// codes returns the output channel codes of the converted components.
+func (s Station) codes() []string {
+	if len(s.Codes) == 3 {
+		return s.Codes
+	}
+	switch s.output() {
+	case FrameHDZ:
+		return []string{"H", "D", "Z"}
+	case FrameDIF:
+		return []string{"D", "I", "F"}
+	default:
+		return []string{"X", "Y", "Z"}
+	}
+}
+
+// Vector returns the geographic vector of the readings of the three input channels.
+func (s Station) Vector(values [3]float64) Vector {
+	v := values
+	for i := range s.Baseline {
+		if i < len(v) {
+			v[i] += s.Baseline[i]
+		}
+	}
+
+	switch s.input() {
+	case FrameXYZ:
+		return Vector{X: v[0], Y: v[1], Z: v[2]}
+	case FrameHDZ:
+		return FromHDZ(v[0], v[1], v[2])
+	case FrameDIF:
+		return FromDIF(v[0], v[1], v[2])
+	default:
+		return FromSensor(v[0], v[1], v[2], s.Azimuth)
+	}
+}
+
+// Validate checks the station settings are complete.
+func (s Station) Validate() error {
+	switch s.input() {
+	case FrameSensor, FrameXYZ, FrameHDZ, FrameDIF:
+	default:
+		return fmt.Errorf("unknown input frame for station %s: %s", s.Station, s.Input)
+	}
+	switch s.output() {
+	case FrameXYZ, FrameHDZ, FrameDIF:
+	default:
+		return fmt.Errorf("unknown output frame for station %s: %s", s.Station, s.Output)
+	}
+	switch {
+	case len(s.Channels) != 3:
+		return fmt.Errorf("expected three vector channels for station %s", s.Station)
+	case len(s.Codes) != 0 && len(s.Codes) != 3:
+		return fmt.Errorf("expected three output codes for station %s", s.Station)
+	case len(s.Baseline) != 0 && len(s.Baseline) != 3:
+		return fmt.Errorf("expected three baseline values for station %s", s.Station)
+	}
+	return nil
+}
+
+// Table holds the rotation settings of a list of stations.
+type Table []Station
+
+// Find returns the settings of the given station.
+func (t Table) Find(station string) (Station, bool) {
+	for _, s := range t {
+		if s.Station == station {
+			return s, true
+		}
+	}
+	return Station{}, false
+}
+
+// LoadTable reads a table of station settings from a YAML or JSON file, the format is chosen using the file extension.
+func LoadTable(name string) (Table, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var table Table
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown rotation file format: %s", ext)
+	}
+
+	for _, s := range table {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
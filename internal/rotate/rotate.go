@@ -0,0 +1,74 @@
+// Package rotate converts aligned multi-channel readings between sensor, XYZ, HDZ and DIF frames.
+package rotate
+
+import (
+	"math"
+)
+
+// minutes converts between radians and minutes of arc, angles are given in minutes of arc as used in IAGA-2002 files.
+const minutes = 180.0 * 60.0 / math.Pi
+
+// Vector is a field vector in geographic components, X north, Y east and Z down, in nT.
+type Vector struct {
+	X float64
+	Y float64
+	Z float64
+}
+
+// FromSensor returns the geographic vector for readings from a sensor whose first axis points at the
+// given azimuth, in degrees east of geographic north, with the second axis at right angles to the east
+// and the third vertically down.
+func FromSensor(u, v, w, azimuth float64) Vector {
+	a := azimuth * math.Pi / 180.0
+	return Vector{
+		X: u*math.Cos(a) - v*math.Sin(a),
+		Y: u*math.Sin(a) + v*math.Cos(a),
+		Z: w,
+	}
+}
+
+// FromHDZ returns the geographic vector for horizontal intensity, declination in minutes of arc, and vertical components.
+func FromHDZ(h, d, z float64) Vector {
+	return Vector{
+		X: h * math.Cos(d/minutes),
+		Y: h * math.Sin(d/minutes),
+		Z: z,
+	}
+}
+
+// FromDIF returns the geographic vector for declination and inclination, in minutes of arc, and total field intensity.
+func FromDIF(d, i, f float64) Vector {
+	return FromHDZ(f*math.Cos(i/minutes), d, f*math.Sin(i/minutes))
+}
+
+// H returns the horizontal intensity.
+func (v Vector) H() float64 {
+	return math.Hypot(v.X, v.Y)
+}
+
+// D returns the declination in minutes of arc.
+func (v Vector) D() float64 {
+	return math.Atan2(v.Y, v.X) * minutes
+}
+
+// I returns the inclination in minutes of arc.
+func (v Vector) I() float64 {
+	return math.Atan2(v.Z, v.H()) * minutes
+}
+
+// F returns the total field intensity of the vector.
+func (v Vector) F() float64 {
+	return math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+}
+
+// Components returns the vector in the given output frame, either xyz, hdz or dif.
+func (v Vector) Components(frame string) [3]float64 {
+	switch frame {
+	case FrameHDZ:
+		return [3]float64{v.H(), v.D(), v.Z}
+	case FrameDIF:
+		return [3]float64{v.D(), v.I(), v.F()}
+	default:
+		return [3]float64{v.X, v.Y, v.Z}
+	}
+}
@@ -0,0 +1,210 @@
+package rotate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+func TestVector(t *testing.T) {
+	v := FromSensor(20000.0, 0.0, 50000.0, 90.0)
+	if math.Abs(v.X) > 1e-9 || math.Abs(v.Y-20000.0) > 1e-9 || v.Z != 50000.0 {
+		t.Errorf("unexpected rotated sensor vector: %+v", v)
+	}
+
+	v = FromHDZ(20000.0, 23.0*60.0, 50000.0)
+	if math.Abs(v.H()-20000.0) > 1e-9 || math.Abs(v.D()-23.0*60.0) > 1e-9 {
+		t.Errorf("unexpected hdz round trip: %g %g", v.H(), v.D())
+	}
+
+	v = Vector{X: 3.0, Y: 4.0, Z: 5.0 * math.Sqrt(3.0)}
+	dif := v.Components(FrameDIF)
+	if math.Abs(dif[1]-60.0*60.0) > 1e-9 || math.Abs(dif[2]-10.0) > 1e-9 {
+		t.Errorf("unexpected dif components: %v", dif)
+	}
+}
+
+func TestRotator(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	table := Table{{
+		Station:  "NZ_EYWM_51",
+		Channels: []string{"LFU", "LFV", "LFW"},
+		Scalar:   "LFF",
+		Azimuth:  23.0,
+		Baseline: []float64{20000.0, 0.0, -50000.0},
+	}}
+	if err := table[0].Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	packet := func(channel string, v float64) *raw.Raw {
+		r := raw.NewRaw("NZ_EYWM_51_"+channel, 0)
+		for i := 0; i < 10; i++ {
+			r.Add(raw.NewReading(start.Add(time.Duration(i)*time.Second), r.Label, v))
+		}
+		return r
+	}
+
+	rotator := NewRotator(table, 2)
+	for _, c := range []string{"LFU", "LFV", "LFW"} {
+		if res := rotator.Add(packet(c, 0.0)); len(res) != 0 {
+			t.Fatalf("unexpected output before the scalar readings: %v", res)
+		}
+	}
+
+	res := rotator.Add(packet("LFF", 53000.0))
+	if len(res) != 5 {
+		t.Fatalf("expected five output channels got %d", len(res))
+	}
+
+	values := make(map[string]float64)
+	for _, r := range res {
+		if n := len(r.Readings); n != 10 {
+			t.Errorf("%s: expected 10 readings got %d", r.Label, n)
+		}
+		values[r.Label] = r.Readings[0].Field
+	}
+
+	// the sensor's first axis points along the declination
+	d := 23.0 * math.Pi / 180.0
+	f := math.Hypot(20000.0, 50000.0)
+	for label, v := range map[string]float64{
+		"NZ_EYWM_51_X": 20000.0 * math.Cos(d),
+		"NZ_EYWM_51_Y": 20000.0 * math.Sin(d),
+		"NZ_EYWM_51_Z": -50000.0,
+		"NZ_EYWM_51_F": f,
+		"NZ_EYWM_51_G": f - 53000.0,
+	} {
+		if math.Abs(values[label]-v) > 1e-6 {
+			t.Errorf("%s: expected %g got %g", label, v, values[label])
+		}
+	}
+
+	// incomplete samples are only converted once flushed
+	if res := rotator.Add(packet("LFU", 1.0), packet("LFV", 1.0)); len(res) != 0 {
+		t.Errorf("unexpected output from incomplete samples: %v", res)
+	}
+	if res := rotator.Flush(); len(res) != 0 {
+		t.Errorf("unexpected output from samples without all vector channels: %v", res)
+	}
+
+	table[0].Output = FrameHDZ
+	hdz := Rotate(table, 2, []*raw.Raw{packet("LFU", 0.0), packet("LFV", 0.0), packet("LFW", 0.0)})
+	if len(hdz) != 4 || hdz[0].Label != "NZ_EYWM_51_D" || math.Abs(hdz[0].Readings[0].Field-23.0*60.0) > 1e-6 {
+		t.Errorf("unexpected hdz output: %v", hdz)
+	}
+}
+
+func TestRotator_Jitter(t *testing.T) {
+	start := time.Date(2016, time.March, 19, 0, 0, 0, 968393000, time.UTC)
+
+	table := Table{{
+		Station:  "NZ_EYWM_51",
+		Input:    FrameXYZ,
+		Channels: []string{"LFX", "LFY", "LFZ"},
+		Scalar:   "LFF",
+	}}
+
+	// each channel is offset and jittered by a few milliseconds from the others
+	jitter := []time.Duration{0, 3 * time.Millisecond, -5 * time.Millisecond, 11 * time.Millisecond}
+
+	var raws []*raw.Raw
+	for i, c := range []string{"LFX", "LFY", "LFZ", "LFF"} {
+		r := raw.NewRaw("NZ_EYWM_51_"+c, 0)
+		for n := 0; n < 60; n++ {
+			r.Add(raw.NewReading(start.Add(time.Duration(n)*time.Second+time.Duration(i)*20*time.Millisecond+jitter[(n+i)%len(jitter)]), r.Label, 1.0))
+		}
+		raws = append(raws, r)
+	}
+
+	rotator := NewRotator(table, 2)
+
+	var res []*raw.Raw
+	for _, r := range raws {
+		res = append(res, rotator.Add(r)...)
+	}
+	if len(res) != 5 {
+		t.Fatalf("expected five output channels got %d", len(res))
+	}
+	for _, r := range res {
+		if n := len(r.Readings); n != 60 {
+			t.Errorf("%s: expected 60 aligned readings got %d", r.Label, n)
+		}
+		if !r.Readings[0].Timestamp.Equal(start) {
+			t.Errorf("%s: expected the times of the first channel got %s", r.Label, r.Readings[0].Timestamp)
+		}
+	}
+	if res := rotator.Flush(); len(res) != 0 {
+		t.Errorf("unexpected samples left after alignment: %v", res)
+	}
+}
+
+func TestRotator_NoDelay(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	table := Table{{
+		Station:  "NZ_EYWM_51",
+		Input:    FrameXYZ,
+		Channels: []string{"LFX", "LFY", "LFZ"},
+	}}
+
+	// each channel spans longer than the default delay and arrives in turn, as from separate files
+	rotator := NewRotator(table, 2)
+	rotator.MaxDelay = 0
+
+	var res []*raw.Raw
+	for _, c := range []string{"LFX", "LFY", "LFZ"} {
+		for h := 0; h < 3; h++ {
+			r := raw.NewRaw("NZ_EYWM_51_"+c, 0)
+			for i := 0; i < 60; i++ {
+				r.Add(raw.NewReading(start.Add(time.Duration(h)*time.Hour+time.Duration(i)*time.Minute), r.Label, 1.0))
+			}
+			res = append(res, rotator.Add(r)...)
+		}
+	}
+	res = append(res, rotator.Flush()...)
+
+	counts := make(map[string]int)
+	for _, r := range res {
+		counts[r.Label] += len(r.Readings)
+	}
+	for _, c := range []string{"X", "Y", "Z", "F"} {
+		if n := counts["NZ_EYWM_51_"+c]; n != 180 {
+			t.Errorf("%s: expected 180 converted readings got %d", c, n)
+		}
+	}
+}
+
+func TestFromDIF(t *testing.T) {
+	v := FromDIF(23.0*60.0, -65.0*60.0, 55000.0)
+	if dif := v.Components(FrameDIF); math.Abs(dif[0]-23.0*60.0) > 1e-9 || math.Abs(dif[1]+65.0*60.0) > 1e-9 || math.Abs(dif[2]-55000.0) > 1e-9 {
+		t.Errorf("unexpected dif round trip: %v", dif)
+	}
+}
+
+func TestStation_DIF(t *testing.T) {
+	v := Vector{X: 18000.0, Y: 7500.0, Z: -52000.0}
+	dif := v.Components(FrameDIF)
+
+	for _, output := range []string{FrameXYZ, FrameHDZ} {
+		station := Station{
+			Station:  "NZ_EYWM_51",
+			Input:    FrameDIF,
+			Output:   output,
+			Channels: []string{"LFD", "LFI", "LFF"},
+		}
+		if err := station.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		got := station.Vector(dif).Components(output)
+		for i, c := range v.Components(output) {
+			if math.Abs(got[i]-c) > 1e-6 {
+				t.Errorf("%s: expected component %d to be %g got %g", output, i, c, got[i])
+			}
+		}
+	}
+}
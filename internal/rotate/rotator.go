@@ -0,0 +1,269 @@
+package rotate
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ozym/geomag/internal/raw"
+)
+
+// DefaultMaxDelay is how long to wait for the readings of any missing channels, behind the latest
+// reading of a station, before a sample is converted without them or dropped. A single record of
+// low rate data can span many minutes, so this needs to be longer than a record.
+const DefaultMaxDelay = time.Hour
+
+// sample holds the aligned readings of a station at a single time.
+type sample struct {
+	at        time.Time
+	values    [3]float64
+	have      [3]bool
+	scalar    float64
+	hasScalar bool
+}
+
+// vector returns whether all vector channels have readings.
+func (s *sample) vector() bool {
+	return s.have[0] && s.have[1] && s.have[2]
+}
+
+// pending holds the samples of a station waiting for readings from other channels, in time order.
+// Sample times are not expected to match exactly across channels, readings within the tolerance
+// of a sample are aligned with it.
+type pending struct {
+	station   Station
+	samples   []*sample
+	tolerance time.Duration
+	latest    time.Time
+}
+
+// Rotator aligns the streamed readings of the configured stations by time, to within half the
+// sample period, and converts them into the output frame of each station. Flagged or missing
+// readings are ignored. A zero MaxDelay holds any incomplete samples until they are flushed.
+type Rotator struct {
+	Table     Table
+	Precision int
+	MaxDelay  time.Duration
+
+	stations map[string]*pending
+}
+
+// NewRotator returns a Rotator for the given station settings, converted readings use the given precision.
+func NewRotator(table Table, precision int) *Rotator {
+	return &Rotator{
+		Table:     table,
+		Precision: precision,
+		MaxDelay:  DefaultMaxDelay,
+		stations:  make(map[string]*pending),
+	}
+}
+
+// Enabled returns whether any stations are to be converted.
+func (r *Rotator) Enabled() bool {
+	return len(r.Table) > 0
+}
+
+// index returns the position of a channel in the vector channels, or -1 for the scalar channel.
+func (p *pending) index(channel string) (int, bool) {
+	for i, c := range p.station.Channels {
+		if c == channel {
+			return i, true
+		}
+	}
+	if p.station.Scalar != "" && p.station.Scalar == channel {
+		return -1, true
+	}
+	return 0, false
+}
+
+// find returns the nearest sample within the tolerance of the given time that doesn't yet have
+// a reading for the channel position, a new sample is added if there isn't one.
+func (p *pending) find(n int, at time.Time) *sample {
+	i := sort.Search(len(p.samples), func(i int) bool {
+		return !p.samples[i].at.Before(at.Add(-p.tolerance))
+	})
+
+	var best *sample
+	for j := i; j < len(p.samples) && !p.samples[j].at.After(at.Add(p.tolerance)); j++ {
+		s := p.samples[j]
+		if (n < 0 && s.hasScalar) || (n >= 0 && s.have[n]) {
+			continue
+		}
+		if best == nil || abs(s.at.Sub(at)) < abs(best.at.Sub(at)) {
+			best = s
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	s := &sample{at: at}
+	for i < len(p.samples) && !p.samples[i].at.After(at) {
+		i++
+	}
+	p.samples = append(p.samples, nil)
+	copy(p.samples[i+1:], p.samples[i:])
+	p.samples[i] = s
+
+	return s
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// add includes a reading for the given channel position.
+func (p *pending) add(n int, v raw.Reading) {
+	if v.Flag != "" || math.IsNaN(v.Field) {
+		return
+	}
+
+	s := p.find(n, v.Timestamp)
+
+	switch {
+	case n < 0:
+		s.scalar, s.hasScalar = v.Field, true
+	default:
+		s.values[n], s.have[n] = v.Field, true
+	}
+
+	if v.Timestamp.After(p.latest) {
+		p.latest = v.Timestamp
+	}
+}
+
+// convert returns the output readings of a sample.
+func (p *pending) convert(s *sample) []raw.Reading {
+	label := p.station.Station + "_"
+
+	vector := p.station.Vector(s.values)
+
+	var readings []raw.Reading
+	for i, v := range vector.Components(p.station.output()) {
+		readings = append(readings, raw.NewReading(s.at, label+p.station.codes()[i], v))
+	}
+	if p.station.output() != FrameDIF {
+		readings = append(readings, raw.NewReading(s.at, label+CodeF, vector.F()))
+	}
+	if s.hasScalar {
+		readings = append(readings, raw.NewReading(s.at, label+CodeDeltaF, vector.F()-s.scalar))
+	}
+
+	return readings
+}
+
+// flush returns the output readings of any complete samples, or of those too far behind the
+// latest reading to expect any more, final flushes all samples with vector readings.
+func (p *pending) flush(final bool, delay time.Duration) []raw.Reading {
+	var readings []raw.Reading
+
+	var samples []*sample
+	for _, s := range p.samples {
+		complete := s.vector() && (p.station.Scalar == "" || s.hasScalar)
+		stale := delay > 0 && s.at.Before(p.latest.Add(-delay))
+		if !complete && !stale && !final {
+			samples = append(samples, s)
+			continue
+		}
+		if s.vector() {
+			readings = append(readings, p.convert(s)...)
+		}
+	}
+	p.samples = samples
+
+	return readings
+}
+
+// group builds raw data sets from the converted readings, one per output label.
+func (r *Rotator) group(readings []raw.Reading) []*raw.Raw {
+	cache := make(map[string]*raw.Raw)
+	for _, v := range readings {
+		if _, ok := cache[v.Label]; !ok {
+			cache[v.Label] = raw.NewRaw(v.Label, r.Precision)
+		}
+		cache[v.Label].Add(v)
+	}
+
+	var res []*raw.Raw
+	for _, v := range cache {
+		sort.Slice(v.Readings, func(i, j int) bool {
+			return v.Readings[i].Less(v.Readings[j])
+		})
+		res = append(res, v)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Label < res[j].Label
+	})
+
+	return res
+}
+
+// Add includes the readings of raw data sets and returns the converted readings of any samples
+// that are now complete.
+func (r *Rotator) Add(raws ...*raw.Raw) []*raw.Raw {
+	if r.stations == nil {
+		r.stations = make(map[string]*pending)
+	}
+
+	touched := make(map[string]*pending)
+	for _, v := range raws {
+		station, channel := raw.SplitSrcName(v.Label)
+
+		p, ok := r.stations[station]
+		if !ok {
+			settings, ok := r.Table.Find(station)
+			if !ok {
+				continue
+			}
+			p = &pending{
+				station: settings,
+			}
+			r.stations[station] = p
+		}
+
+		n, ok := p.index(channel)
+		if !ok {
+			continue
+		}
+		if d := v.Spacing(); d > 0 {
+			p.tolerance = d / 2
+		}
+		for _, reading := range v.Readings {
+			p.add(n, reading)
+		}
+
+		touched[station] = p
+	}
+
+	var readings []raw.Reading
+	for _, p := range touched {
+		readings = append(readings, p.flush(false, r.MaxDelay)...)
+	}
+
+	return r.group(readings)
+}
+
+// Flush returns the converted readings of all remaining samples that have readings from each vector channel.
+func (r *Rotator) Flush() []*raw.Raw {
+	var readings []raw.Reading
+	for _, p := range r.stations {
+		readings = append(readings, p.flush(true, r.MaxDelay)...)
+	}
+	return r.group(readings)
+}
+
+// Rotate returns the converted readings of a complete set of raw data.
+func Rotate(table Table, precision int, raws []*raw.Raw) []*raw.Raw {
+	r := NewRotator(table, precision)
+
+	var readings []raw.Reading
+	for _, v := range append(r.Add(raws...), r.Flush()...) {
+		readings = append(readings, v.Readings...)
+	}
+
+	return r.group(readings)
+}
@@ -0,0 +1,422 @@
+package seedlink
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often an idle connection is checked for timeouts and keep-alives.
+const pollInterval = time.Second
+
+// negotiateTimeout is used for command responses when no network timeout has been given.
+const negotiateTimeout = 30 * time.Second
+
+// Client manages SeedLink connections to a single server, stream sequence numbers
+// are updated as packets are received so that a new connection will resume from
+// the last packet collected.
+type Client struct {
+	// Server is the SeedLink address in host:port format, either of which may be empty.
+	Server string
+	// Timeout is the network timeout after which an idle connection is abandoned.
+	Timeout time.Duration
+	// KeepAlive is the interval to send keep-alive requests when the connection is idle.
+	KeepAlive time.Duration
+	// BeginTime and EndTime are used for streams without a sequence number.
+	BeginTime time.Time
+	EndTime   time.Time
+	// Dialup requests data using FETCH rather than DATA, the connection ends once
+	// all buffered data has been sent.
+	Dialup bool
+
+	// Version and Site hold the server HELLO response of the last connection.
+	Version string
+	Site    string
+
+	mu      sync.Mutex
+	streams []Stream
+}
+
+// NewClient returns a Client for the given SeedLink server.
+func NewClient(server string) *Client {
+	return &Client{
+		Server: server,
+	}
+}
+
+// SetStreams replaces the requested streams.
+func (c *Client) SetStreams(streams []Stream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.streams = append([]Stream{}, streams...)
+}
+
+// ParseStreamList sets the requested streams using a libslink style stream list
+// and default selectors, it returns the number of streams configured.
+func (c *Client) ParseStreamList(streamlist, selectors string) (int, error) {
+	streams, err := ParseStreamList(streamlist, selectors)
+	if err != nil {
+		return 0, err
+	}
+
+	c.SetStreams(streams)
+
+	return len(streams), nil
+}
+
+// Streams returns a copy of the current stream details.
+func (c *Client) Streams() []Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Stream{}, c.streams...)
+}
+
+// update records the sequence number and time of a received data packet.
+func (c *Client) update(p Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := p.Network() + "_" + p.Station()
+	for i, s := range c.streams {
+		if ok, _ := path.Match(s.Key(), key); !ok {
+			continue
+		}
+		c.streams[i].Sequence, c.streams[i].Timestamp = p.Sequence, p.Starttime()
+		return
+	}
+}
+
+// address adds default host and port details to a SeedLink server address.
+func address(server string) string {
+	host, port := server, DefaultPort
+	if n := strings.LastIndex(server, ":"); n >= 0 {
+		host, port = server[:n], server[n+1:]
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// conn wraps a network connection with SeedLink command handling.
+type conn struct {
+	net.Conn
+
+	rd      *bufio.Reader
+	timeout time.Duration
+}
+
+// command sends a single SeedLink command.
+func (c *conn) command(cmd string) error {
+	if err := c.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c, cmd+"\r")
+	return err
+}
+
+// line reads a single response line.
+func (c *conn) line() (string, error) {
+	if err := c.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", err
+	}
+	s, err := c.rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(s, "\r\n"), nil
+}
+
+// request sends a command and returns whether the server responded with OK.
+func (c *conn) request(cmd string) (bool, error) {
+	if err := c.command(cmd); err != nil {
+		return false, err
+	}
+	resp, err := c.line()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case strings.HasPrefix(resp, "OK"):
+		return true, nil
+	case strings.HasPrefix(resp, "ERROR"):
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid response to %q: %q", cmd, resp)
+	}
+}
+
+// packet reads a complete SeedLink packet into buf, idle is called whenever no data
+// has arrived within the poll interval and may abort the read by returning an error.
+// An io.EOF is returned if the server signals the end of the data stream.
+func (c *conn) packet(buf []byte, idle func() error) error {
+	var n int
+	for n < len(buf) {
+		if err := c.SetReadDeadline(time.Now().Add(pollInterval)); err != nil {
+			return err
+		}
+		m, err := c.rd.Read(buf[n:])
+		n += m
+		if n >= 3 && string(buf[:3]) == "END" {
+			return io.EOF
+		}
+		var ne net.Error
+		switch {
+		case err == nil, m > 0:
+		case errors.As(err, &ne) && ne.Timeout():
+			if err := idle(); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// connect opens a connection to the server and exchanges HELLO details.
+func (c *Client) connect(ctx context.Context) (*conn, error) {
+	var dialer net.Dialer
+
+	nc, err := dialer.DialContext(ctx, "tcp", address(c.Server))
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if !(timeout > 0) {
+		timeout = negotiateTimeout
+	}
+
+	conn := &conn{
+		Conn:    nc,
+		rd:      bufio.NewReader(nc),
+		timeout: timeout,
+	}
+
+	if err := conn.command("HELLO"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	version, err := conn.line()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	site, err := conn.line()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.Version, c.Site = version, site
+	c.mu.Unlock()
+
+	return conn, nil
+}
+
+// action builds the DATA, FETCH or TIME command for a stream.
+func (c *Client) action(s Stream) string {
+	cmd := "DATA"
+	if c.Dialup {
+		cmd = "FETCH"
+	}
+
+	switch {
+	case s.Sequence >= 0 && !s.Timestamp.IsZero():
+		return fmt.Sprintf("%s %06X %s", cmd, (s.Sequence+1)&0xffffff, s.Timestamp.UTC().Format(TimeFormat))
+	case s.Sequence >= 0:
+		return fmt.Sprintf("%s %06X", cmd, (s.Sequence+1)&0xffffff)
+	case !c.BeginTime.IsZero() && !c.EndTime.IsZero():
+		return fmt.Sprintf("TIME %s %s", c.BeginTime.UTC().Format(TimeFormat), c.EndTime.UTC().Format(TimeFormat))
+	case !c.BeginTime.IsZero():
+		return fmt.Sprintf("TIME %s", c.BeginTime.UTC().Format(TimeFormat))
+	default:
+		return cmd
+	}
+}
+
+// negotiate requests the configured streams using multi-station mode.
+func (c *Client) negotiate(conn *conn) error {
+	streams := c.Streams()
+	if !(len(streams) > 0) {
+		return errors.New("no streams configured")
+	}
+
+	var accepted int
+	for _, s := range streams {
+		ok, err := conn.request(fmt.Sprintf("STATION %s %s", s.Station, s.Network))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		for _, sel := range s.Selectors {
+			if _, err := conn.request("SELECT " + sel); err != nil {
+				return err
+			}
+		}
+		ok, err = conn.request(c.action(s))
+		if err != nil {
+			return err
+		}
+		if ok {
+			accepted++
+		}
+	}
+
+	if !(accepted > 0) {
+		return errors.New("no stations accepted")
+	}
+
+	return conn.command("END")
+}
+
+// watch closes the connection if the context is cancelled, the returned function
+// must be called once the connection is no longer in use.
+func watch(ctx context.Context, conn *conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// Collect connects to the server, negotiates the configured streams and sends the
+// received data packets to the given channel. It returns when the context is cancelled,
+// the server ends the data stream, or a network error or timeout occurs; the client
+// may then be collected from again and will resume from the last received packets.
+func (c *Client) Collect(ctx context.Context, packets chan<- Packet) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := watch(ctx, conn)
+	defer stop()
+
+	if err := c.negotiate(conn); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	last, sent := time.Now(), time.Now()
+	idle := func() error {
+		now := time.Now()
+		if c.Timeout > 0 && now.Sub(last) > c.Timeout {
+			return fmt.Errorf("network timeout after %s", now.Sub(last))
+		}
+		if c.KeepAlive > 0 && now.Sub(last) > c.KeepAlive && now.Sub(sent) > c.KeepAlive {
+			sent = now
+			return conn.command("INFO ID")
+		}
+		return nil
+	}
+
+	buf := make([]byte, HeaderSize+RecordSize)
+	for {
+		switch err := conn.packet(buf, idle); {
+		case err == io.EOF:
+			return nil
+		case err != nil && ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			return err
+		}
+		last = time.Now()
+
+		kind, seq, err := parseHeader(buf[:HeaderSize])
+		if err != nil {
+			return err
+		}
+		if kind != Data {
+			// keep-alive responses
+			continue
+		}
+
+		p := Packet{
+			Type:     kind,
+			Sequence: seq,
+			Record:   append([]byte{}, buf[HeaderSize:]...),
+		}
+
+		select {
+		case packets <- p:
+			c.update(p)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Info requests the given INFO level from the server and returns the XML response.
+func (c *Client) Info(ctx context.Context, level string) (string, error) {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	stop := watch(ctx, conn)
+	defer stop()
+
+	if err := conn.command("INFO " + level); err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	idle := func() error {
+		if time.Since(start) > conn.timeout {
+			return fmt.Errorf("network timeout after %s", time.Since(start))
+		}
+		return nil
+	}
+
+	var sb strings.Builder
+
+	buf := make([]byte, HeaderSize+RecordSize)
+	for {
+		if err := conn.packet(buf, idle); err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", err
+		}
+
+		kind, _, err := parseHeader(buf[:HeaderSize])
+		if err != nil {
+			return "", err
+		}
+
+		switch p := (Packet{Type: kind, Record: buf[HeaderSize:]}); kind {
+		case Info:
+			sb.WriteString(p.Text())
+		case InfoTerminal:
+			sb.WriteString(p.Text())
+			return sb.String(), nil
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package seedlink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozym/geomag/internal/seedlink/seedlinktest"
+)
+
+func testRecords(t *testing.T, n int) [][]byte {
+	data, err := ioutil.ReadFile("../../cmd/msgeomag/testdata/NZ.EYWM.51.LFF.D.2019.146")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records [][]byte
+	for i := 0; i < n && (i+1)*RecordSize <= len(data); i++ {
+		records = append(records, data[i*RecordSize:(i+1)*RecordSize])
+	}
+
+	return records
+}
+
+func hasCommand(commands []string, cmd string) bool {
+	for _, c := range commands {
+		if strings.HasPrefix(c, cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseStreamList(t *testing.T) {
+	streams, err := ParseStreamList("IU_KONO:BHE BHN,GE_WLF,MN_AQU:HH?.D", "H??")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 3 {
+		t.Fatalf("expected 3 streams got %d", len(streams))
+	}
+	if s := streams[0]; s.Key() != "IU_KONO" || strings.Join(s.Selectors, " ") != "BHE BHN" || s.Sequence != -1 {
+		t.Errorf("unexpected stream: %+v", s)
+	}
+	if s := streams[1]; s.Key() != "GE_WLF" || strings.Join(s.Selectors, " ") != "H??" {
+		t.Errorf("unexpected stream: %+v", s)
+	}
+
+	if _, err := ParseStreamList("IU__KONO:BHE BHN,GE_WLF", ""); err == nil {
+		t.Error("shouldn't be able to parse stream list, invalid string")
+	}
+}
+
+func TestClient_Collect(t *testing.T) {
+	records := testRecords(t, 10)
+
+	server := seedlinktest.NewServer(records)
+	defer server.Close()
+
+	client := NewClient(server.Addr())
+	client.BeginTime = time.Date(2019, time.May, 26, 0, 0, 0, 0, time.UTC)
+	client.EndTime = time.Date(2019, time.May, 27, 0, 0, 0, 0, time.UTC)
+	if _, err := client.ParseStreamList("NZ_EYWM", "51LF?"); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := make(chan Packet, len(records))
+	if err := client.Collect(context.Background(), packets); err != nil {
+		t.Fatal(err)
+	}
+	close(packets)
+
+	var n int
+	for p := range packets {
+		n++
+		if p.Sequence != n {
+			t.Errorf("expected sequence %d got %d", n, p.Sequence)
+		}
+		if p.Network() != "NZ" || p.Station() != "EYWM" {
+			t.Errorf("unexpected packet source: %s_%s", p.Network(), p.Station())
+		}
+		if string(p.Record) != string(records[n-1]) {
+			t.Errorf("packet %d record mismatch", n)
+		}
+	}
+	if n != len(records) {
+		t.Errorf("expected %d packets got %d", len(records), n)
+	}
+
+	commands := server.Commands()
+	for _, c := range []string{"HELLO", "STATION EYWM NZ", "SELECT 51LF?", "TIME 2019,05,26,00,00,00 2019,05,27,00,00,00", "END"} {
+		if !hasCommand(commands, c) {
+			t.Errorf("missing command %q: %v", c, commands)
+		}
+	}
+	if !strings.Contains(client.Version, "SeedLink") {
+		t.Errorf("unexpected server version: %s", client.Version)
+	}
+
+	streams := client.Streams()
+	if s := streams[0]; s.Sequence != len(records) || !s.Timestamp.Equal(Packet{Record: records[len(records)-1]}.Starttime()) {
+		t.Errorf("unexpected stream state: %+v", s)
+	}
+}
+
+func TestClient_Resume(t *testing.T) {
+	records := testRecords(t, 10)
+
+	server := seedlinktest.NewServer(records)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "seedlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statefile := filepath.Join(dir, "seedlink.state")
+
+	client := NewClient(server.Addr())
+	if _, err := client.ParseStreamList("NZ_EYWM", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	packets := make(chan Packet)
+	done := make(chan error)
+	go func() {
+		done <- client.Collect(ctx, packets)
+	}()
+	for i := 0; i < 4; i++ {
+		<-packets
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected context cancelled got %v", err)
+	}
+
+	if err := client.SaveState(statefile); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := NewClient(server.Addr())
+	resumed.Dialup = true
+	if _, err := resumed.ParseStreamList("NZ_EYWM", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.RecoverState(statefile); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining := make(chan Packet, len(records))
+	if err := resumed.Collect(context.Background(), remaining); err != nil {
+		t.Fatal(err)
+	}
+	close(remaining)
+
+	p, ok := <-remaining
+	if !ok || p.Sequence != 5 {
+		t.Fatalf("expected to resume from sequence 5 got %d", p.Sequence)
+	}
+	if n := len(remaining) + 1; n != 6 {
+		t.Errorf("expected 6 remaining packets got %d", n)
+	}
+	if !hasCommand(server.Commands(), "FETCH 000005 2019,05,26") {
+		t.Errorf("missing resume command: %v", server.Commands())
+	}
+}
+
+func TestClient_KeepAlive(t *testing.T) {
+	server := seedlinktest.NewServer(testRecords(t, 1))
+	defer server.Close()
+
+	client := NewClient(server.Addr())
+	client.KeepAlive = time.Second
+	client.Timeout = time.Minute
+	if _, err := client.ParseStreamList("NZ_EYWM", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3500*time.Millisecond)
+	defer cancel()
+
+	packets := make(chan Packet, 1)
+	if err := client.Collect(ctx, packets); err != context.DeadlineExceeded {
+		t.Fatalf("expected context deadline got %v", err)
+	}
+	if len(packets) != 1 {
+		t.Errorf("expected a single packet got %d", len(packets))
+	}
+	if !hasCommand(server.Commands(), "INFO ID") {
+		t.Errorf("missing keep-alive request: %v", server.Commands())
+	}
+}
+
+func TestClient_Info(t *testing.T) {
+	server := seedlinktest.NewServer(nil)
+	defer server.Close()
+
+	info, err := NewClient(server.Addr()).Info(context.Background(), "STATIONS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(info, `level="STATIONS"`) {
+		t.Errorf("unexpected info response: %q", info)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	for k, v := range map[string]string{
+		"":                   "localhost:18000",
+		":":                  "localhost:18000",
+		"link.geonet.org.nz": "link.geonet.org.nz:18000",
+		":18001":             "localhost:18001",
+		"127.0.0.1:18002":    "127.0.0.1:18002",
+	} {
+		if a := address(k); a != v {
+			t.Errorf("expected address %s for %q got %s", v, k, a)
+		}
+	}
+}
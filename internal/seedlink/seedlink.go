@@ -0,0 +1,161 @@
+// Package seedlink provides a native SeedLink v3 client.
+package seedlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderSize is the length of a SeedLink packet header.
+	HeaderSize = 8
+	// RecordSize is the length of the miniseed record in a SeedLink packet.
+	RecordSize = 512
+	// TimeFormat is the SeedLink time format used for requests and state files.
+	TimeFormat = "2006,01,02,15,04,05"
+	// DefaultPort is the SeedLink port used when the server address has none.
+	DefaultPort = "18000"
+)
+
+// Type indicates the kind of SeedLink packet received.
+type Type int
+
+const (
+	Data         Type = iota // waveform or other data record
+	Info                     // a non-terminating INFO response packet
+	InfoTerminal             // the final INFO response packet
+)
+
+// Packet holds a SeedLink packet as received from the server.
+type Packet struct {
+	Type     Type
+	Sequence int
+	Record   []byte
+}
+
+// Network returns the network code from the miniseed record header.
+func (p Packet) Network() string {
+	return field(p.Record, 18, 2)
+}
+
+// Station returns the station code from the miniseed record header.
+func (p Packet) Station() string {
+	return field(p.Record, 8, 5)
+}
+
+// Starttime returns the record start time from the miniseed record header.
+func (p Packet) Starttime() time.Time {
+	if len(p.Record) < 48 {
+		return time.Time{}
+	}
+
+	order := binary.ByteOrder(binary.BigEndian)
+	if year, day := order.Uint16(p.Record[20:]), order.Uint16(p.Record[22:]); year < 1900 || year > 2100 || day < 1 || day > 366 {
+		order = binary.LittleEndian
+	}
+
+	year, day := int(order.Uint16(p.Record[20:])), int(order.Uint16(p.Record[22:]))
+	hour, minute, second := int(p.Record[24]), int(p.Record[25]), int(p.Record[26])
+	fract := int(order.Uint16(p.Record[28:]))
+
+	return time.Date(year, time.January, day, hour, minute, second, fract*100000, time.UTC)
+}
+
+// Text returns the text payload of an INFO packet, this is the data section of
+// the enclosed miniseed log record.
+func (p Packet) Text() string {
+	if len(p.Record) < 48 {
+		return ""
+	}
+
+	order := binary.ByteOrder(binary.BigEndian)
+	if year, day := order.Uint16(p.Record[20:]), order.Uint16(p.Record[22:]); year < 1900 || year > 2100 || day < 1 || day > 366 {
+		order = binary.LittleEndian
+	}
+
+	samples, offset := int(order.Uint16(p.Record[30:])), int(order.Uint16(p.Record[44:]))
+	if offset < 48 || offset+samples > len(p.Record) {
+		return ""
+	}
+
+	return string(p.Record[offset : offset+samples])
+}
+
+func field(record []byte, offset, length int) string {
+	if len(record) < offset+length {
+		return ""
+	}
+	return strings.TrimRight(string(record[offset:offset+length]), " \x00")
+}
+
+// Stream describes a requested network and station with optional selectors, the sequence
+// number and time of the last packet received is maintained to allow resuming.
+type Stream struct {
+	Network   string
+	Station   string
+	Selectors []string
+
+	Sequence  int
+	Timestamp time.Time
+}
+
+// Key returns the NET_STA identifier for the stream.
+func (s Stream) Key() string {
+	return s.Network + "_" + s.Station
+}
+
+// ParseStreamList decodes a libslink style stream list, e.g. "IU_KONO:BHE BHN,GE_WLF,MN_AQU:HH?.D",
+// the default selectors are used for a stream that has none.
+func ParseStreamList(streamlist, selectors string) ([]Stream, error) {
+	var streams []Stream
+	for _, s := range strings.Split(streamlist, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		parts := strings.SplitN(s, ":", 2)
+
+		netsta := strings.Split(parts[0], "_")
+		if len(netsta) != 2 || netsta[0] == "" || netsta[1] == "" {
+			return nil, fmt.Errorf("not in NET_STA format: %s", parts[0])
+		}
+
+		sel := strings.Fields(selectors)
+		if len(parts) > 1 {
+			sel = strings.Fields(parts[1])
+		}
+
+		streams = append(streams, Stream{
+			Network:   netsta[0],
+			Station:   netsta[1],
+			Selectors: sel,
+			Sequence:  -1,
+		})
+	}
+
+	return streams, nil
+}
+
+// parseHeader decodes the SeedLink packet header.
+func parseHeader(header []byte) (Type, int, error) {
+	switch {
+	case len(header) != HeaderSize:
+		return 0, 0, fmt.Errorf("invalid packet header length: %d", len(header))
+	case string(header[0:6]) == "SLINFO":
+		if header[7] == '*' {
+			return Info, -1, nil
+		}
+		return InfoTerminal, -1, nil
+	case string(header[0:2]) == "SL":
+		seq, err := strconv.ParseInt(string(header[2:]), 16, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid packet sequence number: %q", string(header[2:]))
+		}
+		return Data, int(seq), nil
+	default:
+		return 0, 0, fmt.Errorf("invalid packet header: %q", string(header))
+	}
+}
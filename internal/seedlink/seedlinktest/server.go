@@ -0,0 +1,244 @@
+// Package seedlinktest provides a minimal in-process SeedLink server for testing.
+package seedlinktest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves a fixed set of miniseed records using the SeedLink v3 protocol, the
+// sequence number of each record is its position in the list starting from one.
+type Server struct {
+	Listener net.Listener
+
+	records [][]byte
+
+	mu       sync.Mutex
+	commands []string
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a Server on a local port serving the given 512 byte records.
+func NewServer(records [][]byte) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("seedlinktest: failed to listen on a port: %v", err))
+	}
+
+	s := &Server{
+		Listener: l,
+		records:  records,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[c] = struct{}{}
+			s.mu.Unlock()
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(c)
+			}()
+		}
+	}()
+
+	return s
+}
+
+// Addr returns the server address in host:port format.
+func (s *Server) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+// Commands returns the commands received by the server.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.commands...)
+}
+
+// CloseClients drops any open client connections.
+func (s *Server) CloseClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Close shuts down the server and all client connections.
+func (s *Server) Close() {
+	s.Listener.Close()
+	s.CloseClients()
+	s.wg.Wait()
+}
+
+type request struct {
+	network string
+	station string
+	start   int
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	var requests []request
+	var station *request
+	var fetch bool
+
+	// writes are shared with the packet streaming goroutine
+	var wmu sync.Mutex
+	write := func(data []byte) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		_, err := c.Write(data)
+		return err
+	}
+
+	rd := bufio.NewReader(c)
+	for {
+		line, err := rd.ReadString('\r')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		if cmd == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		s.mu.Unlock()
+
+		fields := strings.Fields(cmd)
+		switch strings.ToUpper(fields[0]) {
+		case "HELLO":
+			if err := write([]byte("SeedLink v3.1 (seedlinktest)\r\nseedlinktest\r\n")); err != nil {
+				return
+			}
+		case "STATION":
+			if len(fields) < 3 {
+				write([]byte("ERROR\r\n"))
+				continue
+			}
+			requests = append(requests, request{network: fields[2], station: fields[1], start: 1})
+			station = &requests[len(requests)-1]
+			write([]byte("OK\r\n"))
+		case "SELECT":
+			write([]byte("OK\r\n"))
+		case "DATA", "FETCH", "TIME":
+			if station == nil {
+				write([]byte("ERROR\r\n"))
+				continue
+			}
+			if strings.ToUpper(fields[0]) != "TIME" && len(fields) > 1 {
+				if seq, err := strconv.ParseInt(fields[1], 16, 32); err == nil {
+					station.start = int(seq)
+				}
+			}
+			// a time window or dial-up request ends once all data has been sent
+			switch strings.ToUpper(fields[0]) {
+			case "FETCH":
+				fetch = true
+			case "TIME":
+				fetch = fetch || len(fields) > 2
+			}
+			write([]byte("OK\r\n"))
+		case "END":
+			go s.stream(requests, fetch, write, c)
+		case "INFO":
+			level := "ID"
+			if len(fields) > 1 {
+				level = strings.ToUpper(fields[1])
+			}
+			text := fmt.Sprintf("<?xml version=\"1.0\"?>\n<seedlink software=\"seedlinktest\" level=\"%s\"/>\n", level)
+			if err := write(append([]byte("SLINFO  "), InfoRecord(text)...)); err != nil {
+				return
+			}
+		case "BYE":
+			return
+		default:
+			write([]byte("ERROR\r\n"))
+		}
+	}
+}
+
+// stream sends the records matching the requested stations, a dial-up or time
+// window connection is ended once all records have been sent.
+func (s *Server) stream(requests []request, fetch bool, write func([]byte) error, c net.Conn) {
+	for i, r := range s.records {
+		seq := i + 1
+		if len(r) < 20 {
+			continue
+		}
+		net, sta := strings.TrimSpace(string(r[18:20])), strings.TrimSpace(string(r[8:13]))
+		for _, q := range requests {
+			if q.network != net || q.station != sta || seq < q.start {
+				continue
+			}
+			packet := make([]byte, 8+512)
+			copy(packet, fmt.Sprintf("SL%06X", seq))
+			copy(packet[8:], r)
+			if err := write(packet); err != nil {
+				return
+			}
+		}
+	}
+	if fetch {
+		write([]byte("END"))
+		c.Close()
+	}
+}
+
+// InfoRecord builds a 512 byte miniseed log record holding the given text.
+func InfoRecord(text string) []byte {
+	record := make([]byte, 512)
+
+	copy(record[0:], "000000D ")
+	copy(record[8:], "INFO   LOGSL")
+
+	now := time.Now().UTC()
+	binary.BigEndian.PutUint16(record[20:], uint16(now.Year()))
+	binary.BigEndian.PutUint16(record[22:], uint16(now.YearDay()))
+	record[24], record[25], record[26] = byte(now.Hour()), byte(now.Minute()), byte(now.Second())
+
+	if len(text) > 512-64 {
+		text = text[:512-64]
+	}
+
+	binary.BigEndian.PutUint16(record[30:], uint16(len(text)))
+	record[39] = 1
+	binary.BigEndian.PutUint16(record[44:], 64)
+	binary.BigEndian.PutUint16(record[46:], 48)
+
+	// blockette 1000: ascii encoding, big endian, 512 byte records
+	binary.BigEndian.PutUint16(record[48:], 1000)
+	record[52], record[53], record[54] = 0, 1, 9
+
+	copy(record[64:], text)
+
+	return record
+}
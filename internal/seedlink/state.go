@@ -0,0 +1,83 @@
+package seedlink
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SaveState writes the stream sequence numbers and packet times to a state file, the
+// format is compatible with libslink state files.
+func (c *Client) SaveState(statefile string) error {
+	var buf bytes.Buffer
+	for _, s := range c.Streams() {
+		if s.Sequence < 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s %s %d %s\n", s.Network, s.Station, s.Sequence, s.Timestamp.UTC().Format(TimeFormat))
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(statefile), ".xxxx")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), statefile)
+}
+
+// RecoverState reads stream sequence numbers and packet times from a state file, only
+// streams that have already been configured are updated.
+func (c *Client) RecoverState(statefile string) error {
+	file, err := os.Open(statefile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		seq, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("invalid state sequence number: %q", fields[2])
+		}
+
+		var at time.Time
+		if len(fields) > 3 {
+			if at, err = time.Parse(TimeFormat, fields[3]); err != nil {
+				return fmt.Errorf("invalid state time: %q", fields[3])
+			}
+		}
+
+		for i, s := range c.streams {
+			if s.Network != fields[0] || s.Station != fields[1] {
+				continue
+			}
+			c.streams[i].Sequence, c.streams[i].Timestamp = seq, at
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,202 @@
+package stationxml
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fdsnQuery = "/fdsnws/station/1/query?"
+
+// Service fetches response level StationXML documents from an FDSN station service,
+// query results are kept in memory, and optionally cached on disk, and are reused
+// until they are older than MaxAge.
+type Service struct {
+	Service string
+	Timeout time.Duration
+
+	// Cache is an optional directory to store fetched documents.
+	Cache string
+	// MaxAge is how long a cached document is used before fetching again, zero
+	// will always use a cached document if present.
+	MaxAge time.Duration
+
+	mu      sync.Mutex
+	results map[string]result
+}
+
+// result holds the sensitivities decoded from a query along with when they were fetched.
+type result struct {
+	list    Sensitivities
+	fetched time.Time
+}
+
+func NewService(service string, timeout time.Duration) *Service {
+	return &Service{
+		Service: service,
+		Timeout: timeout,
+	}
+}
+
+// Request builds the station service response level query, empty codes are replaced
+// with wildcards except for the location which is requested as blank.
+func (s *Service) Request(network, station, location, channel string) (string, error) {
+	wildcard := func(s string) string {
+		if s == "" {
+			return "*"
+		}
+		return s
+	}
+	if location == "" {
+		location = "--"
+	}
+
+	values := url.Values{}
+	values.Add("network", wildcard(network))
+	values.Add("station", wildcard(station))
+	values.Add("location", location)
+	values.Add("channel", wildcard(channel))
+	values.Add("level", "response")
+
+	req, err := url.Parse(strings.TrimRight(s.Service, "/") + fdsnQuery + values.Encode())
+	if err != nil {
+		return "", err
+	}
+
+	return req.String(), nil
+}
+
+// cacheFile returns the cache file name used for a query.
+func (s *Service) cacheFile(network, station, location, channel string) string {
+	name := strings.Join([]string{network, station, location, channel}, "_")
+	return filepath.Join(s.Cache, url.QueryEscape(name)+".xml")
+}
+
+// fresh returns whether a result fetched at the given time can still be used.
+func (s *Service) fresh(fetched time.Time) bool {
+	return !(s.MaxAge > 0) || time.Since(fetched) < s.MaxAge
+}
+
+// remember keeps the result of a query in memory.
+func (s *Service) remember(key string, list Sensitivities, fetched time.Time) {
+	if s.results == nil {
+		s.results = make(map[string]result)
+	}
+	s.results[key] = result{list: list, fetched: fetched}
+}
+
+// fetch requests a StationXML document from the service, a service responding with
+// no data will return an empty document.
+func (s *Service) fetch(query string) ([]byte, error) {
+	client := &http.Client{
+		Timeout: s.Timeout,
+	}
+
+	resp, err := client.Get(query)
+	if resp == nil || err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid response from %s: %s", query, resp.Status)
+	}
+}
+
+// store writes a document into the cache via a temporary file.
+func (s *Service) store(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".xxxx")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Query returns the sensitivities of the matching channels, using an earlier result or a
+// cached document if it is recent enough. A stale document is used if the service can't be reached.
+func (s *Service) Query(network, station, location, channel string) (Sensitivities, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.Join([]string{network, station, location, channel}, "_")
+
+	last, ok := s.results[key]
+	if ok && s.fresh(last.fetched) {
+		return last.list, nil
+	}
+
+	var cache string
+	if s.Cache != "" {
+		cache = s.cacheFile(network, station, location, channel)
+		if fi, err := os.Stat(cache); err == nil && s.fresh(fi.ModTime()) {
+			list, err := Load(cache)
+			if err != nil {
+				return nil, err
+			}
+			s.remember(key, list, fi.ModTime())
+			return list, nil
+		}
+	}
+
+	query, err := s.Request(network, station, location, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.fetch(query)
+	if err != nil {
+		if len(last.list) > 0 {
+			return last.list, nil
+		}
+		if cache != "" {
+			if list, cerr := Load(cache); cerr == nil {
+				return list, nil
+			}
+		}
+		return nil, err
+	}
+	if !(len(data) > 0) {
+		s.remember(key, nil, time.Now())
+		return nil, nil
+	}
+
+	list, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != "" {
+		if err := s.store(cache, data); err != nil {
+			return nil, err
+		}
+	}
+	s.remember(key, list, time.Now())
+
+	return list, nil
+}
@@ -0,0 +1,175 @@
+// Package stationxml extracts instrument sensitivities from FDSN StationXML documents.
+package stationxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// document holds the parts of a StationXML document needed to find channel sensitivities.
+type document struct {
+	Networks []struct {
+		Code     string `xml:"code,attr"`
+		Stations []struct {
+			Code     string `xml:"code,attr"`
+			Channels []struct {
+				Code         string `xml:"code,attr"`
+				LocationCode string `xml:"locationCode,attr"`
+				StartDate    string `xml:"startDate,attr"`
+				EndDate      string `xml:"endDate,attr"`
+				Response     struct {
+					InstrumentSensitivity *struct {
+						Value       float64 `xml:"Value"`
+						Frequency   float64 `xml:"Frequency"`
+						InputUnits  units   `xml:"InputUnits"`
+						OutputUnits units   `xml:"OutputUnits"`
+					} `xml:"InstrumentSensitivity"`
+				} `xml:"Response"`
+			} `xml:"Channel"`
+		} `xml:"Station"`
+	} `xml:"Network"`
+}
+
+type units struct {
+	Name string `xml:"Name"`
+}
+
+// timeFormats lists the accepted StationXML date time layouts.
+var timeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseTime decodes a StationXML date time, an empty value gives a zero time.
+func parseTime(s string) (time.Time, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return time.Time{}, nil
+	}
+	for _, f := range timeFormats {
+		if t, err := time.Parse(f, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid stationxml time: %q", s)
+}
+
+// nanotesla gives the number of nT in each of the magnetic field units, sensitivities
+// given in any of these are converted into nT so the collectors store nT values.
+var nanotesla = map[string]float64{
+	"T":  1e9,
+	"mT": 1e6,
+	"uT": 1e3,
+	"µT": 1e3,
+	"nT": 1.0,
+	"pT": 1e-3,
+}
+
+// Sensitivity is the overall instrument sensitivity of a channel epoch, in output
+// units (usually counts) per input unit at the given frequency. Magnetic field
+// sensitivities are always given per nT, whatever input units were used.
+type Sensitivity struct {
+	Srcname     string
+	Start       time.Time
+	End         time.Time
+	Value       float64
+	Frequency   float64
+	InputUnits  string
+	OutputUnits string
+}
+
+// Valid returns whether the channel epoch covers the given time, a zero end time is open.
+func (s Sensitivity) Valid(at time.Time) bool {
+	if !s.Start.IsZero() && at.Before(s.Start) {
+		return false
+	}
+	if !s.End.IsZero() && !at.Before(s.End) {
+		return false
+	}
+	return true
+}
+
+// Sensitivities holds the sensitivities of a set of channel epochs.
+type Sensitivities []Sensitivity
+
+// Find returns the sensitivity of the channel epoch covering the given time.
+func (s Sensitivities) Find(srcname string, at time.Time) (Sensitivity, bool) {
+	for _, v := range s {
+		if v.Srcname == srcname && v.Valid(at) {
+			return v, true
+		}
+	}
+	return Sensitivity{}, false
+}
+
+// Convert divides a raw sample value by the channel sensitivity to give a value in
+// physical units, the boolean result indicates whether a sensitivity was applied.
+func (s Sensitivities) Convert(srcname string, at time.Time, v float64) (float64, bool) {
+	if r, ok := s.Find(srcname, at); ok && r.Value != 0.0 {
+		return v / r.Value, true
+	}
+	return v, false
+}
+
+// Decode reads the channel sensitivities from a StationXML document, channels without an
+// instrument sensitivity are skipped.
+func Decode(rd io.Reader) (Sensitivities, error) {
+	var doc document
+	if err := xml.NewDecoder(rd).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var list Sensitivities
+	for _, n := range doc.Networks {
+		for _, s := range n.Stations {
+			for _, c := range s.Channels {
+				sens := c.Response.InstrumentSensitivity
+				if sens == nil {
+					continue
+				}
+
+				start, err := parseTime(c.StartDate)
+				if err != nil {
+					return nil, err
+				}
+				end, err := parseTime(c.EndDate)
+				if err != nil {
+					return nil, err
+				}
+
+				value, units := sens.Value, strings.TrimSpace(sens.InputUnits.Name)
+				if scale, ok := nanotesla[units]; ok {
+					value, units = value/scale, "nT"
+				}
+
+				list = append(list, Sensitivity{
+					Srcname:     strings.Join([]string{n.Code, s.Code, strings.TrimSpace(c.LocationCode), c.Code}, "_"),
+					Start:       start,
+					End:         end,
+					Value:       value,
+					Frequency:   sens.Frequency,
+					InputUnits:  units,
+					OutputUnits: sens.OutputUnits.Name,
+				})
+			}
+		}
+	}
+
+	return list, nil
+}
+
+// Load reads the channel sensitivities from a local StationXML file.
+func Load(path string) (Sensitivities, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Decode(file)
+}
@@ -0,0 +1,230 @@
+package stationxml
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testStationXML = `<?xml version="1.0" encoding="UTF-8"?>
+<FDSNStationXML xmlns="http://www.fdsn.org/xml/station/1" schemaVersion="1.1">
+  <Source>test</Source>
+  <Created>2020-01-01T00:00:00Z</Created>
+  <Network code="NZ">
+    <Station code="EYWM" startDate="2018-01-01T00:00:00Z">
+      <Channel code="LFZ" locationCode="51" startDate="2018-01-01T00:00:00" endDate="2020-01-01T00:00:00">
+        <Response>
+          <InstrumentSensitivity>
+            <Value>10</Value>
+            <Frequency>0</Frequency>
+            <InputUnits><Name>nT</Name></InputUnits>
+            <OutputUnits><Name>count</Name></OutputUnits>
+          </InstrumentSensitivity>
+        </Response>
+      </Channel>
+      <Channel code="LFZ" locationCode="51" startDate="2020-01-01T00:00:00.0000Z">
+        <Response>
+          <InstrumentSensitivity>
+            <Value>20</Value>
+            <Frequency>0</Frequency>
+            <InputUnits><Name>nT</Name></InputUnits>
+            <OutputUnits><Name>count</Name></OutputUnits>
+          </InstrumentSensitivity>
+        </Response>
+      </Channel>
+      <Channel code="LKO" locationCode="" startDate="2018-01-01T00:00:00Z">
+        <Response/>
+      </Channel>
+    </Station>
+  </Network>
+</FDSNStationXML>
+`
+
+// testTeslaStationXML holds a channel sensitivity given in counts per tesla.
+const testTeslaStationXML = `<?xml version="1.0" encoding="UTF-8"?>
+<FDSNStationXML xmlns="http://www.fdsn.org/xml/station/1" schemaVersion="1.1">
+  <Source>test</Source>
+  <Created>2020-01-01T00:00:00Z</Created>
+  <Network code="NZ">
+    <Station code="EYWM" startDate="2018-01-01T00:00:00Z">
+      <Channel code="LFX" locationCode="51" startDate="2018-01-01T00:00:00Z">
+        <Response>
+          <InstrumentSensitivity>
+            <Value>2.5E10</Value>
+            <Frequency>0</Frequency>
+            <InputUnits><Name>T</Name></InputUnits>
+            <OutputUnits><Name>count</Name></OutputUnits>
+          </InstrumentSensitivity>
+        </Response>
+      </Channel>
+      <Channel code="LKO" locationCode="50" startDate="2018-01-01T00:00:00Z">
+        <Response>
+          <InstrumentSensitivity>
+            <Value>100</Value>
+            <Frequency>0</Frequency>
+            <InputUnits><Name>degC</Name></InputUnits>
+            <OutputUnits><Name>count</Name></OutputUnits>
+          </InstrumentSensitivity>
+        </Response>
+      </Channel>
+    </Station>
+  </Network>
+</FDSNStationXML>
+`
+
+func TestDecode(t *testing.T) {
+	list, err := Decode(strings.NewReader(testStationXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 sensitivities got %d", len(list))
+	}
+
+	change := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, v := range []struct {
+		srcname string
+		at      time.Time
+		value   float64
+		ok      bool
+	}{
+		{"NZ_EYWM_51_LFZ", change.Add(-time.Second), 10, true},
+		{"NZ_EYWM_51_LFZ", change, 20, true},
+		{"NZ_EYWM_51_LFZ", change.AddDate(-5, 0, 0), 0, false},
+		{"NZ_EYWM__LKO", change, 0, false},
+	} {
+		s, ok := list.Find(v.srcname, v.at)
+		if ok != v.ok || s.Value != v.value {
+			t.Errorf("unexpected sensitivity for %s at %s: %+v (%v)", v.srcname, v.at, s, ok)
+		}
+	}
+
+	if s, _ := list.Find("NZ_EYWM_51_LFZ", change); s.InputUnits != "nT" || s.OutputUnits != "count" {
+		t.Errorf("unexpected sensitivity units: %+v", s)
+	}
+
+	if v, ok := list.Convert("NZ_EYWM_51_LFZ", change, 100); !ok || v != 5 {
+		t.Errorf("expected converted value 5 got %g (%v)", v, ok)
+	}
+	if v, ok := list.Convert("NZ_EYWM__LKO", change, 100); ok || v != 100 {
+		t.Errorf("expected unconverted value 100 got %g (%v)", v, ok)
+	}
+}
+
+func TestDecode_Tesla(t *testing.T) {
+	list, err := Decode(strings.NewReader(testTeslaStationXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// magnetic field sensitivities are converted to counts per nT
+	if s, ok := list.Find("NZ_EYWM_51_LFX", at); !ok || s.InputUnits != "nT" || s.Value != 25 {
+		t.Errorf("unexpected tesla sensitivity: %+v (%v)", s, ok)
+	}
+	if v, ok := list.Convert("NZ_EYWM_51_LFX", at, 100); !ok || v != 4 {
+		t.Errorf("expected converted value 4 nT got %g (%v)", v, ok)
+	}
+
+	// other units are left alone
+	if v, ok := list.Convert("NZ_EYWM_50_LKO", at, 2500); !ok || v != 25 {
+		t.Errorf("expected converted value 25 degC got %g (%v)", v, ok)
+	}
+}
+
+func TestService_Query(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stationxml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/fdsnws/station/1/query" || r.URL.Query().Get("level") != "response" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("station") != "EYWM" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(testStationXML))
+	}))
+
+	service := NewService(server.URL, time.Second)
+	service.Cache = dir
+
+	list, err := service.Query("NZ", "EYWM", "51", "LF?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || requests != 1 {
+		t.Fatalf("unexpected query result: %d sensitivities from %d requests", len(list), requests)
+	}
+
+	// the cached document should now be used
+	if list, err = service.Query("NZ", "EYWM", "51", "LF?"); err != nil || len(list) != 2 || requests != 1 {
+		t.Fatalf("expected cached query result: %d sensitivities from %d requests (%v)", len(list), requests, err)
+	}
+
+	if list, err = service.Query("NZ", "SMHS", "", "*"); err != nil || len(list) != 0 {
+		t.Errorf("expected empty query result: %d sensitivities (%v)", len(list), err)
+	}
+
+	// an expired cache falls back to the stale document when the service is unavailable
+	server.Close()
+	service.MaxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+
+	if list, err = service.Query("NZ", "EYWM", "51", "LF?"); err != nil || len(list) != 2 {
+		t.Errorf("expected stale cached query result: %d sensitivities (%v)", len(list), err)
+	}
+	if _, err = service.Query("NZ", "SMHS", "", "*"); err == nil {
+		t.Error("expected an error from an unavailable service")
+	}
+}
+
+func TestService_Memory(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("station") != "EYWM" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(testStationXML))
+	}))
+	defer server.Close()
+
+	// results are reused without a cache directory
+	service := NewService(server.URL, time.Second)
+	service.MaxAge = time.Hour
+
+	for i := 0; i < 3; i++ {
+		if list, err := service.Query("NZ", "EYWM", "51", "LF?"); err != nil || len(list) != 2 {
+			t.Fatalf("unexpected query result: %d sensitivities (%v)", len(list), err)
+		}
+		if list, err := service.Query("NZ", "SMHS", "", "*"); err != nil || len(list) != 0 {
+			t.Fatalf("expected empty query result: %d sensitivities (%v)", len(list), err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests got %d", requests)
+	}
+
+	// expired results are requested again
+	service.MaxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+
+	if _, err := service.Query("NZ", "EYWM", "51", "LF?"); err != nil || requests != 3 {
+		t.Errorf("expected an expired result to be requested again: %d requests (%v)", requests, err)
+	}
+}